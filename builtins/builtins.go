@@ -0,0 +1,369 @@
+// Package builtins is the standard library. Each builtin is declared once
+// with its type, the function the evaluator runs and the C the native
+// runtime defines it with, so the checker, the evaluator, the IL and the
+// x86 runtime agree on what is built in.
+package builtins
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/tcel/types"
+)
+
+// Builtin is a function every program may call. Native is the symbol of the
+// C function which implements it in the runtime, C is its definition.
+// Floats are passed to and returned from the runtime as the bits of a C
+// float in an int so they travel in the same registers as every other value.
+type Builtin struct {
+	Name   string
+	Type   *types.Function
+	Eval   func(args []interface{}) interface{}
+	Native string
+	C      string
+}
+
+// Stdin and Stdout are what the evaluator reads and prints with.
+var (
+	Stdin  io.Reader = os.Stdin
+	Stdout io.Writer = os.Stdout
+)
+
+// Builtins lists the builtins in the order they are declared.
+var Builtins []*Builtin
+
+// Lookup gives the builtin with the name or nil if there is none.
+func Lookup(name string) *Builtin {
+	for _, b := range Builtins {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// FormatFloat formats a float as the runtime's printf("%g") does. Compiled
+// floats are single precision so it is rounded to one first.
+func FormatFloat(f float64) string {
+	f = float64(float32(f))
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f) && math.Signbit(f):
+		return "-nan"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
+
+func fn(returns types.Type, params ...types.Type) *types.Function {
+	return &types.Function{Parameters: params, Returns: returns}
+}
+
+func add(b *Builtin) {
+	if b.Native == "" {
+		b.Native = b.Name
+	}
+	Builtins = append(Builtins, b)
+}
+
+// libm builds a builtin of float math backed by the C function of the same
+// name in libm.
+func libm(name string, f func(float64) float64) *Builtin {
+	return &Builtin{
+		Name:   name,
+		Type:   fn(types.Float, types.Float),
+		Eval:   func(args []interface{}) interface{} { return f(args[0].(float64)) },
+		Native: "tcel_" + name,
+		C: fmt.Sprintf(`
+int tcel_%v(int x) {
+	return from_float(%vf(to_float(x)));
+}
+`, name, name),
+	}
+}
+
+func init() {
+	add(&Builtin{
+		Name: "print_int",
+		Type: fn(types.Unit, types.Int),
+		Eval: func(args []interface{}) interface{} {
+			fmt.Fprintln(Stdout, args[0].(int64))
+			return types.Unit
+		},
+		C: `
+void print_int(int i) {
+	printf("%d\n", i);
+}
+`,
+	})
+	add(&Builtin{
+		Name: "print_float",
+		Type: fn(types.Unit, types.Float),
+		Eval: func(args []interface{}) interface{} {
+			fmt.Fprintln(Stdout, FormatFloat(args[0].(float64)))
+			return types.Unit
+		},
+		C: `
+void print_float(int f) {
+	printf("%g\n", to_float(f));
+}
+`,
+	})
+	add(&Builtin{
+		Name: "print",
+		Type: fn(types.Unit, types.String),
+		Eval: func(args []interface{}) interface{} {
+			fmt.Fprintln(Stdout, args[0].(string))
+			return types.Unit
+		},
+		C: `
+void print(char * msg) {
+	printf("%s\n", msg);
+}
+`,
+	})
+	add(&Builtin{
+		Name: "read_stdin_int",
+		Type: fn(types.Int, types.String),
+		Eval: func(args []interface{}) interface{} {
+			fmt.Fprintf(Stdout, "%v ", args[0].(string))
+			var read int64
+			if _, err := fmt.Fscan(stdin(), &read); err == io.EOF {
+				panic(fmt.Errorf("EOF on stdin read"))
+			} else if err != nil {
+				panic(fmt.Errorf("Could not read int from stdin"))
+			}
+			return read
+		},
+		C: `
+int read_stdin_int(char * msg) {
+	int read;
+	printf("%s ", msg);
+	int res = scanf("%d", &read);
+	if (res == EOF) {
+		int e = errno;
+		error(1, e, "EOF on stdin read\n");
+		return 0;
+	} else if (res == 0) {
+		error(1, EIO, "Could not read int from stdin\n");
+		return 0;
+	} else {
+		return read;
+	}
+}
+`,
+	})
+
+	elem := types.NewTypeVar("T")
+	add(&Builtin{
+		Name: "len",
+		Type: &types.Function{
+			TypeParams: []*types.TypeVar{elem},
+			Parameters: []types.Type{&types.Array{Base: elem}},
+			Returns:    types.Int,
+		},
+		Eval: func(args []interface{}) interface{} {
+			return int64(len(args[0].([]interface{})))
+		},
+		Native: "tcel_len",
+		C: `
+int tcel_len(int * array) {
+	/* the size in bytes then the length of the outermost dimension */
+	return array[1];
+}
+`,
+	})
+
+	add(&Builtin{
+		Name: "strlen",
+		Type: fn(types.Int, types.String),
+		Eval: func(args []interface{}) interface{} {
+			return int64(len(args[0].(string)))
+		},
+		Native: "tcel_strlen",
+		C: `
+int tcel_strlen(char * s) {
+	return strlen(s);
+}
+`,
+	})
+	add(&Builtin{
+		Name: "substr",
+		Type: fn(types.String, types.String, types.Int, types.Int),
+		Eval: func(args []interface{}) interface{} {
+			s, from, to := args[0].(string), args[1].(int64), args[2].(int64)
+			if from < 0 || to < from || to > int64(len(s)) {
+				panic(fmt.Errorf("substr(%q, %d, %d) is out of range", s, from, to))
+			}
+			return s[from:to]
+		},
+		Native: "tcel_substr",
+		C: `
+char * tcel_substr(char * s, int from, int to) {
+	int n = strlen(s);
+	if (from < 0 || to < from || to > n) {
+		error(1, ERANGE, "substr(\"%s\", %d, %d) is out of range\n", s, from, to);
+	}
+	char * sub = malloc(to - from + 1);
+	memcpy(sub, s + from, to - from);
+	sub[to - from] = '\0';
+	return sub;
+}
+`,
+	})
+	add(&Builtin{
+		Name: "int_to_string",
+		Type: fn(types.String, types.Int),
+		Eval: func(args []interface{}) interface{} {
+			return strconv.FormatInt(args[0].(int64), 10)
+		},
+		Native: "tcel_int_to_string",
+		C: `
+char * tcel_int_to_string(int i) {
+	char * s = malloc(12);
+	snprintf(s, 12, "%d", i);
+	return s;
+}
+`,
+	})
+	add(&Builtin{
+		Name: "float_to_string",
+		Type: fn(types.String, types.Float),
+		Eval: func(args []interface{}) interface{} {
+			return FormatFloat(args[0].(float64))
+		},
+		Native: "tcel_float_to_string",
+		C: `
+char * tcel_float_to_string(int f) {
+	char * s = malloc(32);
+	snprintf(s, 32, "%g", to_float(f));
+	return s;
+}
+`,
+	})
+	add(&Builtin{
+		Name: "string_to_int",
+		Type: fn(types.Int, types.String),
+		Eval: func(args []interface{}) interface{} {
+			i, err := strconv.ParseInt(strings.TrimSpace(args[0].(string)), 10, 64)
+			if err != nil {
+				panic(fmt.Errorf("Could not read an int from %q", args[0]))
+			}
+			return i
+		},
+		Native: "tcel_string_to_int",
+		C: `
+int tcel_string_to_int(char * s) {
+	int i;
+	if (sscanf(s, "%d", &i) != 1) {
+		error(1, EINVAL, "Could not read an int from \"%s\"\n", s);
+	}
+	return i;
+}
+`,
+	})
+	add(&Builtin{
+		Name: "string_to_float",
+		Type: fn(types.Float, types.String),
+		Eval: func(args []interface{}) interface{} {
+			f, err := strconv.ParseFloat(strings.TrimSpace(args[0].(string)), 64)
+			if err != nil {
+				panic(fmt.Errorf("Could not read a float from %q", args[0]))
+			}
+			return f
+		},
+		Native: "tcel_string_to_float",
+		C: `
+int tcel_string_to_float(char * s) {
+	float f;
+	if (sscanf(s, "%f", &f) != 1) {
+		error(1, EINVAL, "Could not read a float from \"%s\"\n", s);
+	}
+	return from_float(f);
+}
+`,
+	})
+	add(&Builtin{
+		Name: "int_to_float",
+		Type: fn(types.Float, types.Int),
+		Eval: func(args []interface{}) interface{} {
+			return float64(args[0].(int64))
+		},
+		Native: "tcel_int_to_float",
+		C: `
+int tcel_int_to_float(int i) {
+	return from_float((float)i);
+}
+`,
+	})
+	add(&Builtin{
+		Name: "float_to_int",
+		Type: fn(types.Int, types.Float),
+		Eval: func(args []interface{}) interface{} {
+			return int64(args[0].(float64))
+		},
+		Native: "tcel_float_to_int",
+		C: `
+int tcel_float_to_int(int f) {
+	return (int)to_float(f);
+}
+`,
+	})
+
+	add(libm("sqrt", math.Sqrt))
+	add(libm("floor", math.Floor))
+	add(libm("ceil", math.Ceil))
+	add(libm("sin", math.Sin))
+	add(libm("cos", math.Cos))
+	add(libm("exp", math.Exp))
+	add(libm("log", math.Log))
+	add(&Builtin{
+		Name: "abs",
+		Type: fn(types.Float, types.Float),
+		Eval: func(args []interface{}) interface{} {
+			return math.Abs(args[0].(float64))
+		},
+		Native: "tcel_abs",
+		C: `
+int tcel_abs(int x) {
+	return from_float(fabsf(to_float(x)));
+}
+`,
+	})
+	add(&Builtin{
+		Name: "pow",
+		Type: fn(types.Float, types.Float, types.Float),
+		Eval: func(args []interface{}) interface{} {
+			return math.Pow(args[0].(float64), args[1].(float64))
+		},
+		Native: "tcel_pow",
+		C: `
+int tcel_pow(int x, int y) {
+	return from_float(powf(to_float(x), to_float(y)));
+}
+`,
+	})
+}
+
+var reader *bufio.Reader
+var read_from io.Reader
+
+// stdin buffers Stdin, anew if it has been replaced.
+func stdin() *bufio.Reader {
+	if reader == nil || read_from != Stdin {
+		reader = bufio.NewReader(Stdin)
+		read_from = Stdin
+	}
+	return reader
+}
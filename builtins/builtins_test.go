@@ -0,0 +1,118 @@
+package builtins
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	runtime := Runtime()
+	seen := make(map[string]bool)
+	for _, b := range Builtins {
+		if seen[b.Name] {
+			t.Errorf("%v is declared twice", b.Name)
+		}
+		seen[b.Name] = true
+		if Lookup(b.Name) != b {
+			t.Errorf("could not look up %v", b.Name)
+		}
+		if b.Type == nil || b.Eval == nil {
+			t.Errorf("%v needs a type and an implementation", b.Name)
+		}
+		if !strings.Contains(runtime, " "+b.Native+"(") {
+			t.Errorf("the runtime does not define %v for %v", b.Native, b.Name)
+		}
+	}
+	if Lookup("no_such_builtin") != nil {
+		t.Errorf("expected no builtin")
+	}
+}
+
+func TestEval(t *testing.T) {
+	if got := Lookup("substr").Eval([]interface{}{"tcel", int64(1), int64(3)}); got != "ce" {
+		t.Errorf("expected ce got %v", got)
+	}
+	if got := Lookup("float_to_int").Eval([]interface{}{-2.7}); got != int64(-2) {
+		t.Errorf("expected float_to_int to truncate got %v", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected substr out of range to fail")
+		}
+	}()
+	Lookup("substr").Eval([]interface{}{"tcel", int64(3), int64(5)})
+}
+
+func TestRuntime(t *testing.T) {
+	runtime := Runtime()
+	for _, symbol := range []string{BoundsError, Alloc, Enter, Leave} {
+		if !strings.Contains(runtime, " "+symbol+"(") {
+			t.Errorf("the runtime does not define %v", symbol)
+		}
+	}
+}
+
+var floats = []float64{1.0 / 3.0, 0.1, 2.5, -7, 100000, 1e6, 1e-4, 1e-5, 16777217, 1e20, math.Inf(1), math.Inf(-1)}
+
+func TestFormatFloat(t *testing.T) {
+	expected := []string{"0.333333", "0.1", "2.5", "-7", "100000", "1e+06", "0.0001", "1e-05", "1.67772e+07", "1e+20", "inf", "-inf"}
+	for i, f := range floats {
+		if got := FormatFloat(f); got != expected[i] {
+			t.Errorf("expected %v to format as %v got %v", f, expected[i], got)
+		}
+	}
+}
+
+// TestFloatsNatively runs print_float and float_to_string of the runtime
+// with the C compiler of the host on the floats the evaluator formats.
+func TestFloatsNatively(t *testing.T) {
+	gcc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("not comparing with the runtime, there is no gcc")
+	}
+	dir, err := ioutil.TempDir("", "tcel-builtins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var src bytes.Buffer
+	src.WriteString(prelude)
+	src.WriteString(Lookup("print_float").C)
+	src.WriteString(Lookup("float_to_string").C)
+	src.WriteString("int main() {\n")
+	var expected bytes.Buffer
+	old := Stdout
+	Stdout = &expected
+	defer func() {
+		Stdout = old
+	}()
+	for _, f := range floats {
+		bits := int32(math.Float32bits(float32(f)))
+		fmt.Fprintf(&src, "\tprint_float(%d);\n", bits)
+		fmt.Fprintf(&src, "\tprintf(\"%%s\\n\", %v(%d));\n", Lookup("float_to_string").Native, bits)
+		Lookup("print_float").Eval([]interface{}{f})
+		fmt.Fprintln(&expected, Lookup("float_to_string").Eval([]interface{}{f}))
+	}
+	src.WriteString("\treturn 0;\n}\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "floats.c"), src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "floats")
+	if out, err := exec.Command(gcc, "-o", bin, filepath.Join(dir, "floats.c"), "-lm").CombinedOutput(); err != nil {
+		t.Fatalf("could not compile the runtime's floats: %v\n%s", err, out)
+	}
+	got, err := exec.Command(bin).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected.String() {
+		t.Errorf("expected the runtime to print\n%v\ngot\n%s", expected.String(), got)
+	}
+}
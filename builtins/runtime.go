@@ -0,0 +1,193 @@
+package builtins
+
+import (
+	"strings"
+)
+
+// The symbols of the runtime which compiled code calls besides the
+// builtins.
+const (
+	// BoundsError is called when an index is out of bounds with where the
+	// index is, the index and the length of the array. It stops the program.
+	BoundsError = "tcel_bounds_error"
+	// Alloc allocates an object, it is given the object's size in bytes and
+	// its layout: the count of words after the size which are laid out one
+	// by one, whether the elements after those are pointers, then whether
+	// each of those words is a pointer.
+	Alloc = "tcel_alloc"
+	// Enter records the frame of a function being called on the shadow
+	// stack with its stack map: the count of the registers holding pointers
+	// then their offsets from the frame pointer.
+	Enter = "tcel_gc_enter"
+	// Leave removes the frame of the returning function from the shadow
+	// stack.
+	Leave = "tcel_gc_leave"
+)
+
+const prelude = `
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <math.h>
+#include <error.h>
+#include <errno.h>
+
+static float to_float(int bits) {
+	union { int bits; float f; } u;
+	u.bits = bits;
+	return u.f;
+}
+
+static int from_float(float f) {
+	union { int bits; float f; } u;
+	u.f = f;
+	return u.bits;
+}
+
+void tcel_bounds_error(char * at, int index, int length) {
+	error(1, ERANGE, "index %d out of bounds for an array of length %d at %s", index, length, at);
+}
+`
+
+// collector is a mark and sweep garbage collector. The roots are the
+// registers holding pointers of the functions being run, found with the
+// shadow stack. Objects are traced with their layouts. Strings made by the
+// builtins are not collected.
+const collector = `
+struct layout {
+	int words;
+	int elems;
+	int pointers[];
+};
+
+struct header {
+	struct header * next;
+	struct layout * layout;
+	int marked;
+};
+
+struct frame {
+	char * fp;
+	int * map;
+};
+
+static struct header * objects = NULL;
+static struct frame * frames = NULL;
+static int nframes = 0, frames_cap = 0;
+static int ** gray = NULL;
+static int ngray = 0, gray_cap = 0;
+static int allocated = 0;
+static int threshold = 1 << 20;
+static int stress = -1;
+
+static void * grow(void * buf, int * cap, int size) {
+	*cap = *cap ? 2 * *cap : 256;
+	buf = realloc(buf, *cap * size);
+	if (buf == NULL) {
+		error(1, ENOMEM, "out of memory\n");
+	}
+	return buf;
+}
+
+void tcel_gc_enter(char * fp, int * map) {
+	if (nframes == frames_cap) {
+		frames = grow(frames, &frames_cap, sizeof(struct frame));
+	}
+	frames[nframes].fp = fp;
+	frames[nframes].map = map;
+	nframes++;
+}
+
+void tcel_gc_leave(void) {
+	nframes--;
+}
+
+static struct header * header_of(int * obj) {
+	return ((struct header *)obj) - 1;
+}
+
+static void shade(int * obj) {
+	if (obj == NULL || header_of(obj)->marked) {
+		return;
+	}
+	header_of(obj)->marked = 1;
+	if (ngray == gray_cap) {
+		gray = grow(gray, &gray_cap, sizeof(int *));
+	}
+	gray[ngray++] = obj;
+}
+
+static void mark(void) {
+	for (int f = 0; f < nframes; f++) {
+		int * map = frames[f].map;
+		for (int i = 1; i <= map[0]; i++) {
+			shade(*(int **)(frames[f].fp + map[i]));
+		}
+	}
+	while (ngray > 0) {
+		int * obj = gray[--ngray];
+		struct layout * layout = header_of(obj)->layout;
+		int words = obj[0] / 4;
+		for (int i = 1; i < words; i++) {
+			int pointer = i <= layout->words ? layout->pointers[i-1] : layout->elems;
+			if (pointer) {
+				shade((int *)obj[i]);
+			}
+		}
+	}
+}
+
+static int sweep(void) {
+	int live = 0;
+	struct header ** link = &objects;
+	while (*link != NULL) {
+		struct header * h = *link;
+		if (h->marked) {
+			h->marked = 0;
+			live += ((int *)(h + 1))[0];
+			link = &h->next;
+		} else {
+			*link = h->next;
+			free(h);
+		}
+	}
+	return live;
+}
+
+void tcel_gc_collect(void) {
+	mark();
+	int live = sweep();
+	allocated = 0;
+	threshold = 2 * live > (1 << 20) ? 2 * live : 1 << 20;
+}
+
+int * tcel_alloc(int size, struct layout * layout) {
+	if (stress < 0) {
+		stress = getenv("TCEL_GC_STRESS") != NULL;
+	}
+	if (stress || allocated + size > threshold) {
+		tcel_gc_collect();
+	}
+	struct header * h = calloc(1, sizeof(struct header) + size);
+	if (h == NULL) {
+		error(1, ENOMEM, "out of memory\n");
+	}
+	h->next = objects;
+	h->layout = layout;
+	objects = h;
+	allocated += size;
+	int * obj = (int *)(h + 1);
+	obj[0] = size;
+	return obj;
+}
+`
+
+// Runtime gives the C source of the native runtime: the collector and a
+// definition for every builtin.
+func Runtime() string {
+	parts := []string{prelude, collector}
+	for _, b := range Builtins {
+		parts = append(parts, b.C)
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,378 @@
+// Package cache keeps what the compiler made of each module in a directory
+// so a module which has not changed is not parsed or type checked again,
+// much as the Go build cache does. Each entry is keyed by a hash of the
+// version of the compiler and of what the entry was made from:
+//
+//   - the tree of a module by its path and source,
+//   - the typed tree of a module by its path, source and the keys of the
+//     typed trees of the modules it imports,
+//   - what is generated for a program, its IL or assembly, by the keys of
+//     the typed trees of all its modules.
+//
+// A change gives new keys so entries are never invalidated, only left
+// unused. The IL is kept for the whole program rather than each module as
+// the functions of a program are numbered across its modules.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+import (
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/types"
+)
+
+// Cache is a directory of entries made by the compiler whose version is
+// Version.
+type Cache struct {
+	Dir     string
+	Version string
+	sums    map[string]string // the hash of the source of each module by path
+	keys    map[string]string // the key of the typed tree of each module by path
+}
+
+// Dir gives the directory of the cache, TCEL_CACHE if it is set otherwise
+// tcel in the user's cache directory. It is "" when TCEL_CACHE is off.
+func Dir() (string, error) {
+	if dir := os.Getenv("TCEL_CACHE"); dir == "off" {
+		return "", nil
+	} else if dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tcel"), nil
+}
+
+// New opens the cache in dir, making the directory if need be, for the
+// running compiler.
+func New(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		Dir:  dir,
+		sums: make(map[string]string),
+		keys: make(map[string]string),
+	}
+	version, err := c.version()
+	if err != nil {
+		return nil, err
+	}
+	c.Version = version
+	return c, nil
+}
+
+// version gives Version, kept in the cache by the path, size and time of
+// modification of the executable so it is hashed once rather than on every
+// run.
+func (self *Cache) version() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := self.key("version", path, fmt.Sprint(info.Size()), fmt.Sprint(info.ModTime().UnixNano()))
+	if data, has := self.get(key); has {
+		return string(data), nil
+	}
+	version, err := Version()
+	if err != nil {
+		return "", err
+	}
+	if err := self.put(key, []byte(version)); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// Version identifies the running compiler by a hash of its executable, so
+// rebuilding the compiler gives new keys.
+func Version() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Parser wraps parse, which gives the tree of the file at a path, so that
+// a file whose source is in the cache is not parsed again. It is meant for
+// the Parse of a loader.Loader.
+func (self *Cache) Parser(parse func(path string) (*frontend.Node, error)) func(path string) (*frontend.Node, error) {
+	return func(path string) (*frontend.Node, error) {
+		sum, err := self.sum(path)
+		if err != nil {
+			return nil, err
+		}
+		key := self.key("ast", path, sum)
+		if data, has := self.get(key); has {
+			if node, err := frontend.ReadBinary(bytes.NewReader(data)); err == nil {
+				return node, nil
+			}
+		}
+		node, err := parse(path)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := node.WriteBinary(&buf); err != nil {
+			return nil, err
+		}
+		if err := self.put(key, buf.Bytes()); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+}
+
+// typed is the entry of a checked module. The type of a module only encodes
+// its identity so its members are kept beside its tree.
+type typed struct {
+	Tree   []byte // the Module node written with WriteBinary
+	Values map[string]*types.Encoding
+	Types  map[string]*types.Encoding
+}
+
+// Check type checks the program, a Stmts node of the Modules given by the
+// loader, taking the typed tree of a module from the cache when its source
+// and the modules it imports are as they were when it was put there. The
+// modules which were checked are put in the cache and their paths given.
+func (self *Cache) Check(program *frontend.Node) (checked []string, err error) {
+	fresh := make(map[string]bool)
+	for i, mod := range program.Children {
+		path := mod.Value.(string)
+		sum, err := self.sum(path)
+		if err != nil {
+			return nil, err
+		}
+		parts := []string{path, sum}
+		// the types of a module checked again may not be those of its
+		// cached importers so they are checked again too
+		stale := false
+		for _, imported := range imports(mod) {
+			parts = append(parts, self.keys[imported])
+			stale = stale || fresh[imported]
+		}
+		key := self.key("typed", parts...)
+		self.keys[path] = key
+		if !stale {
+			if cached, err := self.module(key); err == nil {
+				program.Children[i] = cached
+				continue
+			}
+		}
+		fresh[path] = true
+		checked = append(checked, path)
+	}
+	if err := checker.Check(program); err != nil {
+		return checked, err
+	}
+	for _, mod := range program.Children {
+		path := mod.Value.(string)
+		if !fresh[path] {
+			continue
+		}
+		data, err := encodeModule(mod)
+		if err != nil {
+			return checked, err
+		}
+		if err := self.put(self.keys[path], data); err != nil {
+			return checked, err
+		}
+	}
+	return checked, nil
+}
+
+// Generate gives what gen generates for the program, which was checked
+// with Check, from the cache if gen was run for the stage on the same
+// modules before.
+func (self *Cache) Generate(stage string, program *frontend.Node, gen func() (string, error)) (string, error) {
+	parts := make([]string, 0, len(program.Children))
+	for _, mod := range program.Children {
+		key, has := self.keys[mod.Value.(string)]
+		if !has {
+			return "", fmt.Errorf("the module %v was not checked with the cache", mod.Value)
+		}
+		parts = append(parts, key)
+	}
+	key := self.key(stage, parts...)
+	if data, has := self.get(key); has {
+		return string(data), nil
+	}
+	out, err := gen()
+	if err != nil {
+		return "", err
+	}
+	if err := self.put(key, []byte(out)); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// imports gives the paths of the modules mod imports.
+func imports(mod *frontend.Node) (paths []string) {
+	for _, stmt := range mod.Get(1).Children {
+		if path, is := stmt.Value.(string); stmt.Label == "Import" && is {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func encodeModule(mod *frontend.Node) ([]byte, error) {
+	t, is := mod.Get(0).Type.(*types.Module)
+	if !is {
+		return nil, fmt.Errorf("the module %v is not typed", mod.Value)
+	}
+	entry := &typed{
+		Values: make(map[string]*types.Encoding, len(t.Values)),
+		Types:  make(map[string]*types.Encoding, len(t.Types)),
+	}
+	for name, v := range t.Values {
+		entry.Values[name] = types.Encode(v)
+	}
+	for name, v := range t.Types {
+		entry.Types[name] = types.Encode(v)
+	}
+	var tree bytes.Buffer
+	if err := mod.WriteBinary(&tree); err != nil {
+		return nil, err
+	}
+	entry.Tree = tree.Bytes()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// module reads the typed Module node under key.
+func (self *Cache) module(key string) (*frontend.Node, error) {
+	data, has := self.get(key)
+	if !has {
+		return nil, fmt.Errorf("%v is not in the cache", key)
+	}
+	var entry typed
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+	mod, err := frontend.ReadBinary(bytes.NewReader(entry.Tree))
+	if err != nil {
+		return nil, err
+	}
+	if len(mod.Children) != 2 {
+		return nil, fmt.Errorf("expected a module got %v", mod.Serialize(false))
+	}
+	t, is := mod.Get(0).Type.(*types.Module)
+	if !is {
+		return nil, fmt.Errorf("the module %v is not typed", mod.Value)
+	}
+	t.Values = make(map[string]types.Type, len(entry.Values))
+	t.Types = make(map[string]types.Type, len(entry.Types))
+	d := types.NewDecoder()
+	for name, e := range entry.Values {
+		if t.Values[name], err = d.Decode(e); err != nil {
+			return nil, err
+		}
+	}
+	for name, e := range entry.Types {
+		if t.Types[name], err = d.Decode(e); err != nil {
+			return nil, err
+		}
+	}
+	if stmts := mod.Get(1); len(stmts.Children) > 0 && stmts.Get(0).Label == "ModuleDecl" {
+		// the module is known by the name it declares, the same node
+		decl := stmts.Get(0).Get(0)
+		decl.Type = t
+		mod.Children[0] = decl
+	}
+	return mod, nil
+}
+
+// sum gives the hash of the source of the file at path. It is computed once
+// so every key of a run uses the source which was parsed.
+func (self *Cache) sum(path string) (string, error) {
+	if sum, has := self.sums[path]; has {
+		return sum, nil
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(src)
+	sum := hex.EncodeToString(h[:])
+	self.sums[path] = sum
+	return sum, nil
+}
+
+func (self *Cache) key(kind string, parts ...string) string {
+	h := sha256.New()
+	for _, part := range append([]string{kind, self.Version}, parts...) {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (self *Cache) path(key string) string {
+	return filepath.Join(self.Dir, key[:2], key)
+}
+
+func (self *Cache) get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(self.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// put writes the entry to a temporary file renamed into place so another
+// compiler never reads half an entry.
+func (self *Cache) put(key string, data []byte) error {
+	path := self.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/il"
+	"github.com/timtadh/tcel/loader"
+)
+
+var geo = `module geo
+
+type Point record { x int, y int }
+
+square = fn(x int) int { x * x }
+id = fn(x) { x }
+Origin = Point(record { x: 0, y: 0 })
+Norm = fn(p Point) int { id(square(p.x)) + square(p.y) }
+`
+
+var main = `import "geo"
+f = fn(q geo.Point) int { geo.Norm(q) }
+f(geo.Origin) + f(geo.Point(record { x: 3, y: 4 }))
+`
+
+func write(t *testing.T, dir, name, src string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// run compiles main.x in dir as the compiler would with a cache in
+// cacheDir. It gives the files which were parsed, the modules which were
+// checked, the last value of the program and its IL.
+func run(t *testing.T, dir, cacheDir string) (parsed, checked []string, value string, fns string) {
+	c, err := New(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &loader.Loader{
+		Parse: c.Parser(func(path string) (*frontend.Node, error) {
+			parsed = append(parsed, filepath.Base(path))
+			return loader.ParseFile(path)
+		}),
+	}
+	program, err := l.Load(filepath.Join(dir, "main.x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked, err = c.Check(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range checked {
+		checked[i] = filepath.Base(checked[i])
+	}
+	values, err := evaluator.Evaluate(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns, err = c.Generate("il", program, func() (string, error) {
+		fns, err := il.Generate(program)
+		if err != nil {
+			return "", err
+		}
+		return fns.String(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed, checked, fmt.Sprint(values[len(values)-1]), fns
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcel-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	write(t, dir, "geo.x", geo)
+	write(t, dir, "main.x", main)
+
+	parsed, checked, value, expected := run(t, dir, cacheDir)
+	if all := []string{"main.x", "geo.x"}; !reflect.DeepEqual(parsed, all) {
+		t.Errorf("expected %v to be parsed got %v", all, parsed)
+	}
+	if all := []string{"geo.x", "main.x"}; !reflect.DeepEqual(checked, all) {
+		t.Errorf("expected %v to be checked got %v", all, checked)
+	}
+	if value != "25" {
+		t.Errorf("expected 25 got %v", value)
+	}
+
+	parsed, checked, value, fns := run(t, dir, cacheDir)
+	if len(parsed) != 0 || len(checked) != 0 {
+		t.Errorf("expected nothing to be parsed or checked got %v and %v", parsed, checked)
+	}
+	if value != "25" {
+		t.Errorf("expected 25 from the cached modules got %v", value)
+	}
+	if fns != expected {
+		t.Errorf("expected the cached IL\n%v\ngot\n%v", expected, fns)
+	}
+
+	// only the importer changed
+	write(t, dir, "main.x", main+"f(geo.Point(record { x: 1, y: 1 }))\n")
+	parsed, checked, value, _ = run(t, dir, cacheDir)
+	if only := []string{"main.x"}; !reflect.DeepEqual(parsed, only) || !reflect.DeepEqual(checked, only) {
+		t.Errorf("expected only %v to be parsed and checked got %v and %v", only, parsed, checked)
+	}
+	if value != "2" {
+		t.Errorf("expected 2 got %v", value)
+	}
+
+	// the imported module changed so its importer is checked again
+	write(t, dir, "geo.x", geo+"Unit = Point(record { x: 1, y: 0 })\n")
+	parsed, checked, value, _ = run(t, dir, cacheDir)
+	if only := []string{"geo.x"}; !reflect.DeepEqual(parsed, only) {
+		t.Errorf("expected only %v to be parsed got %v", only, parsed)
+	}
+	if all := []string{"geo.x", "main.x"}; !reflect.DeepEqual(checked, all) {
+		t.Errorf("expected %v to be checked got %v", all, checked)
+	}
+	if value != "2" {
+		t.Errorf("expected 2 got %v", value)
+	}
+}
+
+// TestCachedGeneric checks the type variables of a cached generic function
+// are still substituted when it is instantiated, which needs each to be one
+// type in the tree read back.
+func TestCachedGeneric(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcel-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	write(t, dir, "main.x", `pair = fn(a, b) { (b, a) }
+(x, y) = pair(1, "one")
+strlen(x) + y`)
+	_, _, _, expected := run(t, dir, cacheDir)
+	c, err := New(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := loader.Load(filepath.Join(dir, "main.x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked, err := c.Check(program); err != nil || len(checked) != 0 {
+		t.Fatalf("expected the module from the cache got %v %v", checked, err)
+	}
+	fns, err := il.Generate(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fns.String() != expected {
+		t.Errorf("expected the IL of the checked program\n%v\ngot\n%v", expected, fns)
+	}
+}
+
+// TestCachedDefinitions checks the uses of the members of a module read
+// from the cache still refer to their definitions.
+func TestCachedDefinitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcel-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	write(t, dir, "geo.x", geo)
+	write(t, dir, "main.x", main)
+	run(t, dir, cacheDir)
+	c, err := New(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := loader.Load(filepath.Join(dir, "main.x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked, err := c.Check(program); err != nil || len(checked) != 0 {
+		t.Fatalf("expected the modules from the cache got %v %v", checked, err)
+	}
+	// main.x as it is being edited with geo.x from the cache
+	edited, err := loader.Load(filepath.Join(dir, "main.x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, mod := range edited.Children {
+		if filepath.Base(mod.Value.(string)) == "geo.x" {
+			edited.Children[i] = program.Children[i]
+		}
+	}
+	info, err := checker.Analyze(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for use, def := range info.Uses {
+		loc := use.Location()
+		if loc == nil || filepath.Base(loc.Filename) != "main.x" {
+			continue
+		}
+		if loc := def.Location(); loc != nil && filepath.Base(loc.Filename) == "geo.x" && def.Value == use.Value {
+			found[use.Value.(string)] = true
+		}
+	}
+	for _, name := range []string{"Norm", "Origin"} {
+		if !found[name] {
+			t.Errorf("expected geo.%v to refer to its definition in geo.x", name)
+		}
+	}
+}
+
+// TestVersion checks the hash of the executable is kept in the cache rather
+// than computed on every run.
+func TestVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcel-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != version {
+		t.Fatalf("expected version %v got %v", version, c.Version)
+	}
+	// the only entry is the version, one which is read back is not hashed
+	// again
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return ioutil.WriteFile(path, []byte("kept"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != "kept" {
+		t.Errorf("expected the kept version got %v", c.Version)
+	}
+}
@@ -3,9 +3,12 @@ package checker
 import (
 	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 import (
+	"github.com/timtadh/tcel/builtins"
 	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/types"
 	"github.com/timtadh/tcel/table"
@@ -21,7 +24,43 @@ func (self Errors) Error() string {
 	return "[" + strings.Join(errs, ", ") + "]"
 }
 
+// Error is a type error tied to the node it was found at.
+type Error struct {
+	Msg string
+	Node *frontend.Node
+}
+
+func errorf(node *frontend.Node, format string, args ...interface{}) error {
+	return &Error{Msg: fmt.Sprintf(format, args...), Node: node}
+}
+
+func (self *Error) Error() string {
+	return self.Msg
+}
+
+func (self *Error) Location() *frontend.SourceLocation {
+	return self.Node.Location()
+}
+
+// Info records how the names in a program were resolved. Uses maps every
+// NAME node which refers to a symbol to the NAME (or Func, for self) node
+// which bound it. Defs lists the binding nodes in program order.
+type Info struct {
+	Uses map[*frontend.Node]*frontend.Node
+	Defs []*frontend.Node
+}
+
+// arith defaults the type of an operand of an arithmetic or comparison op,
+// if it is still to be inferred, to int.
+func arith(t types.Type) {
+	if _, unknown := types.Resolve(t).(*types.Meta); unknown {
+		types.Equate(t, types.Int)
+	}
+}
+
+// matches reports whether the structure of a is one of ts.
 func matches(a types.Type, ts ...types.Type) bool {
+	a = types.Underlying(a)
 	for _, t := range ts {
 		if a.Equals(t) {
 			return true
@@ -31,50 +70,74 @@ func matches(a types.Type, ts ...types.Type) bool {
 }
 
 func Check(node *frontend.Node) error {
+	_, err := Analyze(node)
+	return err
+}
+
+// Analyze type checks the program and reports how its names were resolved.
+// The Info is returned even when there are errors so tools can work with
+// partially typed programs.
+func Analyze(node *frontend.Node) (*Info, error) {
 	c := newChecker()
 	errors := c.Stmts(node)
 	if len(errors) == 0 {
-		return nil
+		for _, stmt := range node.Children {
+			errors = append(errors, c.zonk(stmt)...)
+		}
 	}
-	return errors
+	if len(errors) == 0 {
+		node.Type = types.Zonk(node.Type)
+		return c.info, nil
+	}
+	return c.info, errors
 }
 
 type checker struct {
 	syms   *table.SymbolTable
 	types  *table.SymbolTable
+	defs   *table.SymbolTable
 	fn     *types.Function
+	info   *Info
+	top    int // the depth of the top level scope
+	path   string // of the module being checked
+	modules map[string]*module // by path
+}
+
+// module is a checked module with the nodes which define its names.
+type module struct {
+	typ  *types.Module
+	defs map[string]interface{}
 }
 
 func newChecker() *checker {
 	c := &checker{
 		syms:  table.NewSymbolTable(),
 		types: table.NewSymbolTable(),
+		defs:  table.NewSymbolTable(),
+		info:  &Info{Uses: make(map[*frontend.Node]*frontend.Node)},
+		modules: make(map[string]*module),
 	}
 	for _, p := range types.Primatives {
 		c.types.Put(string(p), p)
 	}
 	c.syms.Put("unit", types.Unit)
-	c.syms.Put("print_int", &types.Function{
-		Parameters: []types.Type{ types.Type(types.Int) },
-		Returns: types.Unit,
-	})
-	c.syms.Put("read_stdin_int", &types.Function{
-		Parameters: []types.Type{ types.Type(types.String) },
-		Returns: types.Int,
-	})
-	c.syms.Put("print", &types.Function{
-		Parameters: []types.Type{ types.Type(types.String) },
-		Returns: types.Unit,
-	})
+	for _, b := range builtins.Builtins {
+		c.syms.Put(b.Name, b.Type)
+	}
+	c.top = c.syms.Depth()
 	return c
 }
 
 func (c *checker) Push() {
 	c.syms.Push()
 	c.types.Push()
+	c.defs.Push()
 }
 
 func (c *checker) Pop() {
+	if err := c.defs.Pop(); err != nil {
+		panic(err)
+	}
 	if err := c.types.Pop(); err != nil {
 		panic(err)
 	}
@@ -83,6 +146,18 @@ func (c *checker) Pop() {
 	}
 }
 
+func (c *checker) define(name string, t types.Type, def *frontend.Node) {
+	c.syms.Put(name, t)
+	c.defs.Put(name, def)
+	c.info.Defs = append(c.info.Defs, def)
+}
+
+func (c *checker) use(name string, node *frontend.Node) {
+	if def := c.defs.Get(name); def != nil {
+		c.info.Uses[node] = def.(*frontend.Node)
+	}
+}
+
 func (c *checker) Stmts(node *frontend.Node) (errors Errors) {
 	if node.Label != "Stmts" {
 		panic("expected a stmts node")
@@ -90,7 +165,7 @@ func (c *checker) Stmts(node *frontend.Node) (errors Errors) {
 	for _, stmt := range node.Children {
 		errors = append(errors, c.Stmt(stmt)...)
 		if stmt.Type == nil {
-			errors = append(errors, fmt.Errorf("Stmt not well typed : %v", stmt.Serialize(true)))
+			errors = append(errors, errorf(stmt, "Stmt not well typed : %v", stmt.Serialize(true)))
 		}
 	}
 	if len(errors) == 0 {
@@ -103,15 +178,246 @@ func (c *checker) Stmt(node *frontend.Node) (errors Errors) {
 	switch node.Label {
 	case "Assign":
 		return c.Assign(node)
+	case "TypeAlias", "TypeDecl":
+		return c.TypeDecl(node)
+	case "Module":
+		return c.Module(node)
+	case "ModuleDecl":
+		if c.syms.Depth() != c.top {
+			return append(errors, errorf(node, "a module is declared at the top of its file"))
+		}
+		node.Type = types.Unit
+		return errors
+	case "Import":
+		return c.Import(node)
 	default:
 		return c.Expr(node)
 	}
+}
+
+// exported reports whether the name of a value or type is visible outside
+// of its module, it is if it begins with an upper case letter.
+func exported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// Module checks the statements of a module in a scope of their own. The
+// modules it imports have been checked before it. A module which has been
+// checked, by an earlier Check or before it was cached, is not checked again.
+func (c *checker) Module(node *frontend.Node) (errors Errors) {
+	name := node.Get(0)
+	path := node.Value.(string)
+	if mod, is := name.Type.(*types.Module); is && mod.Values != nil && node.Get(1).Type != nil {
+		c.modules[path] = &module{typ: mod, defs: declarations(node.Get(1))}
+		node.Type = types.Unit
+		return errors
+	}
+	mod := &types.Module{
+		Name:   name.Value.(string),
+		Path:   path,
+		Values: make(map[string]types.Type),
+		Types:  make(map[string]types.Type),
+	}
+
+	c.Push()
+	defer c.Pop()
+	old_top, old_path := c.top, c.path
+	c.top, c.path = c.syms.Depth(), path
+	defer func() {
+		c.top, c.path = old_top, old_path
+	}()
+
+	errors = c.Stmts(node.Get(1))
+	for n, t := range c.syms.Top() {
+		if _, is := t.(*types.Module); exported(n) && !is {
+			mod.Values[n] = t.(types.Type)
+		}
+	}
+	for n, t := range c.types.Top() {
+		if exported(n) {
+			mod.Types[n] = t.(types.Type)
+		}
+	}
+	c.modules[path] = &module{typ: mod, defs: c.defs.Top()}
+	name.Type = mod
+	// the statements of the module report their own errors
+	node.Type = types.Unit
+	return errors
+}
+
+// declarations gives the node which binds each name at the top of a module
+// which was checked before, as define would have recorded them.
+func declarations(stmts *frontend.Node) map[string]interface{} {
+	defs := make(map[string]interface{})
+	bind := func(n *frontend.Node, name string) {
+		if _, has := defs[name]; !has {
+			defs[name] = n
+		}
+	}
+	for _, stmt := range stmts.Children {
+		switch stmt.Label {
+		case "Assign":
+			if target := stmt.Get(0); target.Label == "NAME" {
+				bind(target, target.Value.(string))
+			} else if target.Label == "Targets" {
+				for _, kid := range target.Children {
+					if kid.Label == "NAME" {
+						bind(kid, kid.Value.(string))
+					}
+				}
+			}
+		case "TypeAlias", "TypeDecl":
+			if stmt.Get(1).Label == "Union" {
+				for _, variant := range stmt.Get(1).Children {
+					bind(variant.Get(0), variant.Get(0).Value.(string))
+				}
+			}
+		case "Import":
+			if mod, is := stmt.Get(0).Type.(*types.Module); is {
+				bind(stmt, mod.Name)
+			}
+		}
+	}
+	return defs
+}
+
+// Import binds the name of the imported module. The loader records the
+// path of the module as the Value of the Import.
+func (c *checker) Import(node *frontend.Node) (errors Errors) {
+	if c.syms.Depth() != c.top {
+		return append(errors, errorf(node, "imports must be at the top level of a module"))
+	}
+	path, ok := node.Value.(string)
+	if !ok {
+		return append(errors, errorf(node, "the import of %v was not loaded", node.Get(0).Value))
+	}
+	mod, has := c.modules[path]
+	if !has {
+		return append(errors, errorf(node, "the module at %v was not checked before its import", path))
+	}
+	name := mod.typ.Name
+	if c.syms.TopHas(name) {
+		return append(errors, errorf(node, "symbol, %v, declared more than once", name))
+	}
+	node.Get(0).Type = mod.typ
+	c.define(name, mod.typ, node)
+	node.Type = types.Unit
+	return errors
+}
+
+// imported gives the module the NAME node refers to, if it does.
+func (c *checker) imported(node *frontend.Node) *types.Module {
+	if node.Label != "NAME" {
+		return nil
+	}
+	if t, is := c.syms.Get(node.Value.(string)).(*types.Module); is {
+		return t
+	}
 	return nil
 }
 
+// member types m.f, the use of an exported value of an imported module.
+func (c *checker) member(node *frontend.Node, mod *types.Module) (errors Errors) {
+	c.TrySymbol(node.Get(0))
+	n := node.Get(1)
+	name, errors := c.NAME(n)
+	if len(errors) > 0 {
+		return errors
+	}
+	t, has := mod.Values[name]
+	if !has {
+		if exported(name) {
+			return append(errors, errorf(n, "module %v has no value, %v", mod.Name, name))
+		}
+		return append(errors, errorf(n, "%v is not exported by module %v", name, mod.Name))
+	}
+	if def, has := c.modules[mod.Path].defs[name]; has {
+		c.info.Uses[n] = def.(*frontend.Node)
+	}
+	n.Type = t
+	node.Type = t
+	return errors
+}
+
+// TypeDecl declares a type name in the current scope. An alias names the
+// type itself, otherwise the name is a new Named type with the same
+// structure.
+func (c *checker) TypeDecl(node *frontend.Node) (errors Errors) {
+	n := node.Get(0)
+	name, errors := c.NAME(n)
+	if len(errors) > 0 {
+		return errors
+	}
+	if c.types.TopHas(name) {
+		return append(errors, errorf(n, "type, %v, declared more than once", name))
+	}
+	var named *types.Named
+	if node.Label == "TypeDecl" {
+		// the named type is declared before it is defined so it may refer to
+		// itself. checking the tree again must give the same type
+		if prev, is := n.Type.(*types.Named); is && prev.Name == name {
+			named = prev
+		} else {
+			named = types.NewNamed(name, nil)
+			named.Path = c.path
+		}
+		c.types.Put(name, named)
+	}
+	t, errors := c.Type(node.Get(1))
+	if len(errors) > 0 {
+		return errors
+	}
+	if named != nil {
+		for u := t; ; {
+			inner, is := u.(*types.Named)
+			if !is {
+				break
+			} else if inner == named {
+				return append(errors, errorf(node, "type, %v, is defined as itself", name))
+			}
+			u = inner.Type
+		}
+		named.Type = t
+		t = named
+	}
+	c.types.Put(name, t)
+	n.Type = t
+	if node.Get(1).Label == "Union" {
+		errors = c.constructors(node.Get(1), t)
+	}
+	node.Type = types.Unit
+	return errors
+}
+
+// constructors defines a symbol for each variant of the union which makes a
+// t. A variant with fields is constructed by calling it with them.
+func (c *checker) constructors(node *frontend.Node, t types.Type) (errors Errors) {
+	union := types.Underlying(t).(*types.Union)
+	for i, kid := range node.Children {
+		n := kid.Get(0)
+		v := union.Variants[i]
+		if c.syms.TopHas(v.Name) {
+			errors = append(errors, errorf(n, "symbol, %v, declared more than once", v.Name))
+			continue
+		}
+		var ctor types.Type = t
+		if len(v.Fields) > 0 {
+			ctor = &types.Function{Parameters: v.Fields, Returns: t}
+		}
+		n.Type = ctor
+		kid.Type = ctor
+		c.define(v.Name, ctor, n)
+	}
+	return errors
+}
+
 func (c *checker) Assign(node *frontend.Node) (errors Errors) {
 	name := node.Get(0)
 	expr := node.Get(1)
+	if name.Label == "Targets" {
+		return c.Unpack(node)
+	}
 	errors = append(errors, c.Indexed(name)...)
 	errors = append(errors, c.Expr(expr)...)
 	if len(errors) == 0 {
@@ -120,11 +426,14 @@ func (c *checker) Assign(node *frontend.Node) (errors Errors) {
 			if len(errors) != 0 {
 				return errors
 			}
+			if c.syms.Depth() == c.top && expr.Label == "Func" {
+				c.generalize(expr)
+			}
 			name.Type = expr.Type
-			c.syms.Put(sym, expr.Type)
+			c.define(sym, expr.Type, name)
 			node.Type = types.Unit
-		} else if !name.Type.Equals(expr.Type) {
-			errors = append(errors, fmt.Errorf("Assignee did not agree in types with Assinged : %v", node.Serialize(true)))
+		} else if !types.Equate(name.Type, expr.Type) {
+			errors = append(errors, errorf(node, "Assignee did not agree in types with Assinged : %v", node.Serialize(true)))
 		} else {
 			node.Type = types.Unit
 		}
@@ -132,11 +441,110 @@ func (c *checker) Assign(node *frontend.Node) (errors Errors) {
 	return errors
 }
 
+// Unpack assigns each element of a tuple to its own target, (x, y) = f().
+func (c *checker) Unpack(node *frontend.Node) (errors Errors) {
+	targets := node.Get(0)
+	expr := node.Get(1)
+	errors = append(errors, c.Expr(expr)...)
+	if len(errors) != 0 {
+		return errors
+	}
+	if m, unknown := types.Resolve(expr.Type).(*types.Meta); unknown {
+		tuple := make(types.Tuple, 0, len(targets.Children))
+		for range targets.Children {
+			tuple = append(tuple, types.NewMeta())
+		}
+		types.Equate(m, tuple)
+	}
+	tuple, ok := types.Underlying(expr.Type).(types.Tuple)
+	if !ok {
+		return append(errors, errorf(expr, "Expected a tuple to unpack got %v", expr.Serialize(true)))
+	}
+	if len(tuple) != len(targets.Children) {
+		return append(errors, errorf(node, "Cannot unpack a tuple of %d into %d targets", len(tuple), len(targets.Children)))
+	}
+	names := make(map[string]bool)
+	for i, target := range targets.Children {
+		if target.Label == "NAME" {
+			if name := target.Value.(string); names[name] {
+				errors = append(errors, errorf(target, "symbol, %v, unpacked into more than once", name))
+				continue
+			} else {
+				names[name] = true
+			}
+		}
+		errors = append(errors, c.Indexed(target)...)
+		if len(errors) != 0 {
+			continue
+		}
+		if target.Type == nil {
+			sym, err := c.NAME(target)
+			if len(err) != 0 {
+				return append(errors, err...)
+			}
+			target.Type = tuple[i]
+			c.define(sym, tuple[i], target)
+		} else if !types.Equate(target.Type, tuple[i]) {
+			errors = append(errors, errorf(target, "Target, %v, does not agree with the tuple element %v", target.Serialize(true), tuple[i]))
+		}
+	}
+	if len(errors) == 0 {
+		targets.Type = tuple
+		node.Type = types.Unit
+	}
+	return errors
+}
+
+// generalize makes a function assigned at the top level generic in the
+// types its body left to be inferred, so each use may give them differently.
+func (c *checker) generalize(fn *frontend.Node) {
+	f, ok := types.Zonk(fn.Type).(*types.Function)
+	if !ok || len(f.TypeParams) > 0 {
+		return
+	}
+	metas := types.Metas(f)
+	if len(metas) == 0 {
+		return
+	}
+	vars := make([]*types.TypeVar, 0, len(metas))
+	for i, m := range metas {
+		name := fmt.Sprintf("T%d", i + 1)
+		if i < 26 {
+			name = string(rune('A' + i))
+		}
+		v := types.NewTypeVar(name)
+		v.Path = c.path
+		m.Type = v
+		vars = append(vars, v)
+	}
+	f = types.Zonk(f).(*types.Function)
+	f.TypeParams = vars
+	fn.Type = f
+}
+
+// zonk records the inferred types in the tree. A type which is still to be
+// inferred needs an annotation.
+func (c *checker) zonk(node *frontend.Node) (errors Errors) {
+	for _, kid := range node.Children {
+		if errors = c.zonk(kid); len(errors) > 0 {
+			return errors
+		}
+	}
+	if node.Type == nil {
+		return errors
+	}
+	node.Type = types.Zonk(node.Type)
+	if len(types.Metas(node.Type)) > 0 {
+		return append(errors, errorf(node, "Cannot infer the type, %v, of %v. It needs an annotation", node.Type, node.Serialize(false)))
+	}
+	return errors
+}
+
 func (c *checker) Indexed(node *frontend.Node) (errors Errors) {
 	if node.Label == "Deref" {
 		errors = c.Symbol(node.Get(0))
 		if len(errors) == 0 {
-			node.Type = node.Get(0).Type.Unboxed()
+			node.Type = types.Underlying(node.Get(0).Type).Unboxed()
 		}
 	} else if node.Label == "NAME" {
 		errors = c.TryTopSymbol(node)
@@ -146,13 +554,24 @@ func (c *checker) Indexed(node *frontend.Node) (errors Errors) {
 		if node.Get(0).Label == "NAME" {
 			errors = append(errors, c.Symbol(node.Get(0))...)
 		}
-		if t, isarr := node.Get(0).Type.(*types.Array); !isarr {
-			errors = append(errors, fmt.Errorf("Expected a node of type array got %v %T %v", node.Get(0).Serialize(true), node.Get(0).Type, t))
+		if t, isarr := types.Underlying(node.Get(0).Type).(*types.Array); !isarr {
+			errors = append(errors, errorf(node.Get(0), "Expected a node of type array got %v %T %v", node.Get(0).Serialize(true), node.Get(0).Type, t))
 		} else {
 			node.Type = t.Base
 		}
+	} else if node.Label == "Field" {
+		if mod := c.imported(node.Get(0)); mod != nil {
+			return append(errors, errorf(node, "Cannot assign to a member of module %v", mod.Name))
+		}
+		errors = append(errors, c.Indexed(node.Get(0))...)
+		if node.Get(0).Label == "NAME" {
+			errors = append(errors, c.Symbol(node.Get(0))...)
+		}
+		if len(errors) == 0 {
+			errors = append(errors, c.field(node)...)
+		}
 	} else {
-		errors = append(errors, fmt.Errorf("unxpected node in Indexed : %v", node.Serialize(true)))
+		errors = append(errors, errorf(node, "unxpected node in Indexed : %v", node.Serialize(true)))
 	}
 	return errors
 }
@@ -162,15 +581,15 @@ func (c *checker) Indexer(node *frontend.Node) (errors Errors) {
 	if len(errors) > 0 {
 		return errors
 	}
-	if !node.Type.Equals(types.Int) {
-		return append(errors, fmt.Errorf("Expected a node of type int as the index got %v", node.Serialize(true)))
+	if !types.Equate(node.Type, types.Int) {
+		return append(errors, errorf(node, "Expected a node of type int as the index got %v", node.Serialize(true)))
 	}
 	return nil
 }
 
 func (c *checker) NAME(node *frontend.Node) (name string, errors Errors) {
 	if node.Label != "NAME" {
-		return "", append(errors, fmt.Errorf("expected a NAME node : %v", node.Serialize(true)))
+		return "", append(errors, errorf(node, "expected a NAME node : %v", node.Serialize(true)))
 	}
 	return node.Value.(string), nil
 }
@@ -189,7 +608,10 @@ func (c *checker) Expr(node *frontend.Node) (errors Errors) {
 		node.Type = types.String
 	case "NAME":
 		errors = c.Symbol(node)
-	case "Call":
+		if _, is := node.Type.(*types.Module); is {
+			errors = append(errors, errorf(node, "module, %v, is not a value", node.Value))
+		}
+	case "Call", "Conversion":
 		errors = c.Call(node)
 	case "Index":
 		errors = c.Index(node)
@@ -199,9 +621,89 @@ func (c *checker) Expr(node *frontend.Node) (errors Errors) {
 		errors = c.If(node)
 	case "NEW":
 		errors = c.New(node)
+	case "Record":
+		errors = c.Record(node)
+	case "Tuple":
+		errors = c.Tuple(node)
+	case "Field":
+		errors = c.Field(node)
+	case "Match":
+		errors = c.Match(node)
+	case "Instantiate":
+		errors = c.Instantiate(node)
 	default:
-		errors = append(errors, fmt.Errorf("unexpected node %v", node))
+		errors = append(errors, errorf(node, "unexpected node %v", node))
+	}
+	return errors
+}
+
+func (c *checker) Record(node *frontend.Node) (errors Errors) {
+	record := &types.Record{}
+	for _, kid := range node.Children {
+		n := kid.Get(0)
+		expr := kid.Get(1)
+		name, err := c.NAME(n)
+		if err != nil {
+			return append(errors, err...)
+		}
+		if i, _ := record.Lookup(name); i >= 0 {
+			errors = append(errors, errorf(n, "field, %v, given more than once", name))
+			continue
+		}
+		errors = append(errors, c.Expr(expr)...)
+		if expr.Type == nil {
+			continue
+		}
+		record.Fields = append(record.Fields, &types.Field{Name: name, Type: expr.Type})
+		n.Type = expr.Type
+		kid.Type = expr.Type
+	}
+	if len(errors) == 0 {
+		node.Type = record
+	}
+	return errors
+}
+
+func (c *checker) Tuple(node *frontend.Node) (errors Errors) {
+	tuple := make(types.Tuple, 0, len(node.Children))
+	for _, kid := range node.Children {
+		errors = append(errors, c.Expr(kid)...)
+		tuple = append(tuple, kid.Type)
+	}
+	if len(errors) == 0 {
+		node.Type = tuple
+	}
+	return errors
+}
+
+func (c *checker) Field(node *frontend.Node) (errors Errors) {
+	if mod := c.imported(node.Get(0)); mod != nil {
+		return c.member(node, mod)
 	}
+	errors = c.Expr(node.Get(0))
+	if len(errors) > 0 {
+		return errors
+	}
+	return c.field(node)
+}
+
+// field types the access of a field of the already typed record.
+func (c *checker) field(node *frontend.Node) (errors Errors) {
+	record := node.Get(0)
+	name, errors := c.NAME(node.Get(1))
+	if len(errors) > 0 {
+		return errors
+	}
+	r, ok := types.Underlying(record.Type).(*types.Record)
+	if !ok {
+		return append(errors, errorf(record, "Expected a record type got, %v", record.Serialize(true)))
+	}
+	i, t := r.Lookup(name)
+	if i < 0 {
+		return append(errors, errorf(node.Get(1), "%v has no field, %v", r, name))
+	}
+	node.Get(1).Type = t
+	node.Type = t
 	return errors
 }
 
@@ -211,13 +713,31 @@ func (c *checker) New(node *frontend.Node) (errors Errors) {
 		return append(errors, err...)
 	}
 	errors = c.arraysHaveSize(node.Get(0))
-	if _, ok := new_type.(*types.Function); ok {
-		return append(errors, fmt.Errorf("Cannot construct a function with new %v", node.Serialize(true)))
+	// the elements of a new array are made as new would make them
+	elem := types.Underlying(new_type)
+	for {
+		if a, ok := elem.(*types.Array); ok {
+			elem = types.Underlying(a.Base)
+		} else {
+			break
+		}
+	}
+	if _, ok := elem.(*types.Function); ok {
+		return append(errors, errorf(node, "Cannot construct a function with new %v", node.Serialize(true)))
+	}
+	if _, ok := elem.(*types.Union); ok {
+		return append(errors, errorf(node, "Cannot construct a union with new, use one of its variants %v", node.Serialize(true)))
+	}
+	if _, ok := elem.(types.Tuple); ok {
+		return append(errors, errorf(node, "Cannot construct a tuple with new %v", node.Serialize(true)))
+	}
+	if _, ok := elem.(*types.Record); ok {
+		return append(errors, errorf(node, "Cannot construct a record with new, use a record literal %v", node.Serialize(true)))
 	}
 	if _, ok := new_type.(*types.Array); ok {
 		node.Type = new_type
 	} else {
-		node.Type = &types.Box{new_type}
+		node.Type = &types.Box{Boxed: new_type}
 	}
 	return errors
 }
@@ -235,7 +755,7 @@ func (c *checker) BooleanExpr(node *frontend.Node) (errors Errors) {
 	case "!":
 		errors = c.Not(node)
 	default:
-		errors = append(errors, fmt.Errorf("unexpected node %v", node))
+		errors = append(errors, errorf(node, "unexpected node %v", node))
 	}
 	return errors
 }
@@ -249,18 +769,32 @@ func (c *checker) Index(node *frontend.Node) (errors Errors) {
 		return err
 	}
 
+	if types.Generic(indexed.Type) {
+		// f[T] instantiates f rather than indexing it
+		if index.Label != "NAME" {
+			return append(errors, errorf(index, "Expected a type argument got %v", index.Serialize(true)))
+		}
+		node.Label = "Instantiate"
+		node.Children[1] = frontend.NewNode("TypeParams").AddKid(frontend.NewNode("TypeName").AddKid(index))
+		return c.instantiate(node)
+	}
+
 	err = c.Expr(index)
 	if err != nil {
 		return err
 	}
 
-	a_type, ok := indexed.Type.(*types.Array)
+	if m, unknown := types.Resolve(indexed.Type).(*types.Meta); unknown {
+		types.Equate(m, &types.Array{Base: types.NewMeta()})
+	}
+
+	a_type, ok := types.Underlying(indexed.Type).(*types.Array)
 	if !ok {
-		return append(errors, fmt.Errorf("Expected a array type got, %v", indexed.Serialize(true)))
+		return append(errors, errorf(indexed, "Expected a array type got, %v", indexed.Serialize(true)))
 	}
 
-	if !index.Type.Equals(types.Int) {
-		return append(errors, fmt.Errorf("Array index expected int got %v", index.Serialize(true)))
+	if !types.Equate(index.Type, types.Int) {
+		return append(errors, errorf(index, "Array index expected int got %v", index.Serialize(true)))
 	}
 
 	node.Type = a_type.Base
@@ -272,6 +806,20 @@ func (c *checker) Call(node *frontend.Node) (errors Errors) {
 	callee := node.Get(0)
 	params := node.Get(1)
 
+	if callee.Label == "Instantiate" && callee.Get(1).Leaf() {
+		// the instantiation was inferred when the tree was checked before
+		callee = callee.Get(0)
+		node.Children[0] = callee
+	}
+
+	// the back ends tell a conversion from a call by its label rather than
+	// looking the callee up in a scope which may not be this one
+	if t := c.conversion(callee); t != nil {
+		node.Label = "Conversion"
+		return c.Conversion(node, t)
+	}
+	node.Label = "Call"
+
 	err := c.Expr(callee)
 	if err != nil {
 		return err
@@ -282,18 +830,35 @@ func (c *checker) Call(node *frontend.Node) (errors Errors) {
 		return err
 	}
 
-	f_type, ok := callee.Type.(*types.Function)
+	if m, unknown := types.Resolve(callee.Type).(*types.Meta); unknown {
+		// calling a value of a type to be inferred makes it a function
+		types.Equate(m, &types.Function{Parameters: param_types, Returns: types.NewMeta()})
+	}
+
+	if types.Generic(callee.Type) {
+		inst, err := c.infer(node, param_types)
+		if err != nil {
+			return err
+		}
+		args := frontend.NewNode("TypeParams")
+		args.Type = types.Unit
+		callee = frontend.NewNode("Instantiate").AddKid(callee).AddKid(args)
+		callee.Type = inst
+		node.Children[0] = callee
+	}
+
+	f_type, ok := types.Underlying(callee.Type).(*types.Function)
 	if !ok {
-		return append(errors, fmt.Errorf("Expected a function type got, %v", callee))
+		return append(errors, errorf(callee, "Expected a function type got, %v", callee))
 	}
 
 	if len(param_types) != len(f_type.Parameters) {
-		return append(errors, fmt.Errorf("Callee expected %v params got %v", f_type.Parameters, param_types))
+		return append(errors, errorf(node, "Callee expected %v params got %v", f_type.Parameters, param_types))
 	}
 
 	for i, t := range f_type.Parameters {
-		if !t.Equals(param_types[i]) {
-			return append(errors, fmt.Errorf("Callee expected %v params got %v", t, param_types[i]))
+		if !types.Equate(t, param_types[i]) {
+			return append(errors, errorf(params.Get(i), "Callee expected %v params got %v", t, param_types[i]))
 		}
 	}
 
@@ -302,6 +867,123 @@ func (c *checker) Call(node *frontend.Node) (errors Errors) {
 	return errors
 }
 
+// infer instantiates the generic function called by node with the types
+// which make its parameters agree with the arguments.
+func (c *checker) infer(node *frontend.Node, args []types.Type) (inst *types.Function, errors Errors) {
+	f := types.Underlying(node.Get(0).Type).(*types.Function)
+	if len(args) != len(f.Parameters) {
+		return nil, append(errors, errorf(node, "Callee expected %v params got %v", f.Parameters, args))
+	}
+	s := make(map[*types.TypeVar]types.Type, len(f.TypeParams))
+	for _, v := range f.TypeParams {
+		s[v] = nil
+	}
+	for i, p := range f.Parameters {
+		if !types.Unify(p, args[i], s) {
+			return nil, append(errors, errorf(node.Get(1).Get(i), "Callee expected %v params got %v", p, args[i]))
+		}
+	}
+	type_args := make([]types.Type, 0, len(f.TypeParams))
+	for _, v := range f.TypeParams {
+		if s[v] == nil {
+			return nil, append(errors, errorf(node, "Cannot infer the type parameter %v of %v, give it with [%v]", v, f, v))
+		}
+		type_args = append(type_args, s[v])
+	}
+	return f.Instantiate(type_args), errors
+}
+
+func (c *checker) Instantiate(node *frontend.Node) (errors Errors) {
+	errors = c.Expr(node.Get(0))
+	if len(errors) != 0 {
+		return errors
+	}
+	return c.instantiate(node)
+}
+
+// instantiate types an instantiation of a generic function, its expression
+// has already been checked.
+func (c *checker) instantiate(node *frontend.Node) (errors Errors) {
+	expr := node.Get(0)
+	if !types.Generic(expr.Type) {
+		return append(errors, errorf(expr, "Cannot instantiate %v which is not generic %v", expr.Type, expr.Serialize(true)))
+	}
+	f := types.Underlying(expr.Type).(*types.Function)
+	args, errors := c.TypeParams(node.Get(1))
+	if len(errors) != 0 {
+		return errors
+	}
+	if len(args) != len(f.TypeParams) {
+		return append(errors, errorf(node, "%v takes %d type arguments got %d", f, len(f.TypeParams), len(args)))
+	}
+	node.Type = f.Instantiate(args)
+	return errors
+}
+
+// conversion gives the type a call converts to when the callee names a type
+// rather than a value.
+func (c *checker) conversion(callee *frontend.Node) types.Type {
+	if callee.Label == "Field" {
+		// m.T(x) converts to an exported type of an imported module
+		mod := c.imported(callee.Get(0))
+		if mod == nil {
+			return nil
+		}
+		name := callee.Get(1).Value.(string)
+		if _, has := mod.Values[name]; has {
+			return nil
+		}
+		if t, has := mod.Types[name]; has {
+			c.TrySymbol(callee.Get(0))
+			return t
+		}
+		return nil
+	}
+	if callee.Label != "NAME" {
+		return nil
+	}
+	name := callee.Value.(string)
+	if c.syms.Get(name) != nil {
+		return nil
+	}
+	if t := c.types.Get(name); t != nil {
+		return t.(types.Type)
+	}
+	return nil
+}
+
+// numeric reports whether from is a number which converts to the primative
+// to.
+func numeric(from, to types.Type) bool {
+	_, primative := to.(types.Primative)
+	return primative && matches(from, types.Int, types.Float) && matches(to, types.Int, types.Float, types.String)
+}
+
+// Conversion types a call T(x), relabelled Conversion, which gives x the
+// type T. x must have the
+// same structure as T. A number may also be converted to the other kind of
+// number, `float(i)` or `int(f)` which truncates, or to a string,
+// `string(i)`.
+func (c *checker) Conversion(node *frontend.Node, t types.Type) (errors Errors) {
+	params := node.Get(1)
+	if len(params.Children) != 1 {
+		return append(errors, errorf(node, "conversion to %v takes one value got %v", t, len(params.Children)))
+	}
+	param_types, errors := c.Params(params)
+	if len(errors) > 0 {
+		return errors
+	}
+	from, to := types.Underlying(param_types[0]), types.Underlying(t)
+	if _, unknown := from.(*types.Meta); unknown {
+		types.Equate(from, to)
+	} else if !to.Equals(from) && !numeric(from, t) {
+		return append(errors, errorf(params.Get(0), "cannot convert %v to %v", param_types[0], t))
+	}
+	node.Get(0).Type = t
+	node.Type = t
+	return errors
+}
+
 func (c *checker) Params(node *frontend.Node) (typ []types.Type, errors Errors) {
 	for _, kid := range node.Children {
 		err := c.Expr(kid)
@@ -322,6 +1004,14 @@ func (c *checker) Function(node *frontend.Node) (errors Errors) {
 	c.Push()
 	defer c.Pop()
 
+	var type_params []*types.TypeVar
+	if len(node.Children) > 3 {
+		type_params, errors = c.TypeVars(node.Get(3))
+		if len(errors) != 0 {
+			return errors
+		}
+	}
+
 	param_types, err := c.ParamDecls(params)
 	if err != nil {
 		return append(errors, err...)
@@ -333,6 +1023,7 @@ func (c *checker) Function(node *frontend.Node) (errors Errors) {
 	}
 
 	f_type := &types.Function{
+		TypeParams: type_params,
 		Parameters: param_types,
 		Returns: return_type,
 	}
@@ -341,7 +1032,7 @@ func (c *checker) Function(node *frontend.Node) (errors Errors) {
 		old_fn := c.fn
 		c.fn = f_type
 
-		c.syms.Put("self", f_type)
+		c.define("self", f_type, node)
 		errors = append(errors, c.Stmts(block)...)
 		if len(errors) != 0 {
 			return errors
@@ -350,9 +1041,10 @@ func (c *checker) Function(node *frontend.Node) (errors Errors) {
 		c.fn = old_fn
 
 		last := block.Get(-1)
-		if !f_type.Returns.Equals(last.Type) {
+		if !types.Equate(f_type.Returns, last.Type) {
 			return append(errors,
-				fmt.Errorf(
+				errorf(
+					last,
 					"Function type, %v, does not agree with last expression, %v, at %v",
 					f_type,
 					last.Type,
@@ -388,8 +1080,8 @@ func (c *checker) If(node *frontend.Node) (errors Errors) {
 	then.Type = then.Get(-1).Type
 	otherwise.Type = otherwise.Get(-1).Type
 
-	if !then.Type.Equals(otherwise.Type) {
-		return append(errors, fmt.Errorf("Branches of if expression do not agree in types. %v", node.Serialize(true)))
+	if !types.Equate(then.Type, otherwise.Type) {
+		return append(errors, errorf(node, "Branches of if expression do not agree in types. %v", node.Serialize(true)))
 	}
 
 	node.Type = then.Type
@@ -397,6 +1089,83 @@ func (c *checker) If(node *frontend.Node) (errors Errors) {
 	return errors
 }
 
+func (c *checker) Match(node *frontend.Node) (errors Errors) {
+	expr := node.Get(0)
+	errors = c.Expr(expr)
+	if len(errors) != 0 {
+		return errors
+	}
+	union, is := types.Underlying(expr.Type).(*types.Union)
+	if !is {
+		return append(errors, errorf(expr, "Expected a union to match on got %v %v", expr.Type, expr.Serialize(true)))
+	}
+	matched := make(map[string]bool)
+	for _, arm := range node.Children[1:] {
+		pattern := arm.Get(0)
+		name, err := c.NAME(pattern.Get(0))
+		if err != nil {
+			errors = append(errors, err...)
+			continue
+		}
+		_, v := union.Lookup(name)
+		if v == nil {
+			errors = append(errors, errorf(pattern, "%v is not a variant of %v", name, expr.Type))
+			continue
+		} else if matched[name] {
+			errors = append(errors, errorf(pattern, "variant, %v, matched more than once", name))
+			continue
+		}
+		matched[name] = true
+		binders := pattern.Get(1)
+		if len(binders.Children) != len(v.Fields) {
+			errors = append(errors, errorf(pattern, "variant %v has %d fields but the pattern binds %d", name, len(v.Fields), len(binders.Children)))
+			continue
+		}
+		c.Push()
+		for i, b := range binders.Children {
+			sym, err := c.NAME(b)
+			if err != nil {
+				errors = append(errors, err...)
+				continue
+			} else if c.syms.TopHas(sym) {
+				errors = append(errors, errorf(b, "%v bound more than once in pattern", sym))
+			}
+			b.Type = v.Fields[i]
+			c.define(sym, b.Type, b)
+		}
+		errors = append(errors, c.Expr(arm.Get(1))...)
+		c.Pop()
+		pattern.Get(0).Type = expr.Type
+		binders.Type = types.Unit
+		pattern.Type = expr.Type
+		arm.Type = arm.Get(1).Type
+	}
+
+	if len(errors) != 0 {
+		return errors
+	}
+
+	var missing []string
+	for _, v := range union.Variants {
+		if !matched[v.Name] {
+			missing = append(missing, v.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return append(errors, errorf(node, "match is not exhaustive, missing %v", strings.Join(missing, ", ")))
+	}
+
+	typ := node.Get(1).Type
+	for _, arm := range node.Children[2:] {
+		if !types.Equate(arm.Type, typ) {
+			return append(errors, errorf(node, "Arms of match expression do not agree in types. %v", node.Serialize(true)))
+		}
+	}
+	node.Type = typ
+
+	return errors
+}
+
 func (c *checker) Type(node *frontend.Node) (typ types.Type, errors Errors) {
 	switch node.Label {
 	case "TypeName":
@@ -407,26 +1176,50 @@ func (c *checker) Type(node *frontend.Node) (typ types.Type, errors Errors) {
 		return c.ArrayType(node)
 	case "BoxType":
 		return c.BoxType(node)
+	case "RecordType":
+		return c.RecordType(node)
+	case "TupleType":
+		return c.TupleType(node)
+	case "QualifiedType":
+		return c.QualifiedType(node)
+	case "Union":
+		return c.Union(node)
+	case "Infer":
+		node.Type = types.NewMeta()
+		return node.Type, errors
 	}
-	return nil, append(errors, fmt.Errorf("Unexpected node label %v", node))
+	return nil, append(errors, errorf(node, "Unexpected node label %v", node))
 }
 
 func (c *checker) arraysHaveSize(node *frontend.Node) (errors Errors) {
 	switch node.Label {
-	case "TypeName":
+	case "TypeName", "QualifiedType":
+		if _, is := types.Underlying(node.Type).(*types.Array); is {
+			errors = append(errors, errorf(node, "Array specification must have a size here %v", node.Serialize(true)))
+		}
 		return errors
 	case "FuncType":
 		return errors
 	case "ArrayType":
 		errors = c.arraysHaveSize(node.Get(0))
 		if len(node.Children) == 1 {
-			errors = append(errors, fmt.Errorf("Array specification must have a size here %v", node.Serialize(true)))
+			errors = append(errors, errorf(node, "Array specification must have a size here %v", node.Serialize(true)))
 		}
 		return errors
 	case "BoxType":
 		return c.arraysHaveSize(node.Get(0))
+	case "RecordType":
+		for _, kid := range node.Children {
+			errors = append(errors, c.arraysHaveSize(kid.Get(1))...)
+		}
+		return errors
+	case "TupleType":
+		for _, kid := range node.Children {
+			errors = append(errors, c.arraysHaveSize(kid)...)
+		}
+		return errors
 	}
-	return append(errors, fmt.Errorf("Unexpected node label %v", node))
+	return append(errors, errorf(node, "Unexpected node label %v", node))
 }
 
 func (c *checker) TypeName(node *frontend.Node) (typ types.Type, errors Errors) {
@@ -435,7 +1228,7 @@ func (c *checker) TypeName(node *frontend.Node) (typ types.Type, errors Errors)
 		return nil, errors
 	}
 	if e := c.types.Get(sym); e == nil {
-		errors = append(errors, fmt.Errorf("type, %v, undeclared", node.Get(0).Serialize(true)))
+		errors = append(errors, errorf(node.Get(0), "type, %v, undeclared", node.Get(0).Serialize(true)))
 	} else {
 		node.Type = e.(types.Type)
 		node.Get(0).Type = node.Type
@@ -447,12 +1240,92 @@ func (c *checker) TypeName(node *frontend.Node) (typ types.Type, errors Errors)
 func (c *checker) BoxType(node *frontend.Node) (typ types.Type, errors Errors) {
 	t, errors := c.Type(node.Get(0))
 	if len(errors) == 0 {
-		node.Type = &types.Box{t}
+		node.Type = &types.Box{Boxed: t}
 		return node.Type, errors
 	}
 	return nil, errors
 }
 
+func (c *checker) RecordType(node *frontend.Node) (typ types.Type, errors Errors) {
+	record := &types.Record{}
+	for _, kid := range node.Children {
+		n := kid.Get(0)
+		name, err := c.NAME(n)
+		if err != nil {
+			return nil, err
+		}
+		if i, _ := record.Lookup(name); i >= 0 {
+			return nil, append(errors, errorf(n, "field, %v, declared more than once", name))
+		}
+		t, err := c.Type(kid.Get(1))
+		if err != nil {
+			return nil, err
+		}
+		record.Fields = append(record.Fields, &types.Field{Name: name, Type: t})
+		n.Type = t
+		kid.Type = t
+	}
+	node.Type = record
+	return node.Type, errors
+}
+
+// QualifiedType is m.T, an exported type of an imported module.
+func (c *checker) QualifiedType(node *frontend.Node) (typ types.Type, errors Errors) {
+	mod := c.imported(node.Get(0))
+	if mod == nil {
+		return nil, append(errors, errorf(node.Get(0), "%v is not an imported module", node.Get(0).Value))
+	}
+	c.TrySymbol(node.Get(0))
+	name, errors := c.NAME(node.Get(1))
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	t, has := mod.Types[name]
+	if !has {
+		if exported(name) {
+			return nil, append(errors, errorf(node.Get(1), "module %v has no type, %v", mod.Name, name))
+		}
+		return nil, append(errors, errorf(node.Get(1), "%v is not exported by module %v", name, mod.Name))
+	}
+	node.Get(1).Type = t
+	node.Type = t
+	return t, errors
+}
+
+func (c *checker) TupleType(node *frontend.Node) (typ types.Type, errors Errors) {
+	tuple := make(types.Tuple, 0, len(node.Children))
+	for _, kid := range node.Children {
+		t, err := c.Type(kid)
+		if err != nil {
+			return nil, err
+		}
+		tuple = append(tuple, t)
+	}
+	node.Type = tuple
+	return node.Type, errors
+}
+
+func (c *checker) Union(node *frontend.Node) (typ types.Type, errors Errors) {
+	union := &types.Union{}
+	for _, kid := range node.Children {
+		n := kid.Get(0)
+		name, err := c.NAME(n)
+		if err != nil {
+			return nil, err
+		}
+		if i, _ := union.Lookup(name); i >= 0 {
+			return nil, append(errors, errorf(n, "variant, %v, declared more than once", name))
+		}
+		fields, err := c.TypeParams(kid.Get(1))
+		if err != nil {
+			return nil, err
+		}
+		union.Variants = append(union.Variants, &types.Variant{Name: name, Fields: fields})
+	}
+	node.Type = union
+	return node.Type, errors
+}
+
 func (c *checker) FuncType(node *frontend.Node) (typ types.Type, errors Errors) {
 	params, err := c.TypeParams(node.Get(0))
 	if err != nil {
@@ -479,8 +1352,8 @@ func (c *checker) ArrayType(node *frontend.Node) (typ types.Type, errors Errors)
 		if err != nil {
 			return nil, err
 		}
-		if !types.Int.Equals(node.Get(1).Type) {
-			return nil, append(errors, fmt.Errorf("Expected an integer size got %v %v", node.Get(1).Type, node.Serialize(true)))
+		if !types.Equate(types.Int, node.Get(1).Type) {
+			return nil, append(errors, errorf(node.Get(1), "Expected an integer size got %v %v", node.Get(1).Type, node.Serialize(true)))
 		}
 	}
 	node.Type = &types.Array{
@@ -501,6 +1374,30 @@ func (c *checker) TypeParams(node *frontend.Node) (typ []types.Type, errors Erro
 	return typ, nil
 }
 
+// TypeVars declares the type parameters of a generic function.
+func (c *checker) TypeVars(node *frontend.Node) (vars []*types.TypeVar, errors Errors) {
+	for _, n := range node.Children {
+		name, err := c.NAME(n)
+		if err != nil {
+			return nil, err
+		}
+		if c.types.TopHas(name) {
+			return nil, append(errors, errorf(n, "type parameter, %v, declared more than once", name))
+		}
+		// checking the tree again must give the same type
+		v, is := n.Type.(*types.TypeVar)
+		if !is || v.Name != name {
+			v = types.NewTypeVar(name)
+			v.Path = c.path
+		}
+		c.types.Put(name, v)
+		n.Type = v
+		vars = append(vars, v)
+	}
+	node.Type = types.Unit
+	return vars, errors
+}
+
 func (c *checker) ParamDecls(node *frontend.Node) (typ []types.Type, errors Errors) {
 	for _, kid := range node.Children {
 		n := kid.Get(0)
@@ -512,7 +1409,7 @@ func (c *checker) ParamDecls(node *frontend.Node) (typ []types.Type, errors Erro
 		if err != nil {
 			return nil, err
 		}
-		c.syms.Put(name, t)
+		c.define(name, t, n)
 		typ = append(typ, t)
 		n.Type = t
 		kid.Type = t
@@ -529,6 +1426,10 @@ func (c *checker) TryTopSymbol(node *frontend.Node) (errors Errors) {
 	if c.syms.TopHas(sym) {
 		e := c.syms.Get(sym)
 		node.Type = e.(types.Type)
+		c.use(sym, node)
+	} else {
+		// a type left from an earlier check of the tree, the name is new
+		node.Type = nil
 	}
 	return errors
 }
@@ -540,6 +1441,7 @@ func (c *checker) TrySymbol(node *frontend.Node) (errors Errors) {
 	}
 	if e := c.syms.Get(sym); e != nil {
 		node.Type = e.(types.Type)
+		c.use(sym, node)
 	}
 	return errors
 }
@@ -550,7 +1452,7 @@ func (c *checker) Symbol(node *frontend.Node) (errors Errors) {
 		return errors
 	}
 	if node.Type == nil {
-		errors = append(errors, fmt.Errorf("symbol, %v, undeclared", node.Serialize(true)))
+		errors = append(errors, errorf(node, "symbol, %v, undeclared", node.Serialize(true)))
 	}
 	return errors
 }
@@ -561,15 +1463,16 @@ func (c *checker) ArithOp(node *frontend.Node) (errors Errors) {
 	errors = append(errors, c.Expr(a)...)
 	errors = append(errors, c.Expr(b)...)
 	if len(errors) == 0 {
-		if !a.Type.Equals(b.Type) {
-			errors = append(errors, fmt.Errorf("a, %v, does not agree with b, %v, in types", a, b))
+		if !types.Equate(a.Type, b.Type) {
+			errors = append(errors, errorf(node, "a, %v, does not agree with b, %v, in types", a, b))
 		}
+		arith(a.Type)
 		if a.Type.Equals(types.String) && node.Label == "+" {
 			// ok
 		} else if a.Type.Equals(types.Float) && node.Label == "%" {
-			errors = append(errors, fmt.Errorf("type %v does not support % op", a))
+			errors = append(errors, errorf(a, "type %v does not support %% op", a))
 		} else if !matches(a.Type, types.Int, types.Float) {
-			errors = append(errors, fmt.Errorf("type %v does not support arith ops", a))
+			errors = append(errors, errorf(a, "type %v does not support arith ops", a))
 		}
 	}
 	if len(errors) == 0 {
@@ -582,20 +1485,23 @@ func (c *checker) UnaryOp(node *frontend.Node) (errors Errors) {
 	a := node.Children[0]
 	errors = append(errors, c.Expr(a)...)
 	if node.Label == "Negate" {
+		if len(errors) == 0 {
+			arith(a.Type)
+		}
 		if len(errors) == 0 && !matches(a.Type, types.Int, types.Float) {
-			errors = append(errors, fmt.Errorf("type %v does not support arith ops", a))
+			errors = append(errors, errorf(a, "type %v does not support arith ops", a))
 		}
 		if len(errors) == 0 {
 			node.Type = a.Type
 		}
 	} else if node.Label == "Deref" {
-		if box, is := a.Type.(*types.Box); !is {
-			errors = append(errors, fmt.Errorf("type %v does not support deref ops", a))
+		if box, is := types.Underlying(a.Type).(*types.Box); !is {
+			errors = append(errors, errorf(a, "type %v does not support deref ops", a))
 		} else if len(errors) == 0 {
 			node.Type = box.Boxed
 		}
 	} else {
-		return append(errors, fmt.Errorf("Unexpected node %v", node.Serialize(true)))
+		return append(errors, errorf(node, "Unexpected node %v", node.Serialize(true)))
 	}
 	return errors
 }
@@ -615,10 +1521,10 @@ func (c *checker) AndOr(node *frontend.Node) (errors Errors) {
 	errors = append(errors, c.BooleanExpr(b)...)
 	if len(errors) == 0 {
 		if !a.Type.Equals(b.Type) {
-			errors = append(errors, fmt.Errorf("a, %v, does not agree with b, %v, in types", a, b))
+			errors = append(errors, errorf(node, "a, %v, does not agree with b, %v, in types", a, b))
 		}
 		if !matches(a.Type, types.Boolean) {
-			errors = append(errors, fmt.Errorf("type %v does not support boolean ops", a))
+			errors = append(errors, errorf(a, "type %v does not support boolean ops", a))
 		}
 	}
 	if len(errors) == 0 {
@@ -632,7 +1538,7 @@ func (c *checker) Not(node *frontend.Node) (errors Errors) {
 	errors = append(errors, c.BooleanExpr(a)...)
 	if len(errors) == 0 {
 		if !matches(a.Type, types.Boolean) {
-			errors = append(errors, fmt.Errorf("type %v does not support boolean ops", a))
+			errors = append(errors, errorf(a, "type %v does not support boolean ops", a))
 		}
 	}
 	if len(errors) == 0 {
@@ -647,11 +1553,12 @@ func (c *checker) CmpOp(node *frontend.Node) (errors Errors) {
 	errors = append(errors, c.Expr(a)...)
 	errors = append(errors, c.Expr(b)...)
 	if len(errors) == 0 {
-		if !a.Type.Equals(b.Type) {
-			errors = append(errors, fmt.Errorf("a, %v, does not agree with b, %v, in types", a, b))
+		if !types.Equate(a.Type, b.Type) {
+			errors = append(errors, errorf(node, "a, %v, does not agree with b, %v, in types", a, b))
 		}
+		arith(a.Type)
 		if !matches(a.Type, types.Int, types.Float, types.String) {
-			errors = append(errors, fmt.Errorf("type %v does not support boolean comparison ops", a))
+			errors = append(errors, errorf(a, "type %v does not support boolean comparison ops", a))
 		}
 	}
 	if len(errors) == 0 {
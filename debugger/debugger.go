@@ -0,0 +1,348 @@
+// Package debugger steps through a program as the evaluator runs it. It is
+// driven by commands read a line at a time so it may be scripted:
+//
+//	break FILE:LINE, b     stop before the statements on the line
+//	delete FILE:LINE, d    remove a breakpoint
+//	step, s                run to the next statement
+//	next, n                run to the next statement not in a call
+//	finish, f              run until the call being run returns
+//	continue, c            run to the next breakpoint
+//	print NAME, p          print the value of a name, fields with NAME.FIELD
+//	locals                 print the names in scope
+//	backtrace, bt          print the calls being run
+//	frame N                inspect the Nth call, 0 being the innermost
+//	quit, q                stop the program
+//
+// The LINE of a breakpoint alone means the file stopped in. Once the
+// commands run out the program runs to its end.
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/frontend"
+)
+
+// Debugger runs a program stopping where the commands it reads ask.
+type Debugger struct {
+	in     *bufio.Scanner
+	out    io.Writer
+	breaks map[string]bool // FILE:LINE
+	mode   string          // what to run to: "step", "next", "finish" or "continue"
+	depth  int             // the depth of the calls when the program was resumed
+	seen   string          // FILE:LINE of the last statement
+	seend  int             // the depth of the calls at the last statement
+	quit   bool
+	e      *evaluator.Evaluator // the evaluator stopped in
+	node   *frontend.Node       // the statement stopped before
+	frame  int                  // the frame being inspected
+	files  map[string][]string  // the lines of each file shown
+}
+
+// quitting stops the evaluator when the program is quit.
+type quitting struct{}
+
+// Debug runs the type checked program with the commands read from in. It
+// stops before the first statement.
+func Debug(node *frontend.Node, in io.Reader, out io.Writer) ([]interface{}, error) {
+	return New(in, out).Run(node)
+}
+
+func New(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:     bufio.NewScanner(in),
+		out:    out,
+		breaks: make(map[string]bool),
+		mode:   "step",
+		files:  make(map[string][]string),
+	}
+}
+
+// Run evaluates the program. A program which is quit has no values and no
+// error.
+func (d *Debugger) Run(node *frontend.Node) ([]interface{}, error) {
+	values, err := evaluator.EvaluateWith(node, d.hook)
+	if d.quit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(d.out, "exited")
+	return values, nil
+}
+
+func (d *Debugger) hook(e *evaluator.Evaluator, node *frontend.Node, stmt bool) {
+	if !stmt || node.Label == "Module" {
+		return
+	}
+	loc := node.Location()
+	if loc == nil {
+		return
+	}
+	at := fmt.Sprintf("%v:%d", loc.Filename, loc.StartLine)
+	depth := e.Depth()
+	if at == d.seen && depth == d.seend {
+		// the statements nested in one on the same line
+		return
+	}
+	d.seen, d.seend = at, depth
+	stop := d.breakpoint(loc)
+	switch d.mode {
+	case "step":
+		stop = true
+	case "next":
+		stop = stop || depth <= d.depth
+	case "finish":
+		stop = stop || depth < d.depth
+	}
+	if !stop {
+		return
+	}
+	d.e, d.node, d.frame = e, node, 0
+	d.where(loc)
+	d.prompt()
+}
+
+func (d *Debugger) breakpoint(loc *frontend.SourceLocation) bool {
+	for b := range d.breaks {
+		i := strings.LastIndex(b, ":")
+		file, line := b[:i], b[i+1:]
+		if line == strconv.Itoa(loc.StartLine) && same(file, loc.Filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether the file given in a command names the file parsed.
+func same(given, parsed string) bool {
+	return given == parsed ||
+		filepath.Clean(given) == filepath.Clean(parsed) ||
+		strings.HasSuffix(parsed, "/"+given)
+}
+
+// where shows the line stopped at and the call it is in.
+func (d *Debugger) where(loc *frontend.SourceLocation) {
+	in := "main"
+	if frames := d.e.Frames(); len(frames) > 0 {
+		in = frames[0].Name
+	}
+	fmt.Fprintf(d.out, "stopped at %v:%d:%d in %v\n", loc.Filename, loc.StartLine, loc.StartColumn, in)
+	if line := d.line(loc.Filename, loc.StartLine); line != "" {
+		fmt.Fprintf(d.out, "%d\t%v\n", loc.StartLine, line)
+	}
+}
+
+func (d *Debugger) line(path string, n int) string {
+	lines, has := d.files[path]
+	if !has {
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		d.files[path] = lines
+	}
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
+
+// prompt reads commands until one resumes the program.
+func (d *Debugger) prompt() {
+	for {
+		fmt.Fprint(d.out, "(tcel) ")
+		if !d.in.Scan() {
+			// out of commands, run to the end
+			fmt.Fprintln(d.out)
+			d.breaks = make(map[string]bool)
+			d.mode = "continue"
+			return
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		switch cmd {
+		case "step", "s", "next", "n", "finish", "f", "continue", "c":
+			d.resume(cmd)
+			return
+		case "quit", "q":
+			d.quit = true
+			panic(quitting{})
+		case "break", "b":
+			d.setBreak(args, true)
+		case "delete", "d":
+			d.setBreak(args, false)
+		case "print", "p":
+			d.print(args)
+		case "locals":
+			d.locals()
+		case "backtrace", "bt":
+			d.backtrace()
+		case "frame":
+			d.selectFrame(args)
+		default:
+			fmt.Fprintf(d.out, "unknown command %v\n", cmd)
+		}
+	}
+}
+
+func (d *Debugger) resume(cmd string) {
+	switch cmd {
+	case "step", "s":
+		d.mode = "step"
+	case "next", "n":
+		d.mode = "next"
+	case "finish", "f":
+		d.mode = "finish"
+	case "continue", "c":
+		d.mode = "continue"
+	}
+	d.depth = d.e.Depth()
+}
+
+func (d *Debugger) setBreak(args []string, set bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "expected FILE:LINE or LINE")
+		return
+	}
+	file, line := d.node.Location().Filename, args[0]
+	if i := strings.LastIndex(args[0], ":"); i >= 0 {
+		file, line = args[0][:i], args[0][i+1:]
+	}
+	if _, err := strconv.Atoi(line); err != nil {
+		fmt.Fprintf(d.out, "%v is not a line\n", line)
+		return
+	}
+	at := file + ":" + line
+	if set {
+		d.breaks[at] = true
+		fmt.Fprintf(d.out, "breakpoint at %v\n", at)
+	} else if d.breaks[at] {
+		delete(d.breaks, at)
+		fmt.Fprintf(d.out, "deleted breakpoint at %v\n", at)
+	} else {
+		fmt.Fprintf(d.out, "no breakpoint at %v\n", at)
+	}
+}
+
+// env is the scope of the frame being inspected. Each frame but the
+// innermost is in the scope its inner call was made in.
+func (d *Debugger) env() *evaluator.Evaluator {
+	if d.frame == 0 {
+		return d.e
+	}
+	return d.e.Frames()[d.frame-1].Caller()
+}
+
+func (d *Debugger) print(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "expected a NAME")
+		return
+	}
+	path := strings.Split(args[0], ".")
+	value, has := d.env().Lookup(path[0])
+	if !has {
+		fmt.Fprintf(d.out, "%v is not defined\n", path[0])
+		return
+	}
+	for _, field := range path[1:] {
+		record, is := value.(*evaluator.Record)
+		if !is {
+			fmt.Fprintf(d.out, "%v is not a record\n", show(value))
+			return
+		}
+		i, _ := record.Type.Lookup(field)
+		if i < 0 {
+			fmt.Fprintf(d.out, "%v has no field %v\n", record.Type, field)
+			return
+		}
+		value = record.Fields[i]
+	}
+	fmt.Fprintf(d.out, "%v = %v\n", args[0], show(value))
+	if captured := evaluator.Captured(value); captured != nil {
+		d.names(captured.Locals(), "    ")
+	}
+}
+
+func (d *Debugger) locals() {
+	d.names(d.env().Locals(), "")
+}
+
+// names prints the names sorted with their values.
+func (d *Debugger) names(values map[string]interface{}, indent string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(d.out, "%v%v = %v\n", indent, name, show(values[name]))
+	}
+}
+
+func (d *Debugger) backtrace() {
+	frames := d.e.Frames()
+	for i, f := range frames {
+		mark := " "
+		if i == d.frame {
+			mark = "*"
+		}
+		if f.Fn != nil {
+			fmt.Fprintf(d.out, "%v#%d %v (defined at %v) called at %v\n", mark, i, f.Name, f.Fn.Location(), f.Call.Location())
+		} else {
+			fmt.Fprintf(d.out, "%v#%d %v called at %v\n", mark, i, f.Name, f.Call.Location())
+		}
+	}
+	mark := " "
+	if d.frame == len(frames) {
+		mark = "*"
+	}
+	fmt.Fprintf(d.out, "%v#%d main\n", mark, len(frames))
+}
+
+func (d *Debugger) selectFrame(args []string) {
+	n := -1
+	if len(args) == 1 {
+		n, _ = strconv.Atoi(args[0])
+	}
+	frames := d.e.Frames()
+	if n < 0 || n > len(frames) {
+		fmt.Fprintf(d.out, "expected a frame from 0 to %d\n", len(frames))
+		return
+	}
+	d.frame = n
+	if n == len(frames) {
+		fmt.Fprintf(d.out, "#%d main\n", n)
+	} else {
+		fmt.Fprintf(d.out, "#%d %v called at %v\n", n, frames[n].Name, frames[n].Call.Location())
+	}
+}
+
+// show formats a value for printing, functions by where they are defined
+// rather than by their trees.
+func show(value interface{}) string {
+	if fn, is := value.(evaluator.Parameterized); is {
+		if captured := evaluator.Captured(value); captured != nil {
+			return fmt.Sprintf("<closure %v>", fn.FnType())
+		}
+		if s, is := value.(fmt.Stringer); is && !strings.HasPrefix(s.String(), "<function") {
+			return s.String()
+		}
+		return fmt.Sprintf("<function %v>", fn.FnType())
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,136 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/builtins"
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/frontend"
+)
+
+const program = `
+make = fn(k int) fn(int) int {
+	fn(x int) int { x + k }
+}
+add2 = make(2)
+f = fn(n int) int {
+	if n < 2 {
+		add2(n)
+	} else {
+		m = self(n-1)
+		m + n
+	}
+}
+r = record { a: 1, b: 2.5 }
+print_int(f(3))
+`
+
+// debug runs the program with the commands and gives what was printed.
+func debug(t *testing.T, src string, commands ...string) string {
+	tokens, err := frontend.Lex(src, "test.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Check(node); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	stdout := builtins.Stdout
+	builtins.Stdout = out
+	defer func() { builtins.Stdout = stdout }()
+	in := strings.NewReader(strings.Join(commands, "\n") + "\n")
+	if _, err := Debug(node, in, out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func expect(t *testing.T, out string, expected ...string) {
+	rest := out
+	for _, e := range expected {
+		i := strings.Index(rest, e)
+		if i < 0 {
+			t.Fatalf("expected %q in order in\n%v", e, out)
+		}
+		rest = rest[i+len(e):]
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	out := debug(t, program,
+		"break test.x:8",
+		"continue",
+		"backtrace",
+		"print n",
+		"frame 1",
+		"print n",
+		"frame 3",
+		"print r.b",
+		"print add2",
+		"continue",
+	)
+	expect(t, out,
+		"stopped at test.x:2:1 in main",
+		"breakpoint at test.x:8",
+		"stopped at test.x:8:3 in f",
+		"*#0 f (defined at", "#1 f", "#2 f", "#3 main",
+		"n = 1",
+		"#1 f called at (10-7)-(10-15) in test.x",
+		"n = 2",
+		"#3 main",
+		"r.b = 2.5",
+		"add2 = <closure fn(int)int>",
+		"    k = 2",
+		"8\n",
+		"exited",
+	)
+}
+
+func TestStepping(t *testing.T) {
+	out := debug(t, program,
+		"next",
+		"next",
+		"next",
+		"next",
+		"step",
+		"step",
+		"step",
+		"step",
+		"finish",
+		"quit",
+	)
+	expect(t, out,
+		"stopped at test.x:2:1 in main",
+		// next steps over the bodies of the functions
+		"stopped at test.x:5:1 in main",
+		"stopped at test.x:6:1 in main",
+		"stopped at test.x:14:1 in main",
+		"stopped at test.x:15:1 in main",
+		// step goes into each call of f as it recurses
+		"stopped at test.x:7:2 in f",
+		"stopped at test.x:10:3 in f",
+		"stopped at test.x:7:2 in f",
+		"stopped at test.x:10:3 in f",
+		// finish runs until the recursion returns to the caller
+		"stopped at test.x:11:3 in f",
+	)
+	if strings.Count(out, "stopped at") != 10 || strings.Contains(out, "exited") {
+		t.Errorf("expected the program to be quit in\n%v", out)
+	}
+}
+
+func TestOutOfCommands(t *testing.T) {
+	out := debug(t, program, "break 3")
+	expect(t, out, "breakpoint at test.x:3", "8\n", "exited")
+	if strings.Count(out, "stopped at") != 1 {
+		t.Errorf("expected the program to run to its end in\n%v", out)
+	}
+}
@@ -2,25 +2,140 @@ package evaluator
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 import (
+	"github.com/timtadh/tcel/builtins"
 	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/types"
 	"github.com/timtadh/tcel/table"
 )
 
 func Evaluate(node *frontend.Node) (values []interface{}, err error) {
-	/*defer func() {
-		if e := recover(); e != nil {
+	return EvaluateWith(node, nil)
+}
+
+// Hook is called before each statement and each expression is evaluated
+// with the evaluator about to evaluate it. stmt tells which it is, an
+// expression statement is seen once as each.
+type Hook func(e *Evaluator, node *frontend.Node, stmt bool)
+
+// EvaluateWith evaluates the program calling the hook, if there is one,
+// before each statement and expression.
+func EvaluateWith(node *frontend.Node, hook Hook) (values []interface{}, err error) {
+	e := newEvaluator()
+	e.hook = hook
+	return e.run(node)
+}
+
+func (e *Evaluator) run(node *frontend.Node) (values []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, is := r.(*RuntimeError)
+			if !is {
+				// a failure of the evaluator itself, it happened somewhere
+				// in the calls being run
+				rerr = e.errorf(nil, "%v", r)
+			}
 			values = nil
-			err = fmt.Errorf("%v", e)
+			err = rerr
 		}
-	}()*/
-	e := newEvaluator()
+	}()
 	return e.Stmts(node), nil
 }
 
+// RuntimeError is an error in a running program tied to the node it
+// happened at, if it is known, and the calls being run when it happened.
+type RuntimeError struct {
+	Msg   string
+	Node  *frontend.Node
+	Stack []*Frame // innermost first
+}
+
+// Frame is a call being run: the function called, the name it was called by
+// and the Call.
+type Frame struct {
+	Name   string
+	Fn     *frontend.Node // the Func, nil for builtins
+	Call   *frontend.Node
+	caller *Evaluator
+	scope  int // the depth of the caller's scope when called
+}
+
+// Caller gives the scope the call was made in as it was when made, the
+// scopes the call pushes are left out.
+func (self *Frame) Caller() *Evaluator {
+	c := self.caller
+	return &Evaluator{
+		syms: c.syms.Upto(self.scope),
+		types: c.types,
+		fn: c.fn,
+		modules: c.modules,
+		calls: c.calls,
+	}
+}
+
+// errorf makes a RuntimeError at node in the calls being run.
+func (e *Evaluator) errorf(node *frontend.Node, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{Msg: fmt.Sprintf(format, args...), Node: node, Stack: e.Frames()}
+}
+
+// Depth is the count of the calls being run.
+func (e *Evaluator) Depth() int {
+	return len(*e.calls)
+}
+
+// Frames gives the calls being run, innermost first.
+func (e *Evaluator) Frames() []*Frame {
+	calls := *e.calls
+	stack := make([]*Frame, 0, len(calls))
+	for i := len(calls) - 1; i >= 0; i-- {
+		stack = append(stack, calls[i])
+	}
+	return stack
+}
+
+// at turns a failure while evaluating node which is not yet a RuntimeError
+// into one at node. It is deferred.
+func (e *Evaluator) at(node *frontend.Node) {
+	if r := recover(); r != nil {
+		if _, is := r.(*RuntimeError); is {
+			panic(r)
+		}
+		panic(e.errorf(node, "%v", r))
+	}
+}
+
+func (self *RuntimeError) Error() string {
+	if self.Node == nil {
+		return self.Msg
+	}
+	return fmt.Sprintf("%v at %v", self.Msg, self.Node.Location())
+}
+
+func (self *RuntimeError) Location() *frontend.SourceLocation {
+	if self.Node == nil {
+		return nil
+	}
+	return self.Node.Location()
+}
+
+// Backtrace is the error followed by the calls it happened in, innermost
+// first.
+func (self *RuntimeError) Backtrace() string {
+	lines := []string{self.Error()}
+	for _, f := range self.Stack {
+		if f.Fn != nil {
+			lines = append(lines, fmt.Sprintf("    in %v (defined at %v) called at %v", f.Name, f.Fn.Location(), f.Call.Location()))
+		} else {
+			lines = append(lines, fmt.Sprintf("    in %v called at %v", f.Name, f.Call.Location()))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 func Partial() *Evaluator {
 	return newEvaluator()
 }
@@ -34,12 +149,107 @@ type Evaluator struct {
 	syms   *table.SymbolTable
 	types  *table.SymbolTable
 	fn     *types.Function
+	modules map[string]*Evaluator // the scope of each module by path
+	calls  *[]*Frame // the calls being run, shared by clones
+	hook   Hook
+	profile *Profile
 }
 
 type Box struct {
 	Boxed interface{}
 }
 
+func (self *Box) String() string {
+	return fmt.Sprintf("box(%v)", self.Boxed)
+}
+
+// Record is a record value. Its fields are held in the order of its type.
+type Record struct {
+	Type   *types.Record
+	Fields []interface{}
+}
+
+func (self *Record) String() string {
+	fields := make([]string, 0, len(self.Fields))
+	for i, f := range self.Type.Fields {
+		fields = append(fields, fmt.Sprintf("%v: %v", f.Name, self.Fields[i]))
+	}
+	return fmt.Sprintf("record{%v}", strings.Join(fields, ", "))
+}
+
+func (self *Record) field(node *frontend.Node) int {
+	i, _ := self.Type.Lookup(node.Value.(string))
+	if i < 0 {
+		panic(fmt.Errorf("%v has no field %v", self.Type, node.Serialize(true)))
+	}
+	return i
+}
+
+// Tuple is a tuple value, like a record its elements are shared by reference.
+type Tuple struct {
+	Elems []interface{}
+}
+
+func (self *Tuple) String() string {
+	elems := make([]string, 0, len(self.Elems))
+	for _, e := range self.Elems {
+		elems = append(elems, fmt.Sprint(e))
+	}
+	return fmt.Sprintf("(%v)", strings.Join(elems, ", "))
+}
+
+// Variant is a value of a union. Tag is the variant's index in the union.
+type Variant struct {
+	Name   string
+	Tag    int
+	Fields []interface{}
+}
+
+func (self *Variant) String() string {
+	if len(self.Fields) == 0 {
+		return self.Name
+	}
+	fields := make([]string, 0, len(self.Fields))
+	for _, f := range self.Fields {
+		fields = append(fields, fmt.Sprint(f))
+	}
+	return fmt.Sprintf("%v(%v)", self.Name, strings.Join(fields, ", "))
+}
+
+// constructor makes a Variant from its fields when called.
+type constructor struct {
+	name string
+	tag  int
+	typ  *types.Function
+}
+
+func (self *constructor) FnType() *types.Function {
+	return self.typ
+}
+
+func (self *constructor) ParamNames() []string {
+	return nil
+}
+
+func (self *constructor) String() string {
+	return fmt.Sprintf("<constructor %v>", self.name)
+}
+
+// native is a builtin, it runs as Go.
+type native builtins.Builtin
+
+func (self *native) FnType() *types.Function {
+	return self.Type
+}
+
+func (self *native) ParamNames() []string {
+	return nil
+}
+
+func (self *native) String() string {
+	return fmt.Sprintf("<builtin %v>", self.Name)
+}
+
 type function frontend.Node
 
 func (self *function) FnType() *types.Function {
@@ -82,11 +292,16 @@ func newEvaluator() *Evaluator {
 	e := &Evaluator{
 		syms:  table.NewSymbolTable(),
 		types: table.NewSymbolTable(),
+		modules: make(map[string]*Evaluator),
+		calls: new([]*Frame),
 	}
 	for _, p := range types.Primatives {
 		e.types.Put(string(p), p)
 	}
 	e.syms.Put("unit", types.Unit)
+	for _, b := range builtins.Builtins {
+		e.syms.Put(b.Name, (*native)(b))
+	}
 	return e
 }
 
@@ -95,9 +310,40 @@ func (e *Evaluator) Clone() *Evaluator {
 		syms: table.Copy(e.syms.Capture()),
 		types: table.Copy(e.types.Capture()),
 		fn: e.fn,
+		modules: e.modules,
+		calls: e.calls,
+		hook: e.hook,
+		profile: e.profile,
 	}
 }
 
+// Lookup gives the value of the name in the scope being run.
+func (e *Evaluator) Lookup(name string) (value interface{}, has bool) {
+	value = e.syms.Get(name)
+	return value, value != nil
+}
+
+// Locals gives the names in the scope being run which the program defined,
+// leaving out the builtins.
+func (e *Evaluator) Locals() map[string]interface{} {
+	locals := e.syms.Capture()
+	for name, value := range locals {
+		if _, isnative := value.(*native); isnative || name == "unit" {
+			delete(locals, name)
+		}
+	}
+	return locals
+}
+
+// Captured gives the evaluator a closure runs its function in, nil if the
+// value is not a closure.
+func Captured(value interface{}) *Evaluator {
+	if c, is := value.(*closure); is {
+		return c.e
+	}
+	return nil
+}
+
 func (e *Evaluator) Push() {
 	e.syms.Push()
 	e.types.Push()
@@ -115,22 +361,72 @@ func (e *Evaluator) Pop() {
 
 func (e *Evaluator) Stmts(node *frontend.Node) (values []interface{}) {
 	for _, stmt := range node.Children {
-		values = append(values, e.Stmt(stmt))
+		if stmt.Label == "Module" {
+			values = append(values, e.Module(stmt)...)
+		} else {
+			values = append(values, e.Stmt(stmt))
+		}
 	}
 	return values
 }
 
+// Module runs the statements of a module in a scope of their own keeping
+// the scope for the modules which import it.
+func (e *Evaluator) Module(node *frontend.Node) (values []interface{}) {
+	e.Push()
+	defer e.Pop()
+	values = e.Stmts(node.Get(1))
+	e.modules[node.Value.(string)] = e.Clone()
+	return values
+}
+
 func (e *Evaluator) Stmt(node *frontend.Node) (value interface{}) {
+	if e.hook != nil {
+		e.hook(e, node, true)
+	}
+	if e.profile != nil {
+		defer e.profile.stmt(node)()
+	}
 	switch node.Label {
 	case "Assign":
 		return e.Assign(node)
+	case "TypeAlias", "TypeDecl":
+		return e.TypeDecl(node)
+	case "ModuleDecl", "Import":
+		// the names of imported modules only matter to the checker
+		return types.Unit
 	default:
 		return e.Expr(node)
 	}
 }
 
+// TypeDecl defines the constructors of a union, other declarations only
+// matter to the checker.
+func (e *Evaluator) TypeDecl(node *frontend.Node) (value interface{}) {
+	if node.Get(1).Label != "Union" {
+		return types.Unit
+	}
+	union := types.Underlying(node.Get(0).Type).(*types.Union)
+	for tag, v := range union.Variants {
+		if len(v.Fields) == 0 {
+			e.syms.Put(v.Name, &Variant{Name: v.Name, Tag: tag})
+		} else {
+			t := node.Get(1).Get(tag).Type.(*types.Function)
+			e.syms.Put(v.Name, &constructor{name: v.Name, tag: tag, typ: t})
+		}
+	}
+	return types.Unit
+}
+
 func (e *Evaluator) Assign(node *frontend.Node) (value interface{}) {
 	expr := e.Expr(node.Get(1))
+	if targets := node.Get(0); targets.Label == "Targets" {
+		tuple := expr.(*Tuple)
+		for i, target := range targets.Children {
+			e.assign(target, tuple.Elems[i])
+		}
+		return types.Unit
+	}
 	return e.assign(node.Get(0), expr)
 }
 
@@ -143,6 +439,10 @@ func (e *Evaluator) assign(node *frontend.Node, expr interface{}) (value interfa
 		box := e.Expr(node.Get(0)).(*Box)
 		box.Boxed = expr
 		return types.Unit
+	} else if node.Label == "Field" {
+		record := e.Expr(node.Get(0)).(*Record)
+		record.Fields[record.field(node.Get(1))] = expr
+		return types.Unit
 	}
 	assignee, index := e.assignee(node)
 	assignee[index] = expr
@@ -153,7 +453,7 @@ func (e *Evaluator) assignee(node *frontend.Node) (value []interface{}, i int64)
 	if node.Label == "Index" {
 		item := e.Expr(node.Get(0)).([]interface{})
 		spot := e.Expr(node.Get(1)).(int64)
-		return item, spot
+		return item, e.bounds(node, item, spot)
 	} else {
 		panic(fmt.Errorf("Unxpected node %v", node))
 	}
@@ -167,6 +467,9 @@ func (e *Evaluator) NAME(node *frontend.Node) (string) {
 }
 
 func (e *Evaluator) Expr(node *frontend.Node) (value interface{}) {
+	if e.hook != nil {
+		e.hook(e, node, false)
+	}
 	switch node.Label {
 	case "+", "-", "*", "/", "%":
 		return e.ArithOp(node)
@@ -182,6 +485,8 @@ func (e *Evaluator) Expr(node *frontend.Node) (value interface{}) {
 		return e.Symbol(node)
 	case "Call":
 		return e.Call(node)
+	case "Conversion":
+		return convert(e.Expr(node.Get(1).Get(0)), types.Underlying(node.Type))
 	case "Index":
 		return e.Index(node)
 	case "Func":
@@ -192,14 +497,59 @@ func (e *Evaluator) Expr(node *frontend.Node) (value interface{}) {
 		return e.If(node)
 	case "NEW":
 		return e.New(node)
+	case "Record":
+		return e.Record(node)
+	case "Tuple":
+		return e.Tuple(node)
+	case "Field":
+		return e.Field(node)
+	case "Match":
+		return e.Match(node)
+	case "Instantiate":
+		// type arguments only matter to the checker
+		return e.Expr(node.Get(0))
 	default:
 		panic(fmt.Errorf("unexpected node %v", node))
 	}
 }
 
+func (e *Evaluator) Record(node *frontend.Node) (interface{}) {
+	record := &Record{
+		Type: node.Type.(*types.Record),
+		Fields: make([]interface{}, 0, len(node.Children)),
+	}
+	for _, kid := range node.Children {
+		record.Fields = append(record.Fields, e.Expr(kid.Get(1)))
+	}
+	return record
+}
+
+func (e *Evaluator) Tuple(node *frontend.Node) (interface{}) {
+	tuple := &Tuple{Elems: make([]interface{}, 0, len(node.Children))}
+	for _, kid := range node.Children {
+		tuple.Elems = append(tuple.Elems, e.Expr(kid))
+	}
+	return tuple
+}
+
+func (e *Evaluator) Field(node *frontend.Node) (interface{}) {
+	if mod, is := node.Get(0).Type.(*types.Module); is {
+		// the functions of a module are run in the scope of the module
+		me := e.modules[mod.Path]
+		value := me.syms.Get(node.Get(1).Value.(string))
+		if fn, isfn := value.(*function); isfn {
+			return &closure{fn, me}
+		}
+		return value
+	}
+	record := e.Expr(node.Get(0)).(*Record)
+	defer e.at(node)
+	return record.Fields[record.field(node.Get(1))]
+}
+
 func (e *Evaluator) Symbol(node *frontend.Node) (interface{}) {
 	if sym := e.syms.Get(node.Value.(string)); sym == nil {
-		panic(fmt.Errorf("Unknown name, %v", node.Serialize(true)))
+		panic(e.errorf(node, "Unknown name, %v", node.Value))
 	} else {
 		return sym
 	}
@@ -210,14 +560,19 @@ func (e *Evaluator) New(node *frontend.Node) (interface{}) {
 }
 
 func (e *Evaluator) _new(node *frontend.Node) (interface{}) {
-	t := node.Type
+	t := types.Underlying(node.Type)
 	if p, ok := t.(types.Primative); ok {
 		return &Box{p.Empty()}
 	} else if _, ok := t.(*types.Array); ok {
 		length := e.Expr(node.Get(1)).(int64)
 		arr := make([]interface{}, length)
 		for i := range arr {
-			arr[i] = e._new(node.Get(0))
+			// the elements are values in the array rather than boxes
+			if p, ok := types.Underlying(node.Get(0).Type).(types.Primative); ok {
+				arr[i] = p.Empty()
+			} else {
+				arr[i] = e._new(node.Get(0))
+			}
 		}
 		return arr
 	}
@@ -259,6 +614,23 @@ func (e *Evaluator) If(node *frontend.Node) (value interface{}) {
 	}
 }
 
+func (e *Evaluator) Match(node *frontend.Node) (value interface{}) {
+	v := e.Expr(node.Get(0)).(*Variant)
+	for _, arm := range node.Children[1:] {
+		pattern := arm.Get(0)
+		if e.NAME(pattern.Get(0)) != v.Name {
+			continue
+		}
+		e.Push()
+		defer e.Pop()
+		for i, b := range pattern.Get(1).Children {
+			e.syms.Put(e.NAME(b), v.Fields[i])
+		}
+		return e.Expr(arm.Get(1))
+	}
+	panic(e.errorf(node, "No arm matches %v", v))
+}
+
 func (e *Evaluator) Params(node *frontend.Node) (values []interface{}) {
 	for _, expr := range node.Children {
 		values = append(values, e.Expr(expr))
@@ -266,45 +638,134 @@ func (e *Evaluator) Params(node *frontend.Node) (values []interface{}) {
 	return values
 }
 
+// convert gives the value converted to the type. Numbers become the other
+// kind of number, floats are truncated, or their decimal strings as the
+// runtime formats them. Anything else stays as it is.
+func convert(value interface{}, to types.Type) interface{} {
+	switch v := value.(type) {
+	case int64:
+		switch to {
+		case types.Float: return float64(v)
+		case types.String: return strconv.FormatInt(v, 10)
+		}
+	case float64:
+		switch to {
+		case types.Int: return int64(v)
+		case types.String: return builtins.FormatFloat(v)
+		}
+	}
+	return value
+}
+
 func (e *Evaluator) Call(node *frontend.Node) (value interface{}) {
+	scope := e.syms.Depth()
 	e.Push()
 	defer e.Pop()
-	callee := e.Expr(node.Get(0)).(Parameterized)
+	called := e.Expr(node.Get(0))
+	callee, is := called.(Parameterized)
+	if !is {
+		panic(e.errorf(node.Get(0), "%v is not a function", called))
+	}
 	params := e.Expr(node.Get(1)).([]interface{})
+	if ctor, isctor := callee.(*constructor); isctor {
+		return &Variant{Name: ctor.name, Tag: ctor.tag, Fields: params}
+	} else if b, isnative := callee.(*native); isnative {
+		e.enter(node, nil, scope)
+		value = e.native(node, b, params)
+		e.leave()
+		return value
+	}
 	var fne *Evaluator
-	var callee_stmts *frontend.Node
+	var callee_node *frontend.Node
 	if closed, isclosure := callee.(*closure); isclosure {
+		// the parameters must not replace the names closed over
 		fne = closed.e
-		callee_stmts = (*frontend.Node)(closed.fn).Get(2)
+		fne.Push()
+		defer fne.Pop()
+		callee_node = (*frontend.Node)(closed.fn)
 	} else if fn, isfn := callee.(*function); isfn {
 		fne = e
-		callee_stmts = (*frontend.Node)(fn).Get(2)
+		callee_node = (*frontend.Node)(fn)
 	} else {
-		panic("something besides a function or a closure")
+		panic(e.errorf(node.Get(0), "%v is not a function", callee))
 	}
 	for i, param_name := range callee.ParamNames() {
 		fne.syms.Put(param_name, params[i])
 	}
 	fne.syms.Put("self", callee)
-	values := fne.Stmts(callee_stmts)
+	e.enter(node, callee_node, scope)
+	values := fne.Stmts(callee_node.Get(2))
+	e.leave()
 	ret := values[len(values)-1]
-	if _, retfn := callee.FnType().Returns.(*types.Function); retfn {
-		ret := &closure{(ret.(*function)), fne.Clone()}
-		return ret
+	if _, retfn := types.Underlying(callee.FnType().Returns).(*types.Function); retfn {
+		if fn, isfn := ret.(*function); isfn {
+			return &closure{fn, fne.Clone()}
+		}
 	}
 	return ret
 }
 
+// enter records the call of fn as being run. The call is left when it
+// returns, not when it fails, so the calls a failure happened in are known
+// when it is recovered from.
+func (e *Evaluator) enter(call, fn *frontend.Node, scope int) {
+	f := &Frame{Name: e.callee(call.Get(0)), Fn: fn, Call: call, caller: e, scope: scope}
+	*e.calls = append(*e.calls, f)
+	if e.profile != nil {
+		e.profile.enter(f)
+	}
+}
+
+func (e *Evaluator) leave() {
+	*e.calls = (*e.calls)[:len(*e.calls)-1]
+	if e.profile != nil {
+		e.profile.leave()
+	}
+}
+
+// callee is the name a function is called by for a backtrace.
+func (e *Evaluator) callee(node *frontend.Node) string {
+	switch node.Label {
+	case "NAME":
+		name := node.Value.(string)
+		if calls := *e.calls; name == "self" && len(calls) > 0 {
+			return calls[len(calls)-1].Name
+		}
+		return name
+	case "Field":
+		return e.callee(node.Get(0)) + "." + node.Get(1).Value.(string)
+	case "Instantiate":
+		return e.callee(node.Get(0))
+	}
+	return "<function>"
+}
+
+// native runs the builtin, its failures are failures of the call.
+func (e *Evaluator) native(node *frontend.Node, b *native, params []interface{}) interface{} {
+	defer e.at(node)
+	return b.Eval(params)
+}
+
 func (e *Evaluator) Index(node *frontend.Node) (value interface{}) {
 	indexed := e.Expr(node.Get(0)).([]interface{})
 	index := e.Expr(node.Get(1)).(int64)
-	return indexed[index]
+	return indexed[e.bounds(node, indexed, index)]
+}
+
+// bounds gives the index if it is in the array or stops the program with an
+// error at the Index node.
+func (e *Evaluator) bounds(node *frontend.Node, array []interface{}, index int64) int64 {
+	if index < 0 || index >= int64(len(array)) {
+		panic(e.errorf(node, "index %d out of bounds for an array of length %d", index, len(array)))
+	}
+	return index
 }
 
 func (e *Evaluator) ArithOp(node *frontend.Node) (value interface{}) {
 	a := e.Expr(node.Get(0))
 	b := e.Expr(node.Get(1))
-	switch node.Get(0).Type.String() {
+	defer e.at(node)
+	switch types.Underlying(node.Get(0).Type).String() {
 	case "int": return e.IntArithOp(node.Label, a.(int64), b.(int64))
 	case "float": return e.FloatArithOp(node.Label, a.(float64), b.(float64))
 	case "string": return e.StringArithOp(node.Label, a.(string), b.(string))
@@ -315,7 +776,7 @@ func (e *Evaluator) ArithOp(node *frontend.Node) (value interface{}) {
 func (e *Evaluator) UnaryOp(node *frontend.Node) (value interface{}) {
 	a := e.Expr(node.Get(0))
 	if node.Label == "Negate" {
-		switch node.Get(0).Type.String() {
+		switch types.Underlying(node.Get(0).Type).String() {
 		case "int": return - a.(int64)
 		case "float": return - a.(float64)
 		}
@@ -387,7 +848,7 @@ func (e *Evaluator) Not(node *frontend.Node) (bool) {
 func (e *Evaluator) CmpOp(node *frontend.Node) (bool) {
 	a := e.Expr(node.Get(0))
 	b := e.Expr(node.Get(1))
-	switch node.Get(0).Type.String() {
+	switch types.Underlying(node.Get(0).Type).String() {
 	case "int": return e.IntCmpOp(node.Label, a.(int64), b.(int64))
 	case "float": return e.FloatCmpOp(node.Label, a.(float64), b.(float64))
 	case "string": return e.StringCmpOp(node.Label, a.(string), b.(string))
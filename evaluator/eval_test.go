@@ -0,0 +1,485 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/builtins"
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/frontend"
+)
+
+func check(t *testing.T, src string) (*frontend.Node, error) {
+	tokens, err := frontend.Lex(src, "test.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node, checker.Check(node)
+}
+
+func eval(t *testing.T, src string) []interface{} {
+	node, err := check(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := Evaluate(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func last(values []interface{}) string {
+	return fmt.Sprintf("%v", values[len(values)-1])
+}
+
+func TestRecords(t *testing.T) {
+	values := eval(t, `
+p = record { x: 1, y: 2.5 }
+move = fn(q record { x int, y float }) record { x int, y float } {
+	q.x = q.x + 1
+	q
+}
+r = move(p)
+p.x + r.x
+`)
+	if got := last(values); got != "4" {
+		t.Errorf("expected records to be shared by reference got %v", got)
+	}
+	values = eval(t, `
+line = record { from: record { x: 1 }, to: record { x: 5 } }
+line.to.x = line.to.x * 2
+line
+`)
+	if got := last(values); got != "record{from: record{x: 1}, to: record{x: 10}}" {
+		t.Errorf("unexpected record %v", got)
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	bad := []string{
+		"p = record { x: 1, x: 2 }\n",
+		"p = record { x: 1 }\np.y\n",
+		"p = record { x: 1 }\np.x = \"one\"\n",
+		"x = 1\nx.y\n",
+		"f = fn(p record { x int, x int }) int {\n\t1\n}\n",
+		"p = record { x: 1, y: 2 }\nf = fn(q record { y int, x int }) int {\n\tq.x\n}\nf(p)\n",
+		"type P = record { x int, y int }\nb = new P\n",
+		"type P = record { x int, y int }\nn = 3\nb = new [n]P\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestNamedTypes(t *testing.T) {
+	values := eval(t, `
+type I = int
+type Celsius float
+type Point record { x int, y int }
+f = fn(x I) int { x + 1 }
+p = Point(record { x: 1, y: 2 })
+norm = fn(p Point) int { p.x * p.x + p.y * p.y }
+f(norm(p))
+`)
+	if got := last(values); got != "6" {
+		t.Errorf("expected 6 got %v", got)
+	}
+	values = eval(t, "type Celsius float\nhot = Celsius(30.0) + Celsius(2.5)\nhot\n")
+	if got := last(values); got != "32.5" {
+		t.Errorf("expected named floats to add got %v", got)
+	}
+}
+
+func TestNamedTypeErrors(t *testing.T) {
+	bad := []string{
+		"type C float\nx = C(1.0) + 1.0\n",
+		"type A int\ntype A float\n",
+		"type A int\nx = A(1.0)\n",
+		"type A int\nx = A(1, 2)\n",
+		"type P record { x int }\nf = fn(p P) int { p.x }\nf(record { x: 1 })\n",
+		"f = fn() int {\n\ttype T int\n\t1\n}\nx = T(1)\n",
+		"type Row [5]int\nx = new Row\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	values := eval(t, `
+type Option = Some(int) | None
+get = fn(o Option, d int) int {
+	match o {
+		Some(x) => x,
+		None => d,
+	}
+}
+get(Some(3), 1) + get(None, 10)
+`)
+	if got := last(values); got != "13" {
+		t.Errorf("expected 13 got %v", got)
+	}
+	values = eval(t, `
+type List Cons(int, List) | Nil
+sum = fn(l List) int {
+	match l {
+		Cons(x, rest) => x + self(rest),
+		Nil => 0,
+	}
+}
+l = Cons(1, Cons(2, Cons(3, Nil)))
+sum(l)
+l
+`)
+	if got := values[len(values)-2]; fmt.Sprint(got) != "6" {
+		t.Errorf("expected the list to sum to 6 got %v", got)
+	}
+	if got := last(values); got != "Cons(1, Cons(2, Cons(3, Nil)))" {
+		t.Errorf("unexpected list %v", got)
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	bad := []string{
+		"type O = Some(int) | None\nx = match None { Some(x) => x }\n",
+		"type O = Some(int) | None\nx = match None { Some(x) => x, None => 1.0 }\n",
+		"type O = Some(int) | None\nx = match None { Some(x) => x, None => 1, None => 2 }\n",
+		"type O = Some(int) | None\nx = match None { Some(x, y) => x, None => 1 }\n",
+		"type O = Some(int) | None\nx = match None { Some(x) => x, Other => 1 }\n",
+		"type O = Some(int) | None\nx = match 1 { Some(x) => x, None => 1 }\n",
+		"type O = A | A\n",
+		"type P = Pair(int, int) | Empty\nx = match Empty { Pair(a, a) => a, Empty => 1 }\n",
+		"type L = Cons(int, L) | Nil\n",
+		"type O = Some(int) | None\nx = Some(1.5)\n",
+		"type O = Some(int) | None\nx = new O\n",
+		"type T T\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestGenerics(t *testing.T) {
+	values := eval(t, `
+id = fn[T](x T) T { x }
+apply = fn[A, B](f fn(A) B, x A) B { f(x) }
+inc = fn(x int) int { x + 1 }
+id(3) + id[int](4) + apply(inc, 5)
+`)
+	if got := last(values); got != "13" {
+		t.Errorf("expected 13 got %v", got)
+	}
+	values = eval(t, `
+twice = fn[T](f fn(T) T, x T) T { f(f(x)) }
+half = fn(x float) float { x / 2.0 }
+twice(half, 10.0)
+`)
+	if got := last(values); got != "2.5" {
+		t.Errorf("expected 2.5 got %v", got)
+	}
+}
+
+func TestGenericErrors(t *testing.T) {
+	bad := []string{
+		"id = fn[T](x T) T { x }\nx = id(1) + 1.5\n",
+		"f = fn[T](x T) T { x + 1 }\n",
+		"f = fn[T, T](x T) T { x }\n",
+		"pick = fn[A, B](a A) A { a }\nx = pick(1)\n",
+		"id = fn[T](x T) T { x }\nx = id[int](1.5)\n",
+		"inc = fn(x int) int { x + 1 }\nx = inc[int](1)\n",
+		"same = fn[T](a T, b T) T { a }\nx = same(1, 2.5)\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestInference(t *testing.T) {
+	values := eval(t, `
+inc = fn(x) { x + 1 }
+twice = fn(f, x) { f(f(x)) }
+id = fn(x) { x }
+twice(inc, 1) + id(2)
+`)
+	if got := last(values); got != "5" {
+		t.Errorf("expected 5 got %v", got)
+	}
+	values = eval(t, `
+id = fn(x) { x }
+compose = fn(f, g) fn(int) int { fn(x) { f(g(x)) } }
+half = fn(x) { x / 2.0 }
+h = compose(fn(x) { x * 2 }, fn(x int) { x + 1 })
+id(half(5.0)) + id(1.5)
+`)
+	if got := last(values); got != "4" {
+		t.Errorf("expected 4 got %v", got)
+	}
+}
+
+func TestInferenceErrors(t *testing.T) {
+	bad := []string{
+		"f = fn(x) { x + 1 }\ny = f(1.5)\n",
+		"f = fn(x) { x(x) }\n",
+		"f = fn(x) { if x > 1 { x } else { 2.5 } }\n",
+		"g = fn() int {\n\tid = fn(x) { x }\n\tid(1)\n\tid(1.5)\n\t1\n}\n",
+		"g = fn() int {\n\tf = fn(x) { x }\n\t1\n}\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestTuples(t *testing.T) {
+	values := eval(t, `
+divmod = fn(a int, b int) (int, int) { (a / b, a % b) }
+(q, r) = divmod(17, 5)
+(q, r) = (r, q)
+q * 10 + r
+`)
+	if got := last(values); got != "23" {
+		t.Errorf("expected 23 got %v", got)
+	}
+	values = eval(t, `
+p = record { x: 1, name: "a" }
+swap = fn(t (int, string)) (string, int) {
+	(x, s) = t
+	(s, x)
+}
+(p.name, p.x) = swap((2, "b"))
+(p, swap((p.x, p.name)))
+`)
+	if got := last(values); got != "(record{x: 2, name: b}, (b, 2))" {
+		t.Errorf("unexpected tuple %v", got)
+	}
+}
+
+func TestTupleErrors(t *testing.T) {
+	bad := []string{
+		"(x, y) = (1, 2, 3)\n",
+		"(x, y) = 1\n",
+		"(x, x) = (1, 2)\n",
+		"x = 1\n(x, y) = (1.5, 2)\n",
+		"f = fn(t (int, int)) int { 1 }\nf((1, 2.5))\n",
+		"type P = (int, int)\nt = new P\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	var out bytes.Buffer
+	builtins.Stdout = &out
+	defer func() { builtins.Stdout = os.Stdout }()
+	values := eval(t, `
+s = "hello world"
+print(substr(s, 0, strlen(s) - 6))
+a = new [3]int
+print_int(len(a))
+print_float(sqrt(int_to_float(16)) + pow(2.0, 3.0))
+print(int_to_string(float_to_int(floor(3.7))) + float_to_string(abs(-1.5)))
+string_to_int("40") + len(new [2]float)
+`)
+	if got := last(values); got != "42" {
+		t.Errorf("expected 42 got %v", got)
+	}
+	if got := out.String(); got != "hello\n3\n12\n31.5\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	bad := []string{
+		"strlen(1)\n",
+		"len(\"abc\")\n",
+		"substr(\"abc\", 1)\n",
+		"sqrt(2)\n",
+		"int_to_float(1.5)\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+	values := eval(t, `
+i = 7
+f = float(i) / 2.0
+n = int(f) + int(-2.7)
+type A int
+(string(n) + " " + string(f), float(A(3)) * 1.5)
+`)
+	if got := last(values); got != "(1 3.5, 4.5)" {
+		t.Errorf("unexpected conversions %v", got)
+	}
+	// floats are strings as the runtime prints them
+	values = eval(t, "string(1.0 / 3.0) + \" \" + string(float(1000000))\n")
+	if got := last(values); got != "0.333333 1e+06" {
+		t.Errorf("expected 0.333333 1e+06 got %v", got)
+	}
+	values = eval(t, "half = fn(x) { float(x) / 2.0 }\nhalf(3.0)\n")
+	if got := last(values); got != "1.5" {
+		t.Errorf("expected 1.5 got %v", got)
+	}
+	// T names the type where to_t is written though the caller of to_t
+	// binds T to a value
+	values = eval(t, `
+type T int
+to_t = fn(x int) T { T(x) }
+g = fn(T int) T { to_t(T) }
+g(5)
+`)
+	if got := last(values); got != "5" {
+		t.Errorf("expected the conversion to give 5 got %v", got)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	bad := []string{
+		"int(\"1\")\n",
+		"float(record { x: 1 })\n",
+		"string(1, 2)\n",
+		"x = 1 + 2.0\n",
+		"type C float\nc = C(1)\n",
+	}
+	for _, src := range bad {
+		if _, err := check(t, src); err == nil {
+			t.Errorf("expected a type error for\n%v", src)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	values := eval(t, `
+m = new [2][3]int
+m[1][2] = 5
+m[0][0] = m[1][2] + 1
+m[0][0] * m[1][2]
+`)
+	if got := last(values); got != "30" {
+		t.Errorf("expected 30 got %v", got)
+	}
+	bad := map[string]string{
+		"a = new [3]int\na[3]\n":                "index 3 out of bounds for an array of length 3 at (2-1)-(2-4) in test.x",
+		"a = new [3]int\ni = 0 - 1\na[i] = 1\n": "index -1 out of bounds for an array of length 3 at (3-1)-(3-4) in test.x",
+		"m = new [2][3]int\nm[1][3]\n":          "index 3 out of bounds for an array of length 3 at (2-1)-(2-7) in test.x",
+	}
+	for src, msg := range bad {
+		node, err := check(t, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Evaluate(node)
+		if _, is := err.(*RuntimeError); !is {
+			t.Errorf("expected a runtime error for\n%v got %v", src, err)
+		} else if err.Error() != msg {
+			t.Errorf("expected %q got %q", msg, err)
+		}
+	}
+}
+
+func TestNewArrays(t *testing.T) {
+	values := eval(t, `
+a = new [2]float
+m = new [2][3]int
+m[1][2] = 4
+string(a[1]) + " " + string(m[0][1] + m[1][2])
+`)
+	if got := last(values); got != "0 4" {
+		t.Errorf("expected 0 4 got %v", got)
+	}
+}
+
+func TestRows(t *testing.T) {
+	values := eval(t, `
+a = new [3][4]int
+a[1][2] = 5
+b = a[1]
+sum = fn(r [4]int) int { r[0] + r[2] }
+a[2] = new [4]int
+a[2][0] = 1
+(a[0], x) = (new [4]int, 2)
+sum(b) + sum(a[2]) * 10 + len(a[1]) * 100 + x * 1000
+`)
+	if got := last(values); got != "2415" {
+		t.Errorf("expected 2415 got %v", got)
+	}
+	node, err := check(t, "a = new [3][4]int\nb = a[1]\nb[4]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Evaluate(node)
+	msg := "index 4 out of bounds for an array of length 4 at (3-1)-(3-4) in test.x"
+	if err == nil || err.Error() != msg {
+		t.Errorf("expected %q got %v", msg, err)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	node, err := check(t, `
+div = fn(a int, b int) int {
+	a / b
+}
+count = fn(n int) int {
+	if n <= 0 {
+		div(1, n)
+	} else {
+		self(n - 1)
+	}
+}
+count(1)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Evaluate(node)
+	rerr, is := err.(*RuntimeError)
+	if !is {
+		t.Fatalf("expected a runtime error got %v", err)
+	}
+	if rerr.Error() != "Divide by 0 at (3-2)-(3-6) in test.x" {
+		t.Errorf("unexpected error %v", rerr)
+	}
+	names := make([]string, 0, len(rerr.Stack))
+	for _, f := range rerr.Stack {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "div count count" {
+		t.Errorf("unexpected stack %v", got)
+	}
+	if lines := strings.Split(rerr.Backtrace(), "\n"); len(lines) != 4 || lines[3] != "    in count (defined at (5-9)-(11-1) in test.x) called at (12-1)-(12-8) in test.x" {
+		t.Errorf("unexpected backtrace\n%v", rerr.Backtrace())
+	}
+
+	node, err = check(t, "x = \"\"\nx = substr(\"ab\", 1, 5)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Evaluate(node); err == nil || err.Error() != `substr("ab", 1, 5) is out of range at (2-5)-(2-22) in test.x` {
+		t.Errorf("expected the builtin to fail at its call got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+// Profile counts the calls of each function and the runs of each statement
+// with the time spent in them. Functions and statements are known by their
+// source spans. The time of a recursive function or statement is counted
+// once for its outermost run.
+type Profile struct {
+	Functions map[string]*Count
+	Stmts     map[string]*Count
+
+	stacks  map[string]time.Duration // the time spent in each call stack alone
+	active  []*activation
+	running map[*Count]int
+	labels  map[*frontend.Node]string
+	counts  map[*frontend.Node]*Count
+	now     func() time.Time
+}
+
+// Count is how many times a function was called or a statement run and
+// the time spent in it.
+type Count struct {
+	Name  string
+	Calls int
+	Time  time.Duration
+}
+
+type activation struct {
+	count    *Count
+	stack    string // the folded stack of the call
+	start    time.Time
+	children time.Duration
+}
+
+func NewProfile() *Profile {
+	return &Profile{
+		Functions: make(map[string]*Count),
+		Stmts:     make(map[string]*Count),
+		stacks:    make(map[string]time.Duration),
+		running:   make(map[*Count]int),
+		labels:    make(map[*frontend.Node]string),
+		counts:    make(map[*frontend.Node]*Count),
+		now:       time.Now,
+	}
+}
+
+// Profiled evaluates the program counting its calls and statements in the
+// profile.
+func Profiled(node *frontend.Node, profile *Profile) (values []interface{}, err error) {
+	e := newEvaluator()
+	e.profile = profile
+	profile.begin()
+	defer profile.end()
+	return e.run(node)
+}
+
+func (self *Profile) begin() {
+	self.active = []*activation{{stack: "main", start: self.now()}}
+}
+
+// end counts the time of the calls left running by a failure and of main.
+func (self *Profile) end() {
+	for len(self.active) > 0 {
+		self.leave()
+	}
+}
+
+// label is how a function is known, its name and where it is defined.
+// Builtins are known by their names.
+func (self *Profile) label(f *Frame) string {
+	if f.Fn == nil {
+		return f.Name
+	}
+	if label, has := self.labels[f.Fn]; has {
+		return label
+	}
+	label := f.Name
+	if loc := f.Fn.Location(); loc != nil {
+		label = fmt.Sprintf("%v@%v:%d:%d", f.Name, loc.Filename, loc.StartLine, loc.StartColumn)
+	}
+	self.labels[f.Fn] = label
+	return label
+}
+
+func (self *Profile) enter(f *Frame) {
+	label := self.label(f)
+	count, has := self.Functions[label]
+	if !has {
+		count = &Count{Name: f.Name}
+		self.Functions[label] = count
+	}
+	count.Calls++
+	self.running[count]++
+	caller := self.active[len(self.active)-1]
+	self.active = append(self.active, &activation{
+		count: count,
+		stack: caller.stack + ";" + label,
+		start: self.now(),
+	})
+}
+
+func (self *Profile) leave() {
+	a := self.active[len(self.active)-1]
+	self.active = self.active[:len(self.active)-1]
+	total := self.now().Sub(a.start)
+	self.stacks[a.stack] += total - a.children
+	if len(self.active) > 0 {
+		self.active[len(self.active)-1].children += total
+	}
+	if a.count == nil {
+		return
+	}
+	self.running[a.count]--
+	if self.running[a.count] == 0 {
+		a.count.Time += total
+	}
+}
+
+// stmt starts timing a run of the statement, the function it gives stops.
+func (self *Profile) stmt(node *frontend.Node) func() {
+	count, has := self.counts[node]
+	if !has {
+		key := node.Label
+		if loc := node.Location(); loc != nil {
+			key = fmt.Sprintf("%v:%d:%d-%d:%d", loc.Filename, loc.StartLine, loc.StartColumn, loc.EndLine, loc.EndColumn)
+		}
+		if count, has = self.Stmts[key]; !has {
+			count = &Count{Name: node.Label}
+			self.Stmts[key] = count
+		}
+		self.counts[node] = count
+	}
+	count.Calls++
+	self.running[count]++
+	start := self.now()
+	return func() {
+		self.running[count]--
+		if self.running[count] == 0 {
+			count.Time += self.now().Sub(start)
+		}
+	}
+}
+
+// WriteFolded writes the time spent in each call stack alone in
+// nanoseconds, one stack a line with its frames separated by semicolons,
+// as flame graph tools read.
+func (self *Profile) WriteFolded(w io.Writer) error {
+	stacks := make([]string, 0, len(self.stacks))
+	for stack := range self.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%v %d\n", stack, self.stacks[stack].Nanoseconds()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String gives a table of the functions then of the statements, each by
+// the time spent in them.
+func (self *Profile) String() string {
+	lines := []string{fmt.Sprintf("%10v %14v  %v", "calls", "cumulative", "function")}
+	lines = append(lines, rows(self.Functions)...)
+	lines = append(lines, "", fmt.Sprintf("%10v %14v  %v", "runs", "cumulative", "statement"))
+	lines = append(lines, rows(self.Stmts)...)
+	return strings.Join(lines, "\n")
+}
+
+func rows(counts map[string]*Count) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := counts[keys[i]], counts[keys[j]]
+		if a.Time != b.Time {
+			return a.Time > b.Time
+		}
+		return keys[i] < keys[j]
+	})
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		c := counts[key]
+		lines = append(lines, fmt.Sprintf("%10d %14v  %v", c.Calls, c.Time, key))
+	}
+	return lines
+}
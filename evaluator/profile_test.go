@@ -0,0 +1,83 @@
+package evaluator
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfile(t *testing.T) {
+	node, err := check(t, `
+f = fn(n int) int {
+	if n < 2 {
+		sqrt(1.0)
+		n
+	} else {
+		self(n-1) + self(n-2)
+	}
+}
+f(5)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile()
+	// each reading of the clock takes a millisecond
+	var clock time.Time
+	profile.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	values, err := Profiled(node, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := last(values); got != "5" {
+		t.Fatalf("expected 5 got %v", got)
+	}
+	f := profile.Functions["f@test.x:2:5"]
+	if f == nil || f.Calls != 15 {
+		t.Fatalf("expected f to be called 15 times in %v", profile)
+	}
+	if sqrt := profile.Functions["sqrt"]; sqrt == nil || sqrt.Calls != 8 {
+		t.Errorf("expected sqrt to be called 8 times in %v", profile)
+	}
+	if s := profile.Stmts["test.x:4:3-4:11"]; s == nil || s.Calls != 8 {
+		t.Errorf("expected the call of sqrt to run 8 times in %v", profile)
+	}
+	call := profile.Stmts["test.x:10:1-10:4"]
+	if call == nil || call.Time < f.Time {
+		t.Fatalf("expected the call of f to take as long as f in %v", profile)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := profile.WriteFolded(buf); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	stacks := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		i := strings.LastIndex(line, " ")
+		n, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+		stacks[line[:i]] = true
+	}
+	for _, stack := range []string{
+		"main",
+		"main;f@test.x:2:5",
+		"main;f@test.x:2:5;f@test.x:2:5;f@test.x:2:5;f@test.x:2:5;sqrt",
+	} {
+		if !stacks[stack] {
+			t.Errorf("expected the stack %v in\n%v", stack, buf)
+		}
+	}
+	// the time alone in each stack adds up to the time of the program
+	if total < call.Time.Nanoseconds() {
+		t.Errorf("expected the stacks to take at least %v got %v", call.Time, time.Duration(total))
+	}
+}
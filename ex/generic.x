@@ -0,0 +1,21 @@
+id = fn[T](x T) T {
+	x
+}
+apply = fn[A, B](f fn(A) B, x A) B {
+	f(x)
+}
+compose = fn[A, B, C](f fn(B) C, g fn(A) B, x A) C {
+	f(g(x))
+}
+
+inc = fn(x int) int {
+	x + 1
+}
+half = fn(x float) float {
+	x / 2.0
+}
+
+id(1) + id[int](2)
+apply(inc, 41)
+compose(half, half, 10.0)
+apply(id[int], 7)
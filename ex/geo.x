@@ -0,0 +1,13 @@
+module geo
+
+type Point record { x int, y int }
+
+square = fn(x int) int {
+	x * x
+}
+
+Origin = Point(record { x: 0, y: 0 })
+
+Dist = fn(a Point, b Point) int {
+	square(a.x - b.x) + square(a.y - b.y)
+}
@@ -0,0 +1,13 @@
+inc = fn(x) { x + 1 }
+twice = fn(f, x) { f(f(x)) }
+id = fn(x) { x }
+sum = fn(n) {
+	if n <= 0 {
+		0
+	} else {
+		n + self(n - 1)
+	}
+}
+twice(inc, 1)
+id(5)
+sum(id(4))
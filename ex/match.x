@@ -0,0 +1,19 @@
+type Option = Some(int) | None
+type List Cons(int, List) | Nil
+
+// get is the value in o or d if there is none
+get = fn(o Option, d int) int {
+	match o {
+		Some(x) => x,
+		None => d,
+	}
+}
+get(Some(3), 0) + get(None, 10)
+
+sum = fn(l List) int {
+	match l {
+		Cons(x, rest) => x + self(rest),
+		Nil => 0,
+	}
+}
+sum(Cons(1, Cons(2, Cons(3, Nil))))
@@ -0,0 +1,5 @@
+import "geo"
+
+square = 10
+p = geo.Point(record { x: 3, y: 4 })
+geo.Dist(geo.Origin, p) + square
@@ -0,0 +1,9 @@
+origin = record { x: 0, y: 0 }
+translate = fn(p record { x int, y int }, dx int, dy int) record { x int, y int } {
+	record { x: p.x + dx, y: p.y + dy }
+}
+p = translate(origin, 3, 4)
+p.x = p.x * 2
+p.x + p.y
+line = record { from: origin, to: p, name: "diagonal" }
+line.to.y
@@ -0,0 +1,8 @@
+divmod = fn(a int, b int) (int, int) {
+	(a / b, a % b)
+}
+
+(q, r) = divmod(17, 5)
+(q, r) = (r, q)
+q
+r
@@ -0,0 +1,22 @@
+type Adder = fn(int) int
+type Point record { x int, y int }
+type Celsius float
+
+adder = fn(x int) Adder {
+	fn(y int) int {
+		x + y
+	}
+}
+add5 = adder(5)
+add5(10)
+
+origin = Point(record { x: 0, y: 0 })
+shift = fn(p Point, by int) Point {
+	p.x = p.x + by
+	p
+}
+shift(origin, 3).x
+
+boiling = Celsius(100.0)
+warmer = boiling + Celsius(1.5)
+warmer
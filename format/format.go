@@ -0,0 +1,582 @@
+// Package format pretty prints TCEL programs in a canonical style: one
+// statement per line, blocks indented with tabs, single spaces around binary
+// operators and at most one blank line between statements. Comments are
+// carried over from the source.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+// Source formats a whole TCEL source file.
+func Source(src []byte, filename string) ([]byte, error) {
+	tokens, eof, err := frontend.LexTrivia(string(src), filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		var buf bytes.Buffer
+		for _, c := range comments(eof) {
+			buf.WriteString(c.Text)
+			buf.WriteString("\n")
+		}
+		return buf.Bytes(), nil
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Node(&buf, node, tokens, eof); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Node writes the program rooted at the Stmts node to w. The tokens the
+// program was parsed from, if given, supply its comments and blank lines;
+// eof holds the trivia after the last token. Each comment is placed
+// before the first statement which starts after it, or at the end of the
+// statement it falls in.
+func Node(w io.Writer, node *frontend.Node, tokens []*frontend.Token, eof []*frontend.Trivia) error {
+	if node.Label != "Stmts" {
+		return fmt.Errorf("expected a Stmts node got %v", node)
+	}
+	p := new(printer)
+	for _, tok := range tokens {
+		p.comments = append(p.comments, comments(tok.Leading)...)
+		p.comments = append(p.comments, comments(tok.Trailing)...)
+		p.spans = append(p.spans, &frontend.SourceLocation{
+			StartLine: tok.StartLine, StartColumn: tok.StartColumn,
+			EndLine: tok.EndLine, EndColumn: tok.EndColumn,
+		})
+	}
+	p.comments = append(p.comments, comments(eof)...)
+	for _, c := range p.comments {
+		p.spans = append(p.spans, &c.SourceLocation)
+	}
+	sort.SliceStable(p.comments, func(i, j int) bool {
+		return before(&p.comments[i].SourceLocation, p.comments[j].StartLine, p.comments[j].StartColumn)
+	})
+	sort.SliceStable(p.spans, func(i, j int) bool {
+		return before(p.spans[i], p.spans[j].StartLine, p.spans[j].StartColumn)
+	})
+	p.stmts(node, nil)
+	p.flush(-1, -1)
+	p.write("\n")
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+func comments(trivia []*frontend.Trivia) (cs []*frontend.Trivia) {
+	for _, t := range trivia {
+		if t.Kind == "COMMENT" {
+			cs = append(cs, t)
+		}
+	}
+	return cs
+}
+
+// before reports whether loc starts before line:col.
+func before(loc *frontend.SourceLocation, line, col int) bool {
+	if line < 0 {
+		return true
+	}
+	return loc.StartLine < line || (loc.StartLine == line && loc.StartColumn < col)
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	indent   int
+	comments []*frontend.Trivia
+	spans    []*frontend.SourceLocation // tokens and comments in source order
+	opened   bool // a block was just opened
+}
+
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+}
+
+// blank reports whether the source had a blank line between the item
+// starting at line:col and the one before it.
+func (p *printer) blank(line, col int) bool {
+	i := sort.Search(len(p.spans), func(i int) bool {
+		return !before(p.spans[i], line, col)
+	})
+	return i > 0 && line > p.spans[i-1].EndLine+1
+}
+
+// line starts a new line at the current indent for the item starting at
+// start, keeping a single blank line if the source had one. Blank lines at
+// the top of a block are dropped.
+func (p *printer) line(start *frontend.SourceLocation) {
+	if p.buf.Len() > 0 {
+		p.write("\n")
+		if start != nil && !p.opened && p.blank(start.StartLine, start.StartColumn) {
+			p.write("\n")
+		}
+	}
+	p.opened = false
+	p.write(strings.Repeat("\t", p.indent))
+}
+
+// flush prints, each on their own line, the comments which start before
+// line:col. A negative line flushes everything.
+func (p *printer) flush(line, col int) {
+	for len(p.comments) > 0 && before(&p.comments[0].SourceLocation, line, col) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.line(&c.SourceLocation)
+		p.write(c.Text)
+	}
+}
+
+// trailing prints the comments which start before the end of line at the
+// end of the current line.
+func (p *printer) trailing(line int) {
+	for len(p.comments) > 0 && p.comments[0].StartLine <= line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.write(" ")
+		p.write(c.Text)
+	}
+}
+
+// stmts prints the statements in a block. end is the location of the node
+// owning the block, if known, so comments before its closing brace stay
+// inside the block.
+func (p *printer) stmts(node *frontend.Node, end *frontend.SourceLocation) {
+	for _, stmt := range node.Children {
+		loc := stmt.Location()
+		if loc != nil {
+			p.flush(loc.StartLine, loc.StartColumn)
+		}
+		p.line(loc)
+		p.stmt(stmt)
+		if loc != nil {
+			p.trailing(loc.EndLine)
+		}
+	}
+	if end != nil {
+		p.flush(end.EndLine, end.EndColumn)
+	}
+}
+
+func (p *printer) block(node *frontend.Node, owner *frontend.Node) {
+	p.write("{")
+	p.indent++
+	p.opened = true
+	p.stmts(node, owner.Location())
+	p.indent--
+	p.line(nil)
+	p.write("}")
+}
+
+func (p *printer) stmt(node *frontend.Node) {
+	switch node.Label {
+	case "Assign":
+		p.assignee(node.Get(0))
+		p.write(" = ")
+		p.expr(node.Get(1))
+	case "TypeAlias":
+		p.write("type " + node.Get(0).Value.(string) + " = ")
+		p.typ(node.Get(1))
+	case "TypeDecl":
+		p.write("type " + node.Get(0).Value.(string) + " ")
+		p.typ(node.Get(1))
+	case "ModuleDecl":
+		p.write("module " + node.Get(0).Value.(string))
+	case "Import":
+		p.write("import " + String(node.Get(0).Value.(string)))
+	default:
+		if isBoolean(node) {
+			// a statement is a boolean term so connectives need parens
+			p.boolOperand(node, comparison)
+		} else {
+			p.expr(node)
+		}
+	}
+}
+
+func (p *printer) assignee(node *frontend.Node) {
+	switch node.Label {
+	case "NAME":
+		p.write(node.Value.(string))
+	case "Deref":
+		p.write("^")
+		p.assignee(node.Get(0))
+	case "Index":
+		p.assignee(node.Get(0))
+		p.write("[")
+		p.expr(node.Get(1))
+		p.write("]")
+	case "Field":
+		p.assignee(node.Get(0))
+		p.write(".")
+		p.write(node.Get(1).Value.(string))
+	case "Targets":
+		p.write("(")
+		p.list(node.Children, p.assignee)
+		p.write(")")
+	default:
+		panic(fmt.Errorf("unexpected assignee %v", node))
+	}
+}
+
+// Precedence levels of expressions. Higher binds tighter.
+const (
+	additive = iota + 1
+	multiplicative
+	unary
+	postfix
+	atom
+)
+
+func precedence(node *frontend.Node) int {
+	switch node.Label {
+	case "+", "-":
+		return additive
+	case "*", "/", "%":
+		return multiplicative
+	case "Negate", "Deref":
+		return unary
+	case "Call", "Index", "Instantiate", "Field":
+		return postfix
+	}
+	return atom
+}
+
+// operand prints node wrapped in parens if it binds looser than min.
+func (p *printer) operand(node *frontend.Node, min int) {
+	if precedence(node) < min {
+		p.write("(")
+		p.expr(node)
+		p.write(")")
+	} else {
+		p.expr(node)
+	}
+}
+
+func (p *printer) expr(node *frontend.Node) {
+	switch node.Label {
+	case "+", "-", "*", "/", "%":
+		// the operators are left associative so an equal right operand
+		// needs parens to keep the shape of the tree
+		prec := precedence(node)
+		p.operand(node.Get(0), prec)
+		p.write(" " + node.Label + " ")
+		p.operand(node.Get(1), prec+1)
+	case "Negate":
+		p.write("-")
+		p.operand(node.Get(0), postfix)
+	case "Deref":
+		p.write("^")
+		p.operand(node.Get(0), postfix)
+	case "Call":
+		p.operand(node.Get(0), postfix)
+		p.write("(")
+		p.list(node.Get(1).Children, p.expr)
+		p.write(")")
+	case "Index":
+		p.operand(node.Get(0), postfix)
+		p.write("[")
+		p.expr(node.Get(1))
+		p.write("]")
+	case "Instantiate":
+		p.operand(node.Get(0), postfix)
+		if args := node.Get(1); len(args.Children) > 0 {
+			// inferred type arguments were not written
+			p.write("[")
+			p.list(args.Children, p.typ)
+			p.write("]")
+		}
+	case "Field":
+		if node.Get(0).Label == "INT" {
+			// 0.a would lex as the float 0. and the name a
+			p.write("(")
+			p.expr(node.Get(0))
+			p.write(")")
+		} else {
+			p.operand(node.Get(0), postfix)
+		}
+		p.write(".")
+		p.write(node.Get(1).Value.(string))
+	case "Tuple":
+		p.write("(")
+		p.list(node.Children, p.expr)
+		p.write(")")
+	case "Record":
+		p.record(node.Children, func(init *frontend.Node) {
+			p.write(init.Get(0).Value.(string))
+			p.write(": ")
+			p.expr(init.Get(1))
+		})
+	case "NAME":
+		p.write(node.Value.(string))
+	case "INT":
+		p.write(strconv.FormatInt(node.Value.(int64), 10))
+	case "FLOAT":
+		p.write(Float(node.Value.(float64)))
+	case "STRING":
+		p.write(String(node.Value.(string)))
+	case "Func":
+		p.function(node)
+	case "If":
+		p.ifExpr(node)
+	case "Match":
+		p.match(node)
+	case "NEW":
+		p.write("new ")
+		p.typ(node.Get(0))
+	default:
+		panic(fmt.Errorf("unexpected node %v", node))
+	}
+}
+
+func (p *printer) list(nodes []*frontend.Node, each func(*frontend.Node)) {
+	for i, n := range nodes {
+		if i > 0 {
+			p.write(", ")
+		}
+		each(n)
+	}
+}
+
+// record prints the fields of a record literal or type between braces.
+func (p *printer) record(fields []*frontend.Node, each func(*frontend.Node)) {
+	if len(fields) == 0 {
+		p.write("record {}")
+		return
+	}
+	p.write("record { ")
+	p.list(fields, each)
+	p.write(" }")
+}
+
+func (p *printer) function(node *frontend.Node) {
+	p.write("fn")
+	if len(node.Children) > 3 {
+		p.write("[")
+		p.list(node.Get(3).Children, p.expr)
+		p.write("]")
+	}
+	p.write("(")
+	p.list(node.Get(0).Children, func(param *frontend.Node) {
+		p.write(param.Get(0).Value.(string))
+		p.annotation(param.Get(1))
+	})
+	p.write(")")
+	p.annotation(node.Get(1))
+	p.write(" ")
+	p.block(node.Get(2), node)
+}
+
+// annotation prints a type following a space, or nothing if it was left out
+// to be inferred.
+func (p *printer) annotation(node *frontend.Node) {
+	if node.Label == "Infer" {
+		return
+	}
+	p.write(" ")
+	p.typ(node)
+}
+
+func (p *printer) ifExpr(node *frontend.Node) {
+	p.write("if ")
+	p.boolean(node.Get(0))
+	p.write(" ")
+	p.block(node.Get(1), node.Get(1))
+	p.write(" else ")
+	otherwise := node.Get(2)
+	if len(otherwise.Children) == 1 && otherwise.Get(0).Label == "If" {
+		p.ifExpr(otherwise.Get(0))
+	} else {
+		p.block(otherwise, otherwise)
+	}
+}
+
+// match prints each arm on its own line, all followed by a comma.
+func (p *printer) match(node *frontend.Node) {
+	p.write("match ")
+	p.expr(node.Get(0))
+	p.write(" {")
+	p.indent++
+	p.opened = true
+	for _, arm := range node.Children[1:] {
+		loc := arm.Location()
+		if loc != nil {
+			p.flush(loc.StartLine, loc.StartColumn)
+		}
+		p.line(loc)
+		pattern := arm.Get(0)
+		p.write(pattern.Get(0).Value.(string))
+		if binders := pattern.Get(1); len(binders.Children) > 0 {
+			p.write("(")
+			p.list(binders.Children, p.expr)
+			p.write(")")
+		}
+		p.write(" => ")
+		p.expr(arm.Get(1))
+		p.write(",")
+		if loc != nil {
+			p.trailing(loc.EndLine)
+		}
+	}
+	if loc := node.Location(); loc != nil {
+		p.flush(loc.EndLine, loc.EndColumn)
+	}
+	p.indent--
+	p.line(nil)
+	p.write("}")
+}
+
+func isBoolean(node *frontend.Node) bool {
+	switch node.Label {
+	case "||", "&&", "!", "<", "<=", "==", "!=", ">=", ">", "TRUE", "FALSE":
+		return true
+	}
+	return false
+}
+
+// Precedence levels of boolean expressions.
+const (
+	or = iota + 1
+	and
+	not
+	comparison
+)
+
+func boolPrecedence(node *frontend.Node) int {
+	switch node.Label {
+	case "||":
+		return or
+	case "&&":
+		return and
+	case "!":
+		return not
+	}
+	return comparison
+}
+
+func (p *printer) boolOperand(node *frontend.Node, min int) {
+	if boolPrecedence(node) < min {
+		p.write("(")
+		p.boolean(node)
+		p.write(")")
+	} else {
+		p.boolean(node)
+	}
+}
+
+func (p *printer) boolean(node *frontend.Node) {
+	switch node.Label {
+	case "||", "&&":
+		prec := boolPrecedence(node)
+		p.boolOperand(node.Get(0), prec)
+		p.write(" " + node.Label + " ")
+		p.boolOperand(node.Get(1), prec+1)
+	case "!":
+		p.write("!")
+		p.boolOperand(node.Get(0), comparison)
+	case "<", "<=", "==", "!=", ">=", ">":
+		p.expr(node.Get(0))
+		p.write(" " + node.Label + " ")
+		p.expr(node.Get(1))
+	case "TRUE":
+		p.write("true")
+	case "FALSE":
+		p.write("false")
+	default:
+		panic(fmt.Errorf("unexpected boolean node %v", node))
+	}
+}
+
+func (p *printer) typ(node *frontend.Node) {
+	switch node.Label {
+	case "TypeName":
+		p.write(node.Get(0).Value.(string))
+	case "QualifiedType":
+		p.write(node.Get(0).Value.(string) + "." + node.Get(1).Value.(string))
+	case "FuncType":
+		p.write("fn(")
+		p.list(node.Get(0).Children, p.typ)
+		p.write(") ")
+		p.typ(node.Get(1))
+	case "ArrayType":
+		p.write("[")
+		if len(node.Children) > 1 {
+			p.expr(node.Get(1))
+		}
+		p.write("]")
+		p.typ(node.Get(0))
+	case "BoxType":
+		p.write("box(")
+		p.typ(node.Get(0))
+		p.write(")")
+	case "TupleType":
+		p.write("(")
+		p.list(node.Children, p.typ)
+		p.write(")")
+	case "RecordType":
+		p.record(node.Children, func(decl *frontend.Node) {
+			p.write(decl.Get(0).Value.(string))
+			p.write(" ")
+			p.typ(decl.Get(1))
+		})
+	case "Union":
+		for i, variant := range node.Children {
+			if i > 0 {
+				p.write(" | ")
+			}
+			p.write(variant.Get(0).Value.(string))
+			if fields := variant.Get(1); len(fields.Children) > 0 {
+				p.write("(")
+				p.list(fields.Children, p.typ)
+				p.write(")")
+			}
+		}
+	default:
+		panic(fmt.Errorf("unexpected type node %v", node))
+	}
+}
+
+// Float prints f so that it lexes back as a FLOAT rather than an INT.
+func Float(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// String quotes the value of a STRING token. The lexer keeps the escapes
+// \n, \t and \" as written and unescapes anything else, so only a lone
+// backslash needs escaping again.
+func String(s string) string {
+	var buf bytes.Buffer
+	buf.WriteString("\"")
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			if i+1 < len(s) && strings.IndexByte("nt\"", s[i+1]) >= 0 {
+				buf.WriteByte(s[i])
+				buf.WriteByte(s[i+1])
+				i++
+				continue
+			}
+			buf.WriteString("\\\\")
+			continue
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteString("\"")
+	return buf.String()
+}
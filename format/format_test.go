@@ -0,0 +1,103 @@
+package format
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+func parse(t *testing.T, src []byte, name string) *frontend.Node {
+	tokens, err := frontend.Lex(string(src), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestExamples(t *testing.T) {
+	paths, err := filepath.Glob("../ex/*.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(src, path)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		twice, err := Source(once, path)
+		if err != nil {
+			t.Fatalf("%v: reformatting failed %v\n%s", path, err, once)
+		}
+		if string(once) != string(twice) {
+			t.Errorf("%v: formatting was not idempotent\n%s\n%s", path, once, twice)
+		}
+		a := parse(t, src, path).Serialize(false)
+		b := parse(t, once, path).Serialize(false)
+		if a != b {
+			t.Errorf("%v: formatting changed the program\n%s", path, once)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `// leading
+
+x = 1 // trailing
+f = fn(a int) int {
+  /* inside */
+  a+x
+	// before the brace
+}
+
+
+f(2)
+// the end
+`
+	expected := `// leading
+
+x = 1 // trailing
+f = fn(a int) int {
+	/* inside */
+	a + x
+	// before the brace
+}
+
+f(2)
+// the end
+`
+	out, err := Source([]byte(src), "comments.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestParens(t *testing.T) {
+	src := "x = a - (b - c)\ny = (a + b) * -(c)\n(!(x < 1 || y > 2) && true)\n"
+	out, err := Source([]byte(src), "parens.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "x = a - (b - c)\ny = (a + b) * -c\n(!(x < 1 || y > 2) && true)\n"
+	if string(out) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+	if !strings.Contains(Float(2), ".") {
+		t.Errorf("floats must keep a decimal point, %v", Float(2))
+	}
+}
@@ -0,0 +1,196 @@
+package frontend
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+import (
+	"github.com/timtadh/tcel/types"
+)
+
+// The wire forms of a Node. Both keep the Label, the Value with its dynamic
+// type, the Type and the node's own SourceLocation. The tokens a node was
+// parsed from, and so its trivia, are not kept.
+
+type wireNode struct {
+	Label    string          `json:"label"`
+	Value    *wireValue      `json:"value,omitempty"`
+	Type     *types.Encoding `json:"type,omitempty"`
+	Location *SourceLocation `json:"location,omitempty"`
+}
+
+type jsonNode struct {
+	wireNode
+	Children []*Node `json:"children,omitempty"`
+}
+
+type binaryNode struct {
+	Node *wireNode
+	Kids int
+}
+
+type wireValue struct {
+	Kind   string  `json:"kind"`
+	Int    int64   `json:"int,omitempty"`
+	Float  float64 `json:"float,omitempty"`
+	String string  `json:"string,omitempty"`
+	Bool   bool    `json:"bool,omitempty"`
+}
+
+func encodeValue(v interface{}) (*wireValue, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		return &wireValue{Kind: "int", Int: v}, nil
+	case float64:
+		return &wireValue{Kind: "float", Float: v}, nil
+	case string:
+		return &wireValue{Kind: "string", String: v}, nil
+	case bool:
+		return &wireValue{Kind: "bool", Bool: v}, nil
+	}
+	return nil, fmt.Errorf("cannot encode value %v (%T)", v, v)
+}
+
+func (self *wireValue) decode() (interface{}, error) {
+	if self == nil {
+		return nil, nil
+	}
+	switch self.Kind {
+	case "int":
+		return self.Int, nil
+	case "float":
+		return self.Float, nil
+	case "string":
+		return self.String, nil
+	case "bool":
+		return self.Bool, nil
+	}
+	return nil, fmt.Errorf("unknown kind of value %q", self.Kind)
+}
+
+func (self *Node) wire() (*wireNode, error) {
+	value, err := encodeValue(self.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &wireNode{
+		Label:    self.Label,
+		Value:    value,
+		Type:     types.Encode(self.Type),
+		Location: self.location,
+	}, nil
+}
+
+func (self *wireNode) node(d *types.Decoder) (*Node, error) {
+	value, err := self.Value.decode()
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.Decode(self.Type)
+	if err != nil {
+		return nil, err
+	}
+	n := NewValueNode(self.Label, value)
+	n.Type = t
+	n.location = self.Location
+	return n, nil
+}
+
+// MarshalJSON encodes the tree rooted at the node as nested JSON objects.
+func (self *Node) MarshalJSON() ([]byte, error) {
+	w, err := self.wire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonNode{wireNode: *w, Children: self.Children})
+}
+
+// UnmarshalJSON decodes a tree written by MarshalJSON into the node.
+func (self *Node) UnmarshalJSON(data []byte) error {
+	var w jsonNode
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	n, err := w.node(types.NewDecoder())
+	if err != nil {
+		return err
+	}
+	*self = *n
+	for _, kid := range w.Children {
+		if kid == nil {
+			return fmt.Errorf("%v has a null child", self.Label)
+		}
+		self.AddKid(kid)
+	}
+	return nil
+}
+
+// WriteBinary writes the tree rooted at the node to w in a compact binary
+// form, a gob stream of the nodes in pre-order.
+func (self *Node) WriteBinary(w io.Writer) error {
+	var nodes []*binaryNode
+	var walk func(*Node) error
+	walk = func(n *Node) error {
+		wn, err := n.wire()
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, &binaryNode{Node: wn, Kids: len(n.Children)})
+		for _, kid := range n.Children {
+			if err := walk(kid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(self); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(nodes)
+}
+
+// ReadBinary reads a tree written by WriteBinary. The types of the tree
+// share their Named types and type variables.
+func ReadBinary(r io.Reader) (*Node, error) {
+	var nodes []*binaryNode
+	if err := gob.NewDecoder(r).Decode(&nodes); err != nil {
+		return nil, err
+	}
+	d := types.NewDecoder()
+	var build func() (*Node, error)
+	build = func() (*Node, error) {
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("the tree ended early")
+		}
+		bn := nodes[0]
+		nodes = nodes[1:]
+		if bn.Node == nil {
+			return nil, fmt.Errorf("missing node")
+		}
+		n, err := bn.Node.node(d)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < bn.Kids; i++ {
+			kid, err := build()
+			if err != nil {
+				return nil, err
+			}
+			n.AddKid(kid)
+		}
+		return n, nil
+	}
+	root, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) != 0 {
+		return nil, fmt.Errorf("%d nodes after the end of the tree", len(nodes))
+	}
+	return root, nil
+}
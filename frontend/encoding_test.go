@@ -0,0 +1,80 @@
+package frontend_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/loader"
+)
+
+// typed loads the example at path, with the modules it imports, and type
+// checks it.
+func typed(t *testing.T, path string) *frontend.Node {
+	node, err := loader.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Check(node); err != nil {
+		t.Fatalf("%v: %v", path, err)
+	}
+	return node
+}
+
+func TestEncoding(t *testing.T) {
+	paths, err := filepath.Glob("../ex/*.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		node := typed(t, path)
+		expected := node.Serialize(true)
+
+		data, err := json.Marshal(node)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		var decoded frontend.Node
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if got := decoded.Serialize(true); got != expected {
+			t.Errorf("%v: the JSON round trip changed the tree\n%s", path, got)
+		}
+
+		var buf bytes.Buffer
+		if err := node.WriteBinary(&buf); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if buf.Len() >= len(data) {
+			t.Errorf("%v: the binary form (%d) is larger than the JSON (%d)", path, buf.Len(), len(data))
+		}
+		binary, err := frontend.ReadBinary(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if got := binary.Serialize(true); got != expected {
+			t.Errorf("%v: the binary round trip changed the tree\n%s", path, got)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	bad := []string{
+		`{"label":"INT","value":{"kind":"complex"}}`,
+		`{"label":"NAME","type":{"kind":"array"}}`,
+		`{"label":"NAME","type":{"kind":"primative","name":"nat"}}`,
+		`{"label":"Stmts","children":[null]}`,
+	}
+	for _, b := range bad {
+		var n frontend.Node
+		if err := json.Unmarshal([]byte(b), &n); err == nil {
+			t.Errorf("expected %v to fail to decode", b)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package frontend_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/format"
+	"github.com/timtadh/tcel/frontend"
+)
+
+// seed adds the examples and some text the lexer treats specially to the
+// corpus. long.x is left out, it is the others many times over and slows
+// each run down.
+func seed(f *testing.F) {
+	paths, err := filepath.Glob("../ex/*.x")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == "long.x" {
+			continue
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, src := range []string{
+		"",
+		"x = \"a\\tb\\\"c\"",
+		"x = \"unterminated",
+		"x = \"\\q\"",
+		"\"\\",
+		"/* unterminated",
+		"/* a /* b */ c */ x",
+		"// no newline",
+		"x = 1.5e-3 + .5 + 5.",
+		"x = 99999999999999999999",
+		"\x00\xff",
+	} {
+		f.Add(src)
+	}
+}
+
+// FuzzLexer checks the lexer gives either an error or tokens, and that the
+// tokens with their trivia give back the text.
+func FuzzLexer(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, err := frontend.Lex(src, "fuzz.x")
+		if err != nil {
+			if tokens != nil {
+				t.Fatalf("expected no tokens with the error %v", err)
+			}
+			return
+		}
+		for _, tk := range tokens {
+			end := tk.TC + len(tk.Lexeme)
+			if tk.TC < 0 || end > len(src) || src[tk.TC:end] != string(tk.Lexeme) {
+				t.Fatalf("expected %v to be where it was lexed from in %q", tk, src)
+			}
+		}
+		trivia, eof, err := frontend.LexTrivia(src, "fuzz.x")
+		if err != nil {
+			t.Fatalf("expected the text to lex with its trivia: %v", err)
+		}
+		if len(trivia) != len(tokens) {
+			t.Fatalf("expected %d tokens with their trivia got %d", len(tokens), len(trivia))
+		}
+		if got := frontend.Reconstruct(trivia, eof); got != src {
+			t.Fatalf("expected the tokens to give back %q got %q", src, got)
+		}
+	})
+}
+
+// FuzzParser checks the parser does not panic and that a program which
+// parses parses the same once formatted.
+func FuzzParser(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, err := frontend.Lex(src, "fuzz.x")
+		if err != nil {
+			return
+		}
+		node, err := frontend.Parse(tokens)
+		if err != nil {
+			return
+		}
+		formatted, err := format.Source([]byte(src), "fuzz.x")
+		if err != nil {
+			t.Fatalf("expected the program to format: %v", err)
+		}
+		tokens, err = frontend.Lex(string(formatted), "fuzz.x")
+		if err != nil {
+			t.Fatalf("expected the formatted program to lex: %v\n%s", err, formatted)
+		}
+		again, err := frontend.Parse(tokens)
+		if err != nil {
+			t.Fatalf("expected the formatted program to parse: %v\n%s", err, formatted)
+		}
+		if a, b := node.Serialize(false), again.Serialize(false); a != b {
+			t.Fatalf("expected formatting to keep the program\n%v\ngot\n%v\n%s", a, b, formatted)
+		}
+	})
+}
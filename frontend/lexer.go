@@ -14,6 +14,20 @@ import (
 type Token struct {
 	lex.Token
 	Filename string
+	Leading  []*Trivia
+	Trailing []*Trivia
+}
+
+// Trivia is source text which carries no meaning for the parser: comments
+// and whitespace. It is only kept when the LexerContext asks for it.
+type Trivia struct {
+	Kind string
+	Text string
+	SourceLocation
+}
+
+func (self *Trivia) String() string {
+	return fmt.Sprintf("%v %q at %v", self.Kind, self.Text, &self.SourceLocation)
 }
 
 func NewToken(token int, value interface{}, match *machines.Match, filename string) *Token {
@@ -77,8 +91,12 @@ func init() {
 		"/",
 		"%",
 		",",
+		".",
+		":",
 		"&&",
 		"||",
+		"|",
+		"=>",
 		"!",
 		"<",
 		"<=",
@@ -90,6 +108,11 @@ func init() {
 	Tokens = []string{
 		"NAME",
 		"BOX",
+		"RECORD",
+		"TYPE",
+		"MATCH",
+		"MODULE",
+		"IMPORT",
 		"FN",
 		"IF",
 		"ELSE",
@@ -109,21 +132,61 @@ func init() {
 
 type LexerContext struct {
 	Filename string
+	// KeepTrivia makes the lexer attach comments and whitespace to the tokens
+	// around them instead of skipping them. The trivia on a token's line
+	// after it, up to and including the newline, is its Trailing trivia. The
+	// rest is Leading trivia of the token which follows.
+	KeepTrivia bool
+	pending []*Trivia
+	last *Token
 }
 
 func NewContext(filename string) *LexerContext {
 	return &LexerContext{Filename: filename}
 }
 
+// emit hands any trivia seen since the last token to it and tok.
+func (self *LexerContext) emit(tok *Token) *Token {
+	self.trail()
+	tok.Leading = self.pending
+	self.pending = nil
+	self.last = tok
+	return tok
+}
+
+// trail moves the pending trivia up to the end of the last token's line onto
+// that token.
+func (self *LexerContext) trail() {
+	if self.last == nil || len(self.pending) == 0 {
+		return
+	}
+	i := 0
+	for i < len(self.pending) {
+		i++
+		if strings.HasSuffix(self.pending[i-1].Text, "\n") {
+			break
+		}
+	}
+	self.last.Trailing = append(self.last.Trailing, self.pending[:i]...)
+	self.pending = self.pending[i:]
+}
+
+// Pending is the trivia seen after the last token and its line, e.g. a
+// comment at the end of the file.
+func (self *LexerContext) Pending() []*Trivia {
+	self.trail()
+	return self.pending
+}
+
 func (self *LexerContext) Token(name string) lex.Action {
 	return func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
-		return NewToken(TokMap[name], nil, match, self.Filename), nil
+		return self.emit(NewToken(TokMap[name], nil, match, self.Filename)), nil
 	}
 }
 
 func (self *LexerContext) TokenValue(name string) lex.Action {
 	return func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
-		return NewToken(TokMap[name], string(match.Bytes), match, self.Filename), nil
+		return self.emit(NewToken(TokMap[name], string(match.Bytes), match, self.Filename)), nil
 	}
 }
 
@@ -132,11 +195,65 @@ func (self *LexerContext) Skip(scan *lex.Scanner, match *machines.Match) (interf
 }
 
 func (self *LexerContext) Literal(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
-	return NewToken(TokMap[string(match.Bytes)], string(match.Bytes), match, self.Filename), nil
+	return self.emit(NewToken(TokMap[string(match.Bytes)], string(match.Bytes), match, self.Filename)), nil
+}
+
+// Whitespace records the matched spaces, tabs and newline as trivia if the
+// context keeps trivia.
+func (self *LexerContext) Whitespace(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
+	self.trivia("WHITESPACE", string(match.Bytes), match.StartLine, match.StartColumn)
+	return nil, nil
+}
+
+// Comment records the comment text[start:end] as trivia if the context keeps
+// trivia. The newline ending a line comment is kept as whitespace.
+func (self *LexerContext) Comment(text []byte, start, end int, match *machines.Match) {
+	comment := string(text[start:end])
+	newline := strings.HasSuffix(comment, "\n")
+	if newline {
+		comment = comment[:len(comment)-1]
+	}
+	t := self.trivia("COMMENT", comment, match.StartLine, match.StartColumn)
+	if newline && t != nil {
+		self.trivia("WHITESPACE", "\n", t.EndLine, t.EndColumn+1)
+	}
+}
+
+// trivia records text starting at line:col as pending trivia.
+func (self *LexerContext) trivia(kind, text string, line, col int) *Trivia {
+	if !self.KeepTrivia {
+		return nil
+	}
+	t := &Trivia{
+		Kind: kind,
+		Text: text,
+		SourceLocation: SourceLocation{
+			Filename:    self.Filename,
+			StartLine:   line,
+			StartColumn: col,
+			EndLine:     line,
+			EndColumn:   col,
+		},
+	}
+	// the end is the position of the last character
+	for i := 0; i+1 < len(text); i++ {
+		if text[i] == '\n' {
+			t.EndLine++
+			t.EndColumn = 1
+		} else {
+			t.EndColumn++
+		}
+	}
+	self.pending = append(self.pending, t)
+	return t
 }
 
 func Lexer(text, filename string) (*lex.Scanner, error) {
-	ctx := NewContext(filename)
+	return NewContext(filename).Scanner(text)
+}
+
+func (self *LexerContext) Scanner(text string) (*lex.Scanner, error) {
+	ctx := self
 	lexer := lex.NewLexer()
 
 	for _, lit := range Literals {
@@ -151,6 +268,11 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 	lexer.Add([]byte("false"), ctx.Token("FALSE"))
 	lexer.Add([]byte("new"), ctx.Token("NEW"))
 	lexer.Add([]byte("box"), ctx.Token("BOX"))
+	lexer.Add([]byte("record"), ctx.Token("RECORD"))
+	lexer.Add([]byte("type"), ctx.Token("TYPE"))
+	lexer.Add([]byte("match"), ctx.Token("MATCH"))
+	lexer.Add([]byte("module"), ctx.Token("MODULE"))
+	lexer.Add([]byte("import"), ctx.Token("IMPORT"))
 
 	lexer.Add([]byte("([a-z]|[A-Z])([a-z]|[A-Z]|[0-9]|_)*"), ctx.TokenValue("NAME"))
 	lexer.Add(
@@ -160,7 +282,7 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 			if err != nil {
 				return nil, err
 			}
-			return NewToken(TokMap["INT"], int64(i), match, ctx.Filename), nil
+			return ctx.emit(NewToken(TokMap["INT"], int64(i), match, ctx.Filename)), nil
 		},
 	)
 	lexer.Add(
@@ -170,7 +292,7 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 			if err != nil {
 				return nil, err
 			}
-			return NewToken(TokMap["FLOAT"], float64(f), match, ctx.Filename), nil
+			return ctx.emit(NewToken(TokMap["FLOAT"], float64(f), match, ctx.Filename)), nil
 		},
 	)
 	lexer.Add(
@@ -185,14 +307,16 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 					// the next character is a literal
 					tc++
 					match.EndColumn += 1
-					if tc < len(scan.Text) {
-						switch scan.Text[tc] {
-						case 'n', 't', '"': str = append(str, '\\')
-						}
+					if tc >= len(scan.Text) {
+						break
+					}
+					switch scan.Text[tc] {
+					case 'n', 't', '"': str = append(str, '\\')
 					}
 				} else if scan.Text[tc] == '"' {
 					scan.TC = tc + 1
-					return NewToken(TokMap["STRING"], string(str), match, ctx.Filename), nil
+					match.Bytes = scan.Text[match.TC:scan.TC]
+					return ctx.emit(NewToken(TokMap["STRING"], string(str), match, ctx.Filename)), nil
 				}
 				if scan.Text[tc] == '\n' {
 					match.EndLine += 1
@@ -205,8 +329,14 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 		},
 	)
 
-	lexer.Add([]byte("( |\t|\n)"), ctx.Skip)
-	lexer.Add([]byte("//[^\n]*\n"), ctx.Skip)
+	lexer.Add([]byte("( |\t)*\n"), ctx.Whitespace)
+	lexer.Add([]byte("( |\t)+"), ctx.Whitespace)
+	lexer.Add([]byte("//[^\n]*\n"),
+		func(scan *lex.Scanner, match *machines.Match)(interface{}, error) {
+			ctx.Comment(scan.Text, match.TC, scan.TC, match)
+			return nil, nil
+		},
+	)
 	lexer.Add([]byte("/\\*"),
 		func(scan *lex.Scanner, match *machines.Match)(interface{}, error) {
 			for tc := scan.TC; tc < len(scan.Text); tc++ {
@@ -216,6 +346,7 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 				} else if scan.Text[tc] == '*' && tc+1 < len(scan.Text) {
 					if scan.Text[tc+1] == '/' {
 						scan.TC = tc+2
+						ctx.Comment(scan.Text, match.TC, scan.TC, match)
 						return nil, nil
 					}
 				}
@@ -228,3 +359,59 @@ func Lexer(text, filename string) (*lex.Scanner, error) {
 
 	return lexer.Scanner([]byte(text))
 }
+
+// Lex runs the Lexer over the whole text and collects the tokens.
+func Lex(text, filename string) ([]*Token, error) {
+	scanner, err := Lexer(text, filename)
+	if err != nil {
+		return nil, err
+	}
+	return collect(scanner)
+}
+
+// LexTrivia is Lex but keeps the comments and whitespace as trivia on the
+// tokens. The trivia after the last token's line is returned separately.
+// Concatenating the tokens' Leading trivia, lexemes and Trailing trivia
+// followed by that reproduces the text exactly, see Reconstruct.
+func LexTrivia(text, filename string) ([]*Token, []*Trivia, error) {
+	ctx := NewContext(filename)
+	ctx.KeepTrivia = true
+	scanner, err := ctx.Scanner(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := collect(scanner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, ctx.Pending(), nil
+}
+
+// Reconstruct writes back the source text of tokens lexed by LexTrivia. eof
+// is the trivia after the last token.
+func Reconstruct(tokens []*Token, eof []*Trivia) string {
+	var buf strings.Builder
+	trivia := func(ts []*Trivia) {
+		for _, t := range ts {
+			buf.WriteString(t.Text)
+		}
+	}
+	for _, tok := range tokens {
+		trivia(tok.Leading)
+		buf.Write(tok.Lexeme)
+		trivia(tok.Trailing)
+	}
+	trivia(eof)
+	return buf.String()
+}
+
+func collect(scanner *lex.Scanner) ([]*Token, error) {
+	var tokens []*Token
+	for tok, err, eof := scanner.Next(); !eof; tok, err, eof = scanner.Next() {
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok.(*Token))
+	}
+	return tokens, nil
+}
@@ -10,8 +10,11 @@ import (
 )
 
 type SourceLocation struct {
-	Filename string
-	StartLine, StartColumn, EndLine, EndColumn int
+	Filename    string `json:"filename"`
+	StartLine   int    `json:"startLine"`
+	StartColumn int    `json:"startColumn"`
+	EndLine     int    `json:"endLine"`
+	EndColumn   int    `json:"endColumn"`
 }
 
 func (self *SourceLocation) String() string {
@@ -64,6 +67,8 @@ type Node struct {
 	Type     types.Type
 	Children []*Node
 	location *SourceLocation
+	first    *Token
+	last     *Token
 }
 
 func NewNode(label string) *Node {
@@ -93,6 +98,8 @@ func NewTokenNode(tok *Token) *Node {
 			EndLine: tok.EndLine,
 			EndColumn: tok.EndColumn,
 		},
+		first: tok,
+		last: tok,
 	}
 }
 
@@ -144,50 +151,106 @@ func (self *Node) String() string {
 	return fmt.Sprintf("(Node %v %d)", self.Label, len(self.Children))
 }
 
+// Location is the span of source the node was parsed from. It is nil when
+// the node has no source or spans several files, see Locations.
 func (self *Node) Location() *SourceLocation {
+	locs := self.Locations()
+	if len(locs) != 1 {
+		return nil
+	}
+	return locs[0]
+}
+
+// Locations is the span of the node in each file it was parsed from, in the
+// order the files are first seen.
+func (self *Node) Locations() []*SourceLocation {
 	if self == nil {
 		return nil
 	}
 	var locs []*SourceLocation
+	add := func(l *SourceLocation) {
+		for i, o := range locs {
+			if o.Filename == l.Filename {
+				locs[i], _ = o.Join(l)
+				return
+			}
+		}
+		locs = append(locs, l)
+	}
 	if self.location != nil {
-		locs = append(locs, self.location)
+		add(self.location)
 	}
 	for _, kid := range self.Children {
-		kl := kid.Location()
-		if kl != nil {
-			locs = append(locs, kl)
+		for _, l := range kid.Locations() {
+			add(l)
 		}
 	}
-	if len(locs) == 0 {
-		return nil
-	} else if len(locs) == 1 {
-		return locs[0]
-	}
-	base := locs[0]
-	others := locs[1:]
-	l, e := base.Join(others...)
-	if e != nil {
-		panic(e)
-	}
-	return l
+	return locs
 }
 
 func (self *Node) Annotate(nodes []*Node) *Node {
-	var locs []*SourceLocation
+	locs := self.Locations()
 	for _, n := range nodes {
-		l := n.Location()
-		if l != nil {
-			locs = append(locs, l)
+		locs = append(locs, n.Locations()...)
+	}
+	if len(locs) > 0 {
+		var e error
+		self.location, e = locs[0].Join(locs[1:]...)
+		if e != nil {
+			panic(e)
 		}
 	}
-	var e error
-	self.location, e = self.Location().Join(locs...)
-	if e != nil {
-		panic(e)
+	for _, n := range nodes {
+		first, last := n.Tokens()
+		self.first, self.last = spanTokens(self.first, self.last, first, last)
 	}
 	return self
 }
 
+// Tokens is the first and last token the node was parsed from. They are nil
+// for nodes which were not built by the parser.
+func (self *Node) Tokens() (first, last *Token) {
+	if self == nil {
+		return nil, nil
+	}
+	first, last = self.first, self.last
+	for _, kid := range self.Children {
+		f, l := kid.Tokens()
+		first, last = spanTokens(first, last, f, l)
+	}
+	return first, last
+}
+
+func spanTokens(first, last, f, l *Token) (*Token, *Token) {
+	if f != nil && (first == nil || f.TC < first.TC) {
+		first = f
+	}
+	if l != nil && (last == nil || l.TC > last.TC) {
+		last = l
+	}
+	return first, last
+}
+
+// Leading is the trivia before the node's first token. It is only present
+// when the tokens were lexed with LexTrivia.
+func (self *Node) Leading() []*Trivia {
+	first, _ := self.Tokens()
+	if first == nil {
+		return nil
+	}
+	return first.Leading
+}
+
+// Trailing is the trivia after the node's last token up to the end of its
+// line. It is only present when the tokens were lexed with LexTrivia.
+func (self *Node) Trailing() []*Trivia {
+	_, last := self.Tokens()
+	if last == nil {
+		return nil
+	}
+	return last.Trailing
+}
+
 func (self *Node) Serialize(with_loc bool) string {
 	fmt_node := func(n *Node) string {
 		s := ""
@@ -220,7 +283,7 @@ func (self *Node) Serialize(with_loc bool) string {
 				n.Label,
 			)
 		}
-		if with_loc && n.Location != nil {
+		if with_loc && n.Location() != nil {
 			s = fmt.Sprintf("%s:at %v", s, n.Location())
 		}
 		return s
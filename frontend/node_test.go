@@ -0,0 +1,83 @@
+package frontend
+
+import (
+	"testing"
+)
+
+func parseString(t *testing.T, src, filename string) *Node {
+	tokens, err := Lex(src, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func checkSpan(t *testing.T, what string, node *Node, sl, sc, el, ec int) {
+	loc := node.Location()
+	if loc == nil {
+		t.Errorf("%v: %v has no location", what, node)
+		return
+	}
+	if loc.StartLine != sl || loc.StartColumn != sc || loc.EndLine != el || loc.EndColumn != ec {
+		t.Errorf("%v: expected (%d-%d)-(%d-%d) got %v", what, sl, sc, el, ec, loc)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	src := "x = if !(a < 1) {\n\t-(b)\n} else {\n\t^c\n}\nf = fn(y int) box(int) {\n\tnew int\n}\n"
+	node := parseString(t, src, "spans.x")
+	ifExpr := node.Get(0).Get(1)
+	checkSpan(t, "if", ifExpr, 1, 5, 5, 1)
+	checkSpan(t, "not", ifExpr.Get(0), 1, 8, 1, 15)
+	checkSpan(t, "cmp", ifExpr.Get(0).Get(0), 1, 9, 1, 15)
+	checkSpan(t, "then", ifExpr.Get(1), 1, 17, 3, 1)
+	checkSpan(t, "negate", ifExpr.Get(1).Get(0), 2, 2, 2, 5)
+	checkSpan(t, "else", ifExpr.Get(2), 3, 8, 5, 1)
+	checkSpan(t, "deref", ifExpr.Get(2).Get(0), 4, 2, 4, 3)
+	fn := node.Get(1).Get(1)
+	checkSpan(t, "param type", fn.Get(0).Get(0).Get(1), 6, 10, 6, 12)
+	checkSpan(t, "new type", fn.Get(2).Get(0).Get(0), 7, 6, 7, 8)
+}
+
+func TestEmptyListsAreDistinct(t *testing.T) {
+	node := parseString(t, "f()\ng()\n", "calls.x")
+	a := node.Get(0).Get(1)
+	b := node.Get(1).Get(1)
+	if a == b {
+		t.Fatalf("the empty Params nodes are shared")
+	}
+	checkSpan(t, "f()", a, 1, 2, 1, 3)
+	checkSpan(t, "g()", b, 2, 2, 2, 3)
+}
+
+func TestLocationsAcrossFiles(t *testing.T) {
+	a := parseString(t, "x = 1\n", "a.x")
+	b := parseString(t, "\ny = x\n", "b.x")
+	all := NewNode("Stmts").AddKid(a.Get(0)).AddKid(b.Get(0))
+	if loc := all.Location(); loc != nil {
+		t.Errorf("expected no single location got %v", loc)
+	}
+	locs := all.Locations()
+	if len(locs) != 2 || locs[0].Filename != "a.x" || locs[1].Filename != "b.x" {
+		t.Fatalf("expected a span in each file got %v", locs)
+	}
+	checkSpan(t, "b.x", all.Get(1), 2, 1, 2, 5)
+}
+
+func TestTuplesOnTheNextLine(t *testing.T) {
+	node := parseString(t, "x = f(1)\n(a, b) = (2, 3)\n(a + b)\n", "tuples.x")
+	if len(node.Children) != 3 {
+		t.Fatalf("expected 3 statements got %v", node.Serialize(false))
+	}
+	targets := node.Get(1).Get(0)
+	if targets.Label != "Targets" || len(targets.Children) != 2 {
+		t.Errorf("expected two targets got %v", targets)
+	}
+	checkSpan(t, "targets", targets, 2, 1, 2, 6)
+	checkSpan(t, "tuple", node.Get(1).Get(1), 2, 10, 2, 15)
+	checkSpan(t, "paren", node.Get(2), 3, 1, 3, 7)
+}
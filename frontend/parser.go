@@ -2,6 +2,7 @@ package frontend
 
 import (
 	"fmt"
+	"strings"
 )
 
 /*
@@ -9,14 +10,39 @@ import (
 Stmts -> Stmt Stmts
        | Stmt
 
-Stmt -> Assign
+Stmt -> ModuleDecl
+      | Import
+      | TypeDecl
+      | Assign
       | Expr
 
+ModuleDecl -> MODULE NAME
+
+Import -> IMPORT STRING
+
+TypeDecl -> TYPE NAME = Union
+          | TYPE NAME = Type
+          | TYPE NAME Union
+          | TYPE NAME Type
+
+Union -> Variant Variants'
+
+Variants' -> | Variant Variants'
+           | e
+
+Variant -> NAME ( TypeParams )
+         | NAME
+
 Assign -> Indexed = Expr
+        | ( Indexed , Indexed Targets' ) = Expr
+
+Targets' -> , Indexed Targets'
+          | e
 
 Indexed -> NAME Indices
 
 Indices -> Index Indices
+         | Field Indices
          | e
 
 Expr -> Term Expr'
@@ -39,15 +65,23 @@ PostUnary -> Factor Applies
 
 Applies -> Apply Applies'
          | Index Applies
+         | Instantiate Applies'
+         | Field Applies'
 
 Applies' -> Apply Applies'
           | Index Applies'
+          | Instantiate Applies'
+          | Field Applies'
           | e
 
-Apply -> ( Params )
+Apply -> ( Params )          (the "(" on the line of the callee)
 
 Index -> [ Expr ]
 
+Instantiate -> [ Type TypeParams' ]
+
+Field -> . NAME
+
 Params -> Expr Params'
         | e
 
@@ -61,25 +95,71 @@ Factor -> NAME
         | Function
         | If
         | New
-        | ( Expr )
+        | Record
+        | Match
+        | ( Expr Params' )
+
+Match -> MATCH Expr { Arms }
+
+Arms -> Arm Arms'
+
+Arms' -> , Arm Arms'
+       | ,
+       | e
+
+Arm -> Pattern => Expr
+
+Pattern -> NAME ( Binders )
+         | NAME
+
+Binders -> NAME Binders'
+
+Binders' -> , NAME Binders'
+          | e
+
+Record -> RECORD { FieldInits }
+
+FieldInits -> NAME : Expr FieldInits'
+            | e
+
+FieldInits' -> , NAME : Expr FieldInits'
+             | e
 
 If -> IF BooleanExpr { Stmts } ELSE IfElse
 
 IfElse -> { Stmts }
         | If
 
-Function -> FN ( ParamDecls ) Type { Stmts }
+Function -> FN [ TypeVars ] ( ParamDecls ) MaybeType { Stmts }
+          | FN ( ParamDecls ) MaybeType { Stmts }
+
+MaybeType -> Type
+           | e
+
+TypeVars -> NAME TypeVars'
 
-ParamDecls -> NAME Type ParamDecls'
+TypeVars' -> , NAME TypeVars'
+           | e
+
+ParamDecls -> NAME MaybeType ParamDecls'
             | e
 
-ParamDecls' -> , NAME Type ParamDecls'
+ParamDecls' -> , NAME MaybeType ParamDecls'
              | e
 
-Type -> NAME
+Type -> NAME . NAME
+      | NAME
       | FN ( TypeParams ) Type
       | [Expr]Type
       | BOX ( NAME )
+      | RECORD { FieldDecls }
+      | ( Type , Type TypeParams' )
+
+FieldDecls -> NAME Type FieldDecls'
+            | e
+
+FieldDecls' -> , NAME Type FieldDecls'
+             | e
 
 TypeParams -> Type TypeParams'
             | e
@@ -170,495 +250,943 @@ func (self *ParseError) Error() string {
 	return fmt.Sprintf(self.ErrorFmt, self.Token)
 }
 
-type Consumer interface {
-	Consume(i int) (int, *Node, *ParseError)
+// Parse parses the tokens of a file giving its Stmts.
+//
+// The grammar above is read as a parsing expression grammar: the
+// alternatives of a production are tried in order and the first which
+// matches is taken, so a statement which is both an Assign and an Expr is an
+// Assign. The parser picks an alternative by the next few tokens and builds
+// each node once. It only goes back where a token does not decide: a
+// bracket after an expression may index it or instantiate it, a paren in a
+// condition may open a comparison or a boolean expression, a paren after a
+// variant may hold its fields or start the next statement. The binary
+// operators are parsed by precedence climbing.
+func Parse(tokens []*Token) (*Node, error) {
+	p := &parser{tokens: tokens}
+	stmts := p.stmts()
+	if stmts == nil || p.i < len(tokens) {
+		return nil, p.failure()
+	}
+	return stmts, nil
 }
 
-type StringConsumer struct {
-	name string
-	Productions map[string]Consumer
+// the binary operators of expressions and of conditions by precedence
+var (
+	arithmetic  = map[string]int{"+": 1, "-": 1, "*": 2, "/": 2, "%": 2}
+	connectives = map[string]int{"||": 1, "&&": 2}
+	comparisons = map[string]bool{"<": true, "<=": true, "==": true, "!=": true, ">=": true, ">": true}
+)
+
+// parser is the state of Parse. A production which does not match gives nil
+// and leaves the parser at the token it started at.
+type parser struct {
+	tokens []*Token
+	i      int         // the next token
+	err    *ParseError // the error furthest into the tokens
 }
 
-func (self StringConsumer) Consume(i int) (int, *Node, *ParseError) {
-	return self.Productions[self.name].Consume(i)
+// at gives the name of the token k after the next one, "" past the end.
+func (p *parser) at(k int) string {
+	if p.i+k >= len(p.tokens) {
+		return ""
+	}
+	return Tokens[p.tokens[p.i+k].Type]
 }
 
-type FnConsumer func(i int) (int, *Node, *ParseError)
+func (p *parser) peek(token string) bool {
+	return p.at(0) == token
+}
 
-func (self FnConsumer) Consume(i int) (int, *Node, *ParseError) {
-	return self(i)
+func (p *parser) next() *Node {
+	n := NewTokenNode(p.tokens[p.i])
+	p.i++
+	return n
 }
 
+func (p *parser) expect(token string) *Node {
+	if p.peek(token) {
+		return p.next()
+	}
+	p.expected(token)
+	return nil
+}
 
-func Parse(tokens []*Token) (*Node, error) {
+// reset goes back to the token at start after a production did not match.
+func (p *parser) reset(start int) *Node {
+	p.i = start
+	return nil
+}
+
+// expected records that what was expected at the next token.
+func (p *parser) expected(what string) {
+	what = strings.Replace(what, "%", "%%", -1)
+	if p.i >= len(p.tokens) {
+		p.error(Error(fmt.Sprintf("Ran off the end of the input. Expected %v. %%v", what), nil))
+	} else {
+		p.error(Error(fmt.Sprintf("Expected %v got %%v", what), p.tokens[p.i]))
+	}
+}
+
+func (p *parser) error(err *ParseError) {
+	if p.err == nil || p.err.Less(err) {
+		p.err = err
+	}
+}
+
+// failure gives the error of a parse which did not reach the end of the
+// tokens.
+func (p *parser) failure() *ParseError {
+	if p.err == nil {
+		if p.i < len(p.tokens) {
+			return Error("Unexpected %v", p.tokens[p.i])
+		}
+		return Error("Ran off the end of the input. %v", nil)
+	}
+	return p.err
+}
 
-	type Result struct {
-		j int
-		n *Node
-		e *ParseError
-	}
-
-	P := make(map[string]Consumer)
-
-	SC := func(name string) StringConsumer {
-		return StringConsumer{
-			name: name,
-			Productions: P,
-		}
-	}
-
-	var (
-		/*
-		Stmts, Stmt, Assign, Expr, Expr_, Term, Term_, Unary, PostUnary, Factor,
-		Applies, Applies_, Params, Params_, Apply, Index, Function, ParamDecls,
-		ParamDecls_, Type, TypeParams, TypeParams_, If, IfElse, BooleanExpr,
-		BooleanExpr_, AndExpr, AndExpr_, NotExpr, BooleanTerm, CmpExpr,
-		BooleanConstant, CmpOp, Array, ArrayLiteral, ArrayParams, ArrayParams_ Consumer */
-		Epsilon func(*Node) Consumer
-		Consume func(string) Consumer
-		Concat func(...Consumer) func(func(...*Node)(*Node, *ParseError)) Consumer
-		Alt func(...Consumer) Consumer
-	)
-
-	var top_err *ParseError = nil
-
-	collapse := func(subtree, extra *Node) *Node {
-		if extra == nil {
-			return subtree
-		}
-		// extra == T
-		//         / \
-		//       op   root
-		//             .
-		//             .
-		//             .
-		//          op
-
-		// returns
-		//           root
-		//             .
-		//             .
-		//             .
-		//          op
-		//            \
-		//             subtree
-		op := extra.Get(0)
-		op.PrependKid(subtree)
-		return extra.Get(1)
-	}
-
-	swing := func (nodes ...*Node) (*Node, *ParseError) {
-		n := NewNode("T").AddKid(nodes[0]).AddKid(
-			collapse(nodes[0].AddKid(nodes[1]), nodes[2]))
-		return n, nil
-	}
-
-	Epsilon = func(n *Node) Consumer {
-		return FnConsumer(func(i int) (int, *Node, *ParseError) {
-			return i, n, nil
-		})
-	}
-
-	Cached := func(c Consumer) Consumer {
-		cache := make(map[int]*Result)
-		return FnConsumer(func(i int) (int, *Node, *ParseError) {
-			if res, in := cache[i]; in {
-				return res.j, res.n, res.e
+// list parses the ", item" following the first of a list into list. It
+// stops before a comma which is not followed by an item.
+func (p *parser) list(list *Node, item func() *Node) *Node {
+	for p.peek(",") {
+		start := p.i
+		p.i++
+		n := item()
+		if n == nil {
+			p.i = start
+			break
+		}
+		list.AddKid(n)
+	}
+	return list
+}
+
+// binary parses the operands joined by the operators in ops, grouping
+// them to the left. The node of an operator is its token with the operands
+// as kids.
+func (p *parser) binary(ops map[string]int, operand func() *Node) *Node {
+	// once an operator is not followed by an operand it is left for the
+	// caller and no enclosing level may take it either
+	halted := false
+	var climb func(min int) *Node
+	climb = func(min int) *Node {
+		left := operand()
+		for left != nil && !halted {
+			prec, is := ops[p.at(0)]
+			if !is || prec < min {
+				break
 			}
-			j, n, e := c.Consume(i)
-			cache[i] = &Result{j, n, e}
-			return j, n, e
-		})
-	}
-
-	Concat = func(consumers ...Consumer) func(func(...*Node)(*Node, *ParseError)) Consumer {
-		return func(action func(...*Node)(*Node, *ParseError)) Consumer {
-			// Can't cache the Concat because Indices reuses Index.
-			return FnConsumer(func(i int) (int, *Node, *ParseError) {
-				var nodes []*Node
-				var n *Node
-				var err *ParseError
-				j := i
-				for _, c := range consumers {
-					j, n, err = c.Consume(j)
-					if err == nil {
-						nodes = append(nodes, n)
-					} else {
-						return i, nil, err
-					}
-				}
-				an, aerr := action(nodes...)
-				if aerr != nil {
-					return i, nil, aerr
-				}
-				return j, an, nil
-			})
-		}
-	}
-
-	Alt = func(consumers ...Consumer) Consumer {
-		return Cached(FnConsumer(func(i int) (int, *Node, *ParseError) {
-			var err *ParseError = nil
-			for _, c := range consumers {
-				j, n, e := c.Consume(i)
-				if e == nil {
-					return j, n, nil
-				} else if err == nil || err.Less(e) {
-					err = e
-				}
+			start := p.i
+			op := p.next()
+			right := climb(prec + 1)
+			if right == nil {
+				p.i = start
+				halted = true
+				break
 			}
-			if top_err == nil || top_err.Less(err) {
-				top_err = err
+			left = op.AddKid(left).AddKid(right)
+		}
+		return left
+	}
+	return climb(1)
+}
+
+func (p *parser) stmts() *Node {
+	stmts := NewNode("Stmts")
+	for {
+		stmt := p.stmt()
+		if stmt == nil {
+			break
+		}
+		stmts.AddKid(stmt)
+	}
+	if len(stmts.Children) == 0 {
+		return nil
+	}
+	return stmts
+}
+
+// stmt parses an expression and makes it the target of an Assign when an
+// "=" follows it and it is one, rather than parsing the target on its own
+// first.
+func (p *parser) stmt() *Node {
+	switch p.at(0) {
+	case "MODULE":
+		return p.moduleDecl()
+	case "IMPORT":
+		return p.importDecl()
+	case "TYPE":
+		return p.typeDecl()
+	case "^":
+		if p.at(1) == "NAME" && p.at(2) == "=" {
+			start := p.i
+			caret := p.next()
+			name := p.next()
+			deref := NewNode("Deref").AddKid(name).Annotate([]*Node{caret, name})
+			if assign := p.assign(deref); assign != nil {
+				return assign
 			}
-			return i, nil, err
-		}))
-	}
-
-	Consume = func(token string) Consumer {
-		return FnConsumer(func(i int) (int, *Node, *ParseError) {
-			if i == len(tokens) {
-				return i, nil, Error(
-					fmt.Sprintf("Ran off the end of the input. Expected %v. %%v", token), nil)
-				}
-				tk := tokens[i]
-				if tk.Type == TokMap[token] {
-					return i+1, NewTokenNode(tk), nil
-				}
-				return i, nil, Error(fmt.Sprintf("Expected %v got %%v", token), tk)
-			})
-}
-
-	for _, token := range Tokens {
-		P[token] = Consume(token)
-	}
-
-	P["Stmts"] = Alt(
-		Concat(SC("Stmt"), SC("Stmts"))(func (nodes ...*Node) (*Node, *ParseError) {
-			stmts := NewNode("Stmts").AddKid(nodes[0])
-			stmts.Children = append(stmts.Children, nodes[1].Children...)
-			return stmts, nil
-		}),
-		Concat(SC("Stmt"))(func (nodes ...*Node) (*Node, *ParseError) {
-			stmts := NewNode("Stmts").AddKid(nodes[0])
-			return stmts, nil
-		}),
-	)
-
-	P["Stmt"] = Alt(SC("Assign"), SC("Expr"), SC("BooleanTerm"))
-
-	P["Assign"] = Alt(
-		Concat(SC("^"), SC("NAME"), SC("="), SC("Expr"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				stmts := NewNode("Assign").AddKid(NewNode("Deref").AddKid(nodes[1])).AddKid(nodes[3]).Annotate(nodes)
-				return stmts, nil
-			}),
-		Concat(SC("Indexed"), SC("="), SC("Expr"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				stmts := NewNode("Assign").AddKid(nodes[0]).AddKid(nodes[2]).Annotate(nodes)
-				return stmts, nil
-			}),
-	)
-
-	P["Expr"] = Concat(SC("Term"), SC("Expr_"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return collapse(nodes[0], nodes[1]), nil
-	})
-
-	P["Expr_"] = Alt(
-		Concat(SC("+"), SC("Term"), SC("Expr_"))(swing),
-		Concat(SC("-"), SC("Term"), SC("Expr_"))(swing),
-		Epsilon(nil),
-	)
-
-	P["Term"] = Concat(SC("Unary"), SC("Term_"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return collapse(nodes[0], nodes[1]), nil
-	})
-
-	P["Term_"] = Alt(
-		Concat(SC("*"), SC("Unary"), SC("Term_"))(swing),
-		Concat(SC("/"), SC("Unary"), SC("Term_"))(swing),
-		Concat(SC("%"), SC("Unary"), SC("Term_"))(swing),
-		Epsilon(nil),
-	)
-
-	P["Unary"] = Alt(
-		SC("PostUnary"),
-		Concat(SC("-"), SC("PostUnary"))(func (nodes ...*Node) (*Node, *ParseError) {
-			nodes[0].Label = "Negate"
-			return nodes[0].AddKid(nodes[1]), nil
-		}),
-		Concat(SC("^"), SC("PostUnary"))(func (nodes ...*Node) (*Node, *ParseError) {
-			nodes[0].Label = "Deref"
-			return nodes[0].AddKid(nodes[1]), nil
-		}),
-	)
-
-	P["PostUnary"] = Alt(
-		Concat(SC("Factor"), SC("Applies"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return collapse(nodes[0], nodes[1]), nil
-			}),
-			SC("Factor"),
-		)
-
-	aply_swing := func (nodes ...*Node) (*Node, *ParseError) {
-		n := NewNode("T").AddKid(nodes[0]).AddKid(
-			collapse(nodes[0], nodes[1]))
-			return n, nil
-	}
-
-	P["Applies"] = Alt(
-		Concat(SC("Apply"), SC("Applies_"))(aply_swing),
-		Concat(SC("Index"), SC("Applies_"))(aply_swing),
-	)
-
-	P["Applies_"] = Alt(
-		Concat(SC("Apply"), SC("Applies_"))(aply_swing),
-		Concat(SC("Index"), SC("Applies_"))(aply_swing),
-		Epsilon(nil),
-	)
-
-	P["Indexed"] = Concat(SC("NAME"), SC("Indices"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return collapse(nodes[0], nodes[1]), nil
-		})
-
-	P["Indices"] = Alt(
-		Concat(SC("Index"), SC("Indices"))(aply_swing),
-		Epsilon(nil),
-	)
-
-	P["Apply"] = Concat(SC("("), SC("Params"), SC(")"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return NewNode("Call").AddKid(nodes[1].Annotate(nodes)), nil
-		})
-
-	P["Index"] = Concat(SC("["), SC("Expr"), SC("]"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return NewNode("Index").AddKid(nodes[1].Annotate(nodes)), nil
-		})
-
-	P["Params"] = Alt(
-		Concat(SC("Expr"), SC("Params_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("Params").AddKid(nodes[0])
-				if nodes[1] != nil {
-					params.Children = append(params.Children, nodes[1].Children...)
-				}
-				return params.Annotate(nodes), nil
-			}),
-		Epsilon(NewNode("Params")),
-	)
-
-	P["Params_"] = Alt(
-		Concat(SC(","), SC("Expr"), SC("Params_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("Params").AddKid(nodes[1])
-				if nodes[2] != nil {
-					params.Children = append(params.Children, nodes[2].Children...)
-				}
-				return params, nil
-			}),
-		Epsilon(nil),
-	)
-
-	P["Factor"] = Alt(
-		SC("NAME"),
-		SC("INT"),
-		SC("FLOAT"),
-		SC("STRING"),
-		SC("Function"),
-		SC("If"),
-		SC("New"),
-		Concat(SC("("), SC("Expr"), SC(")"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return nodes[1], nil
-			}),
-		)
-
-	P["New"] = Concat(
-		SC("NEW"), SC("NewType"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return nodes[0].AddKid(nodes[1]).Annotate(nodes), nil
-		})
-
-	P["Function"] = Concat(
-		SC("FN"), SC("("), SC("ParamDecls"), SC(")"),
-		SC("Type"), SC("{"), SC("Stmts"), SC("}"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("Func").
-					 AddKid(nodes[2].Annotate(nodes[1:4])).
-					 AddKid(nodes[4]).
-					 AddKid(nodes[6]).Annotate(nodes)
-				return n, nil
-			})
-
-	P["ParamDecls"] = Alt(
-		Concat(SC("NAME"), SC("Type"), SC("ParamDecls_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("ParamDecls").AddKid(
-					NewNode("ParamDecl").AddKid(nodes[0]).AddKid(nodes[1]))
-				if nodes[2] != nil {
-					params.Children = append(params.Children, nodes[2].Children...)
-				}
-				return params.Annotate(nodes), nil
-			}),
-		Epsilon(NewNode("ParamDecls")),
-	)
-
-	P["ParamDecls_"] = Alt(
-		Concat(SC(","), SC("NAME"), SC("Type"), SC("ParamDecls_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("ParamDecls").AddKid(
-					NewNode("ParamDecl").AddKid(nodes[1]).AddKid(nodes[2]))
-				if nodes[3] != nil {
-					params.Children = append(params.Children, nodes[3].Children...)
-				}
-				return params, nil
-			}),
-		Epsilon(nil),
-	)
-
-	P["Type"] = Alt(
-		Concat(SC("NAME"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return NewNode("TypeName").AddKid(nodes[0]), nil
-			}),
-		Concat(SC("FN"), SC("("), SC("TypeParams"), SC(")"), SC("Type"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("FuncType").AddKid(nodes[2]).AddKid(nodes[4]).Annotate(nodes)
-				return n, nil
-			}),
-		Concat(SC("["), SC("Expr"), SC("]"), SC("Type"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("ArrayType").AddKid(nodes[3]).AddKid(nodes[1]).Annotate(nodes)
-				return n, nil
-			}),
-		Concat(SC("["), SC("]"), SC("Type"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("ArrayType").AddKid(nodes[2]).Annotate(nodes)
-				return n, nil
-			}),
-		Concat(SC("BOX"), SC("("), SC("NAME"), SC(")"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("BoxType").AddKid(NewNode("TypeName").AddKid(nodes[2])).Annotate(nodes)
-				return n, nil
-			}),
-	)
-
-	P["NewType"] = Alt(
-		Concat(SC("NAME"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return NewNode("TypeName").AddKid(nodes[0]), nil
-			}),
-		Concat(SC("["), SC("Expr"), SC("]"), SC("Type"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("ArrayType").AddKid(nodes[3]).AddKid(nodes[1]).Annotate(nodes)
-				return n, nil
-			}),
-	)
-
-	P["TypeParams"] = Alt(
-		Concat(SC("Type"), SC("TypeParams_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("TypeParams").AddKid(nodes[0])
-				if nodes[1] != nil {
-					params.Children = append(params.Children, nodes[1].Children...)
-				}
-				return params, nil
-			}),
-		Epsilon(NewNode("TypeParams")),
-	)
-
-	P["TypeParams_"] = Alt(
-		Concat(SC(","), SC("Type"), SC("TypeParams_"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				params := NewNode("TypeParams").AddKid(nodes[1])
-				if nodes[2] != nil {
-					params.Children = append(params.Children, nodes[2].Children...)
-				}
-				return params, nil
-			}),
-		Epsilon(nil),
-	)
-
-	P["If"] = Concat(
-		SC("IF"), SC("BooleanExpr"), SC("{"), SC("Stmts"), SC("}"),
-		SC("ELSE"), SC("IfElse"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				n := NewNode("If").AddKid(nodes[1]).AddKid(nodes[3]).AddKid(nodes[6])
-				return n, nil
-			})
-
-	P["IfElse"] = Alt(
-		Concat(SC("If"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return NewNode("Stmts").AddKid(nodes[0]), nil
-			}),
-		Concat(SC("{"), SC("Stmts"), SC("}")) (
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return nodes[1], nil
-			}),
-	)
-
-	P["BooleanExpr"] = Concat(SC("AndExpr"), SC("BooleanExpr_"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return collapse(nodes[0], nodes[1]), nil
-		})
-
-	P["BooleanExpr_"] = Alt(
-		Concat(SC("||"), SC("AndExpr"), SC("BooleanExpr_"))(swing),
-		Epsilon(nil),
-	)
-
-	P["AndExpr"] = Concat(SC("NotExpr"), SC("AndExpr_"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return collapse(nodes[0], nodes[1]), nil
-		})
-
-	P["AndExpr_"] = Alt(
-		Concat(SC("&&"), SC("NotExpr"), SC("AndExpr_"))(swing),
-		Epsilon(nil),
-	)
-
-	P["NotExpr"] = Alt(
-		Concat(SC("!"), SC("BooleanTerm"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return NewNode("!").AddKid(nodes[1]), nil
-			}),
-		SC("BooleanTerm"),
-	)
-
-	P["BooleanTerm"] = Alt(
-		Alt(SC("CmpExpr"), SC("BooleanConstant")),
-		Concat(SC("("), SC("BooleanExpr"), SC(")"))(
-			func (nodes ...*Node) (*Node, *ParseError) {
-				return nodes[1], nil
-			}),
-		)
-
-	P["CmpExpr"] = Concat(SC("Expr"), SC("CmpOp"), SC("Expr"))(
-		func (nodes ...*Node) (*Node, *ParseError) {
-			return nodes[1].AddKid(nodes[0]).AddKid(nodes[2]), nil
-		})
-
-	P["CmpOp"] = Alt(SC("<"), SC("<="), SC("=="), SC("!="), SC(">"), SC(">="))
-
-	P["BooleanConstant"] = Alt(SC("TRUE"), SC("FALSE"))
-
-	i, node, err := P["Stmts"].Consume(0)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(tokens) != i {
-		return nil, top_err
-	}
-	return node, nil
+			p.i = start
+		}
+	}
+	start := p.i
+	expr := p.expr()
+	if expr == nil {
+		// an Expr can not start a comparison here either
+		switch p.at(0) {
+		case "TRUE", "FALSE":
+			return p.next()
+		case "(":
+			return p.parenCondition()
+		}
+		return nil
+	}
+	if p.peek("=") {
+		if target := p.target(expr, start); target != nil {
+			if assign := p.assign(target); assign != nil {
+				return assign
+			}
+		}
+	}
+	return expr
+}
+
+// target gives what the expression parsed from start assigns to, nil if it
+// is not an assignee.
+func (p *parser) target(expr *Node, start int) *Node {
+	if indexed(expr) {
+		return expr
+	}
+	if expr.Label != "Tuple" {
+		return nil
+	}
+	// the parens are the Tuple's own, not around it
+	if first, _ := expr.Get(0).Tokens(); first != p.tokens[start+1] {
+		return nil
+	}
+	for _, kid := range expr.Children {
+		if !indexed(kid) {
+			return nil
+		}
+	}
+	open, close := expr.Tokens()
+	targets := NewNode("Targets")
+	targets.Children = expr.Children
+	return targets.Annotate([]*Node{NewTokenNode(open), NewTokenNode(close)})
+}
+
+// indexed reports whether node is a NAME followed by indices and fields
+// written without parens.
+func indexed(node *Node) bool {
+	if first, _ := node.Tokens(); first == nil || Tokens[first.Type] != "NAME" {
+		return false
+	}
+	for node.Label == "Index" || node.Label == "Field" {
+		node = node.Get(0)
+	}
+	return node.Label == "NAME"
+}
+
+func (p *parser) assign(target *Node) *Node {
+	start := p.i
+	eq := p.expect("=")
+	if eq == nil {
+		return nil
+	}
+	value := p.expr()
+	if value == nil {
+		return p.reset(start)
+	}
+	return NewNode("Assign").AddKid(target).AddKid(value).Annotate([]*Node{target, eq, value})
+}
+
+func (p *parser) moduleDecl() *Node {
+	start := p.i
+	module := p.expect("MODULE")
+	name := p.expect("NAME")
+	if module == nil || name == nil {
+		return p.reset(start)
+	}
+	return NewNode("ModuleDecl").AddKid(name).Annotate([]*Node{module, name})
+}
+
+func (p *parser) importDecl() *Node {
+	start := p.i
+	imp := p.expect("IMPORT")
+	path := p.expect("STRING")
+	if imp == nil || path == nil {
+		return p.reset(start)
+	}
+	return NewNode("Import").AddKid(path).Annotate([]*Node{imp, path})
+}
+
+func (p *parser) typeDecl() *Node {
+	start := p.i
+	typ := p.expect("TYPE")
+	if typ == nil {
+		return nil
+	}
+	name := p.expect("NAME")
+	if name == nil {
+		return p.reset(start)
+	}
+	label := "TypeDecl"
+	var eq *Node
+	if p.peek("=") {
+		label = "TypeAlias"
+		eq = p.next()
+	}
+	def := p.union()
+	if def == nil {
+		def = p.typ()
+	}
+	if def == nil {
+		return p.reset(start)
+	}
+	return NewNode(label).AddKid(name).AddKid(def).Annotate([]*Node{typ, name, eq, def})
 }
 
+func (p *parser) union() *Node {
+	start := p.i
+	first := p.variant()
+	if first == nil {
+		return nil
+	}
+	union := NewNode("Union").AddKid(first)
+	for p.peek("|") {
+		bar := p.i
+		p.i++
+		variant := p.variant()
+		if variant == nil {
+			p.i = bar
+			break
+		}
+		union.AddKid(variant)
+	}
+	if len(union.Children) == 1 && first.Get(1).Leaf() {
+		tok, _ := first.Tokens()
+		p.error(Error("A union needs more than one variant or some fields. %v", tok))
+		return p.reset(start)
+	}
+	return union.Annotate(union.Children)
+}
 
+// variant parses a NAME and the types of its fields in parens, if they
+// follow it, otherwise the paren is left for the next statement.
+func (p *parser) variant() *Node {
+	name := p.expect("NAME")
+	if name == nil {
+		return nil
+	}
+	if p.peek("(") {
+		start := p.i
+		open := p.next()
+		params := p.typeParams()
+		if close := p.expect(")"); close != nil {
+			params.Annotate([]*Node{open, params, close})
+			return NewNode("Variant").AddKid(name).AddKid(params).Annotate([]*Node{name, params})
+		}
+		p.i = start
+	}
+	return NewNode("Variant").AddKid(name).AddKid(NewNode("TypeParams")).Annotate([]*Node{name})
+}
 
+func (p *parser) expr() *Node {
+	return p.binary(arithmetic, p.unary)
+}
+
+func (p *parser) unary() *Node {
+	if !p.peek("-") && !p.peek("^") {
+		return p.postUnary()
+	}
+	start := p.i
+	op := p.next()
+	operand := p.postUnary()
+	if operand == nil {
+		return p.reset(start)
+	}
+	if op.Label == "-" {
+		op.Label = "Negate"
+	} else {
+		op.Label = "Deref"
+	}
+	return op.AddKid(operand).Annotate([]*Node{op, operand})
+}
 
+// postUnary parses a factor and the calls, indices, instantiations and
+// fields applied to it from left to right.
+func (p *parser) postUnary() *Node {
+	node := p.factor()
+	for node != nil {
+		var op *Node
+		switch p.at(0) {
+		case "(":
+			op = p.apply()
+		case "[":
+			if op = p.index(); op == nil {
+				op = p.instantiate()
+			}
+		case ".":
+			op = p.field()
+		}
+		if op == nil {
+			break
+		}
+		node = op.PrependKid(node)
+	}
+	return node
+}
+
+// apply parses the parameters of a call, which must start on the line its
+// callee ends on so a paren on the next line starts a statement.
+func (p *parser) apply() *Node {
+	if p.i > 0 && p.tokens[p.i].StartLine != p.tokens[p.i-1].EndLine {
+		p.error(Error("Expected a call to start on the line of its callee got %v", p.tokens[p.i]))
+		return nil
+	}
+	start := p.i
+	open := p.expect("(")
+	params := NewNode("Params")
+	if first := p.expr(); first != nil {
+		p.list(params.AddKid(first), p.expr)
+	}
+	close := p.expect(")")
+	if open == nil || close == nil {
+		return p.reset(start)
+	}
+	return NewNode("Call").AddKid(params.Annotate([]*Node{open, params, close}))
+}
+
+func (p *parser) index() *Node {
+	start := p.i
+	open := p.expect("[")
+	if open == nil {
+		return nil
+	}
+	expr := p.expr()
+	if expr == nil {
+		return p.reset(start)
+	}
+	close := p.expect("]")
+	if close == nil {
+		return p.reset(start)
+	}
+	return NewNode("Index").AddKid(expr.Annotate([]*Node{open, expr, close}))
+}
+
+func (p *parser) instantiate() *Node {
+	start := p.i
+	open := p.expect("[")
+	if open == nil {
+		return nil
+	}
+	first := p.typ()
+	if first == nil {
+		return p.reset(start)
+	}
+	params := p.list(NewNode("TypeParams").AddKid(first), p.typ)
+	close := p.expect("]")
+	if close == nil {
+		return p.reset(start)
+	}
+	return NewNode("Instantiate").AddKid(params.Annotate([]*Node{open, params, close}))
+}
+
+func (p *parser) field() *Node {
+	start := p.i
+	dot := p.expect(".")
+	if dot == nil {
+		return nil
+	}
+	name := p.expect("NAME")
+	if name == nil {
+		return p.reset(start)
+	}
+	return NewNode("Field").AddKid(name).Annotate([]*Node{dot, name})
+}
+
+func (p *parser) factor() *Node {
+	switch p.at(0) {
+	case "NAME", "INT", "FLOAT", "STRING":
+		return p.next()
+	case "FN":
+		return p.function()
+	case "IF":
+		return p.ifExpr()
+	case "NEW":
+		return p.newExpr()
+	case "RECORD":
+		return p.record()
+	case "MATCH":
+		return p.match()
+	case "(":
+		return p.paren()
+	}
+	p.expected("an expression")
+	return nil
+}
+
+// paren parses an expression in parens or a Tuple.
+func (p *parser) paren() *Node {
+	start := p.i
+	open := p.expect("(")
+	if open == nil {
+		return nil
+	}
+	first := p.expr()
+	if first == nil {
+		return p.reset(start)
+	}
+	tuple := p.list(NewNode("Tuple").AddKid(first), p.expr)
+	close := p.expect(")")
+	if close == nil {
+		return p.reset(start)
+	}
+	if len(tuple.Children) == 1 {
+		return first.Annotate([]*Node{open, first, close})
+	}
+	return tuple.Annotate([]*Node{open, tuple, close})
+}
+
+func (p *parser) function() *Node {
+	start := p.i
+	fn := p.expect("FN")
+	if fn == nil {
+		return nil
+	}
+	var vars *Node
+	if p.peek("[") {
+		open := p.next()
+		first := p.expect("NAME")
+		if first == nil {
+			return p.reset(start)
+		}
+		vars = p.list(NewNode("TypeVars").AddKid(first), func() *Node { return p.expect("NAME") })
+		close := p.expect("]")
+		if close == nil {
+			return p.reset(start)
+		}
+		vars.Annotate([]*Node{open, vars, close})
+	}
+	open := p.expect("(")
+	if open == nil {
+		return p.reset(start)
+	}
+	params := NewNode("ParamDecls")
+	if first := p.paramDecl(); first != nil {
+		p.list(params.AddKid(first), p.paramDecl)
+	}
+	close := p.expect(")")
+	if close == nil {
+		return p.reset(start)
+	}
+	params.Annotate([]*Node{open, params, close})
+	ret := p.maybeType()
+	// unlike the arms of an If the body keeps the location of its
+	// statements
+	open = p.expect("{")
+	if open == nil {
+		return p.reset(start)
+	}
+	body := p.stmts()
+	if body == nil {
+		return p.reset(start)
+	}
+	close = p.expect("}")
+	if close == nil {
+		return p.reset(start)
+	}
+	n := NewNode("Func").AddKid(params).AddKid(ret).AddKid(body)
+	if vars != nil {
+		n.AddKid(vars)
+	}
+	return n.Annotate([]*Node{fn, params, ret, open, body, close, vars})
+}
+
+func (p *parser) paramDecl() *Node {
+	name := p.expect("NAME")
+	if name == nil {
+		return nil
+	}
+	return NewNode("ParamDecl").AddKid(name).AddKid(p.maybeType())
+}
+
+// maybeType parses a Type or gives an Infer node when there is none.
+func (p *parser) maybeType() *Node {
+	if t := p.typ(); t != nil {
+		return t
+	}
+	return NewNode("Infer")
+}
+
+// block parses { Stmts } giving the Stmts annotated with the braces.
+func (p *parser) block() *Node {
+	start := p.i
+	open := p.expect("{")
+	if open == nil {
+		return nil
+	}
+	stmts := p.stmts()
+	if stmts == nil {
+		return p.reset(start)
+	}
+	close := p.expect("}")
+	if close == nil {
+		return p.reset(start)
+	}
+	return stmts.Annotate([]*Node{open, stmts, close})
+}
+
+func (p *parser) typ() *Node {
+	start := p.i
+	switch p.at(0) {
+	case "NAME":
+		if p.at(1) == "." && p.at(2) == "NAME" {
+			nodes := []*Node{p.next(), p.next(), p.next()}
+			return NewNode("QualifiedType").AddKid(nodes[0]).AddKid(nodes[2]).Annotate(nodes)
+		}
+		name := p.next()
+		return NewNode("TypeName").AddKid(name).Annotate([]*Node{name})
+	case "FN":
+		fn := p.next()
+		open := p.expect("(")
+		if open == nil {
+			return p.reset(start)
+		}
+		params := p.typeParams()
+		close := p.expect(")")
+		if close == nil {
+			return p.reset(start)
+		}
+		ret := p.typ()
+		if ret == nil {
+			return p.reset(start)
+		}
+		return NewNode("FuncType").AddKid(params).AddKid(ret).Annotate([]*Node{fn, open, params, close, ret})
+	case "[":
+		open := p.next()
+		var size *Node
+		if !p.peek("]") {
+			if size = p.expr(); size == nil {
+				return p.reset(start)
+			}
+		}
+		close := p.expect("]")
+		if close == nil {
+			return p.reset(start)
+		}
+		elem := p.typ()
+		if elem == nil {
+			return p.reset(start)
+		}
+		n := NewNode("ArrayType").AddKid(elem)
+		if size != nil {
+			n.AddKid(size)
+		}
+		return n.Annotate([]*Node{open, size, close, elem})
+	case "BOX":
+		box := p.next()
+		open := p.expect("(")
+		if open == nil {
+			return p.reset(start)
+		}
+		name := p.expect("NAME")
+		if name == nil {
+			return p.reset(start)
+		}
+		close := p.expect(")")
+		if close == nil {
+			return p.reset(start)
+		}
+		return NewNode("BoxType").AddKid(NewNode("TypeName").AddKid(name)).Annotate([]*Node{box, open, name, close})
+	case "RECORD":
+		record := p.next()
+		open := p.expect("{")
+		if open == nil {
+			return p.reset(start)
+		}
+		n := NewNode("RecordType")
+		if first := p.fieldDecl(); first != nil {
+			p.list(n.AddKid(first), p.fieldDecl)
+		}
+		close := p.expect("}")
+		if close == nil {
+			return p.reset(start)
+		}
+		return n.Annotate([]*Node{record, open, n, close})
+	case "(":
+		open := p.next()
+		first := p.typ()
+		if first == nil {
+			return p.reset(start)
+		}
+		if p.expect(",") == nil {
+			return p.reset(start)
+		}
+		second := p.typ()
+		if second == nil {
+			return p.reset(start)
+		}
+		n := p.list(NewNode("TupleType").AddKid(first).AddKid(second), p.typ)
+		close := p.expect(")")
+		if close == nil {
+			return p.reset(start)
+		}
+		return n.Annotate([]*Node{open, n, close})
+	}
+	p.expected("a type")
+	return nil
+}
+
+func (p *parser) typeParams() *Node {
+	params := NewNode("TypeParams")
+	if first := p.typ(); first != nil {
+		p.list(params.AddKid(first), p.typ)
+	}
+	return params
+}
+
+func (p *parser) fieldDecl() *Node {
+	start := p.i
+	name := p.expect("NAME")
+	if name == nil {
+		return nil
+	}
+	typ := p.typ()
+	if typ == nil {
+		return p.reset(start)
+	}
+	return NewNode("FieldDecl").AddKid(name).AddKid(typ)
+}
+
+func (p *parser) newExpr() *Node {
+	start := p.i
+	n := p.expect("NEW")
+	if n == nil {
+		return nil
+	}
+	var typ *Node
+	switch p.at(0) {
+	case "NAME":
+		name := p.next()
+		typ = NewNode("TypeName").AddKid(name).Annotate([]*Node{name})
+	case "[":
+		open := p.next()
+		size := p.expr()
+		if size == nil {
+			return p.reset(start)
+		}
+		close := p.expect("]")
+		if close == nil {
+			return p.reset(start)
+		}
+		elem := p.typ()
+		if elem == nil {
+			return p.reset(start)
+		}
+		typ = NewNode("ArrayType").AddKid(elem).AddKid(size).Annotate([]*Node{open, size, close, elem})
+	default:
+		p.expected("a type")
+		return p.reset(start)
+	}
+	return n.AddKid(typ).Annotate([]*Node{n, typ})
+}
+
+func (p *parser) record() *Node {
+	start := p.i
+	record := p.expect("RECORD")
+	if record == nil {
+		return nil
+	}
+	open := p.expect("{")
+	if open == nil {
+		return p.reset(start)
+	}
+	n := NewNode("Record")
+	if first := p.fieldInit(); first != nil {
+		p.list(n.AddKid(first), p.fieldInit)
+	}
+	close := p.expect("}")
+	if close == nil {
+		return p.reset(start)
+	}
+	return n.Annotate([]*Node{record, open, n, close})
+}
+
+func (p *parser) fieldInit() *Node {
+	start := p.i
+	name := p.expect("NAME")
+	if name == nil {
+		return nil
+	}
+	if p.expect(":") == nil {
+		return p.reset(start)
+	}
+	value := p.expr()
+	if value == nil {
+		return p.reset(start)
+	}
+	return NewNode("FieldInit").AddKid(name).AddKid(value)
+}
+
+func (p *parser) match() *Node {
+	start := p.i
+	match := p.expect("MATCH")
+	if match == nil {
+		return nil
+	}
+	expr := p.expr()
+	if expr == nil {
+		return p.reset(start)
+	}
+	open := p.expect("{")
+	if open == nil {
+		return p.reset(start)
+	}
+	first := p.arm()
+	if first == nil {
+		return p.reset(start)
+	}
+	n := NewNode("Match").AddKid(expr).AddKid(first)
+	for p.peek(",") {
+		p.i++
+		arm := p.arm()
+		if arm == nil {
+			// a trailing comma
+			break
+		}
+		n.AddKid(arm)
+	}
+	close := p.expect("}")
+	if close == nil {
+		return p.reset(start)
+	}
+	return n.Annotate([]*Node{match, n, close})
+}
+
+func (p *parser) arm() *Node {
+	start := p.i
+	pattern := p.pattern()
+	if pattern == nil {
+		return nil
+	}
+	arrow := p.expect("=>")
+	if arrow == nil {
+		return p.reset(start)
+	}
+	expr := p.expr()
+	if expr == nil {
+		return p.reset(start)
+	}
+	return NewNode("Arm").AddKid(pattern).AddKid(expr).Annotate([]*Node{pattern, arrow, expr})
+}
+
+func (p *parser) pattern() *Node {
+	name := p.expect("NAME")
+	if name == nil {
+		return nil
+	}
+	if p.peek("(") {
+		start := p.i
+		open := p.next()
+		if first := p.expect("NAME"); first != nil {
+			binders := p.list(NewNode("Binders").AddKid(first), func() *Node { return p.expect("NAME") })
+			if close := p.expect(")"); close != nil {
+				binders.Annotate([]*Node{open, binders, close})
+				return NewNode("Pattern").AddKid(name).AddKid(binders).Annotate([]*Node{name, binders})
+			}
+		}
+		p.i = start
+	}
+	return NewNode("Pattern").AddKid(name).AddKid(NewNode("Binders")).Annotate([]*Node{name})
+}
+
+func (p *parser) ifExpr() *Node {
+	start := p.i
+	n := p.expect("IF")
+	if n == nil {
+		return nil
+	}
+	cond := p.condition()
+	if cond == nil {
+		return p.reset(start)
+	}
+	then := p.block()
+	if then == nil {
+		return p.reset(start)
+	}
+	if p.expect("ELSE") == nil {
+		return p.reset(start)
+	}
+	var otherwise *Node
+	if p.peek("IF") {
+		if elif := p.ifExpr(); elif != nil {
+			otherwise = NewNode("Stmts").AddKid(elif)
+		}
+	} else {
+		otherwise = p.block()
+	}
+	if otherwise == nil {
+		return p.reset(start)
+	}
+	return NewNode("If").AddKid(cond).AddKid(then).AddKid(otherwise).Annotate([]*Node{n, cond, then, otherwise})
+}
+
+// condition parses a BooleanExpr.
+func (p *parser) condition() *Node {
+	return p.binary(connectives, p.not)
+}
+
+func (p *parser) not() *Node {
+	if !p.peek("!") {
+		return p.booleanTerm()
+	}
+	start := p.i
+	bang := p.next()
+	term := p.booleanTerm()
+	if term == nil {
+		return p.reset(start)
+	}
+	return NewNode("!").AddKid(term).Annotate([]*Node{bang, term})
+}
+
+// booleanTerm tries a comparison first, so a paren which is not the start
+// of one is parsed again as the paren of a condition.
+func (p *parser) booleanTerm() *Node {
+	start := p.i
+	if left := p.expr(); left != nil {
+		if comparisons[p.at(0)] {
+			op := p.next()
+			if right := p.expr(); right != nil {
+				return op.AddKid(left).AddKid(right).Annotate([]*Node{left, op, right})
+			}
+		} else {
+			p.expected("a comparison")
+		}
+		p.i = start
+	}
+	switch p.at(0) {
+	case "TRUE", "FALSE":
+		return p.next()
+	case "(":
+		return p.parenCondition()
+	}
+	return nil
+}
+
+func (p *parser) parenCondition() *Node {
+	start := p.i
+	open := p.expect("(")
+	if open == nil {
+		return nil
+	}
+	cond := p.condition()
+	if cond == nil {
+		return p.reset(start)
+	}
+	close := p.expect(")")
+	if close == nil {
+		return p.reset(start)
+	}
+	return cond.Annotate([]*Node{open, cond, close})
+}
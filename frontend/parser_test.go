@@ -0,0 +1,137 @@
+package frontend
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// shape writes a tree on one line as Label(kids...), with the value of a
+// token after its label.
+func shape(n *Node) string {
+	s := n.Label
+	if n.Value != nil {
+		s = fmt.Sprintf("%v %v", s, n.Value)
+	}
+	if n.Leaf() {
+		return s
+	}
+	kids := make([]string, 0, len(n.Children))
+	for _, kid := range n.Children {
+		kids = append(kids, shape(kid))
+	}
+	return s + "(" + strings.Join(kids, ", ") + ")"
+}
+
+func TestParseShapes(t *testing.T) {
+	for _, c := range []struct {
+		src   string
+		stmts []string
+	}{
+		// once a tree was made for an operand tried by two alternatives it
+		// was given the operand twice
+		{"x[2 + 1 - 1]", []string{"Index(NAME x, - -(+ +(INT 2, INT 1), INT 1))"}},
+		{"if (a * b < c) { 1 } else { 2 }", []string{
+			"If(< <(* *(NAME a, NAME b), NAME c), Stmts(INT 1), Stmts(INT 2))",
+		}},
+		// the old parser gave * three children here, x, x and y
+		{"q = if (x * y < z) { x } else { z }", []string{
+			"Assign(NAME q, If(< <(* *(NAME x, NAME y), NAME z), Stmts(NAME x), Stmts(NAME z)))",
+		}},
+		{"a - b - c * d", []string{"- -(- -(NAME a, NAME b), * *(NAME c, NAME d))"}},
+		{"if a < 1 || b < 2 && !c == d {1} else {2}", []string{
+			"If(|| ||(< <(NAME a, INT 1), && &&(< <(NAME b, INT 2), !(== ==(NAME c, NAME d)))), Stmts(INT 1), Stmts(INT 2))",
+		}},
+		{"if ((a < b)) {1} else if true {2} else {3}", []string{
+			"If(< <(NAME a, NAME b), Stmts(INT 1), Stmts(If(TRUE, Stmts(INT 2), Stmts(INT 3))))",
+		}},
+		// a paren which is not the parameters of a call is a statement
+		{"x (a < b)", []string{"NAME x", "< <(NAME a, NAME b)"}},
+		{"^p = -q.r[1]", []string{"Assign(Deref(NAME p), Negate -(Index(Field(NAME q, NAME r), INT 1)))"}},
+		{"(a, b.c) = f(1)", []string{"Assign(Targets(NAME a, Field(NAME b, NAME c)), Call(NAME f, Params(INT 1)))"}},
+		{"id[int, string](1)", []string{"Call(Instantiate(NAME id, TypeParams(TypeName(NAME int), TypeName(NAME string))), Params(INT 1))"}},
+		// nor is a paren after a variant which does not hold types
+		{"type T A(int) | B\n(1, 2)", []string{
+			"TypeDecl(NAME T, Union(Variant(NAME A, TypeParams(TypeName(NAME int))), Variant(NAME B, TypeParams)))",
+			"Tuple(INT 1, INT 2)",
+		}},
+		{"type P = (int, [3]box(int))", []string{
+			"TypeAlias(NAME P, TupleType(TypeName(NAME int), ArrayType(BoxType(TypeName(NAME int)), INT 3)))",
+		}},
+		{"match o { Some(x) => x, None => 0, }", []string{
+			"Match(NAME o, Arm(Pattern(NAME Some, Binders(NAME x)), NAME x), Arm(Pattern(NAME None, Binders), INT 0))",
+		}},
+		{"f = fn[T](x T) { x }", []string{
+			"Assign(NAME f, Func(ParamDecls(ParamDecl(NAME x, TypeName(NAME T))), Infer, Stmts(NAME x), TypeVars(NAME T)))",
+		}},
+	} {
+		node := parseString(t, c.src, "shapes.x")
+		var stmts []string
+		for _, stmt := range node.Children {
+			stmts = append(stmts, shape(stmt))
+		}
+		if strings.Join(stmts, "\n") != strings.Join(c.stmts, "\n") {
+			t.Errorf("%q: expected\n%v\ngot\n%v", c.src, strings.Join(c.stmts, "\n"), strings.Join(stmts, "\n"))
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		line int
+		col  int
+	}{
+		// an error at the end of the input has no token
+		{"x = (1, 2", 0, 0},
+		{"x = 1 +", 0, 0},
+		{"((a, b)) = (1, 2)", 1, 10},
+		{"type T = A | 1", 1, 14},
+		{"f(x) = 1", 1, 6},
+		{"if a < b {1} else 2", 1, 19},
+	} {
+		tokens, err := Lex(c.src, "errors.x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Parse(tokens)
+		pe, is := err.(*ParseError)
+		if !is || pe == nil {
+			t.Errorf("%q: expected a parse error got %v", c.src, err)
+			continue
+		}
+		if c.line == 0 {
+			if pe.Token != nil {
+				t.Errorf("%q: expected the error at the end got %v", c.src, pe)
+			}
+		} else if pe.Token == nil || pe.Token.StartLine != c.line || pe.Token.StartColumn != c.col {
+			t.Errorf("%q: expected the error at %d:%d got %v", c.src, c.line, c.col, pe)
+		}
+	}
+}
+
+// BenchmarkParse parses long.x repeated more and more times. The time per
+// byte should stay the same as the program grows.
+func BenchmarkParse(b *testing.B) {
+	src, err := ioutil.ReadFile("../ex/long.x")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, n := range []int{1, 2, 4, 8} {
+		program := bytes.Repeat(append(src, '\n'), n)
+		tokens, err := Lex(string(program), "long.x")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("copies=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(program)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Parse(tokens); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReconstruct(t *testing.T) {
+	paths, err := filepath.Glob("../ex/*.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = append(paths, "")
+	for _, path := range paths {
+		src := []byte("  x = \"a\\tb\" /* c\n d */ + 1\t// end\n\n\n")
+		if path != "" {
+			src, err = ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		tokens, eof, err := LexTrivia(string(src), path)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if got := Reconstruct(tokens, eof); got != string(src) {
+			t.Errorf("%v: reconstruction differs\n%q\n%q", path, src, got)
+		}
+	}
+}
+
+func TestTrivia(t *testing.T) {
+	src := "// about x\nx = 1 // one\n\n// about y\ny = x\n// the end\n"
+	tokens, eof, err := LexTrivia(src, "trivia.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := func(trivia []*Trivia) (s string) {
+		for _, t := range trivia {
+			s += t.Text
+		}
+		return s
+	}
+	one := tokens[2]
+	if got := text(one.Trailing); got != " // one\n" {
+		t.Errorf("expected the comment to trail 1 got %q", got)
+	}
+	if tokens[1].Trailing[0].Kind != "WHITESPACE" || tokens[2].Trailing[1].Kind != "COMMENT" {
+		t.Errorf("unexpected trivia kinds %v %v", tokens[1].Trailing, tokens[2].Trailing)
+	}
+	c := tokens[2].Trailing[1]
+	if c.StartLine != 2 || c.StartColumn != 7 || c.EndLine != 2 || c.EndColumn != 12 {
+		t.Errorf("unexpected comment location %v", c)
+	}
+	if got := text(tokens[3].Leading); got != "\n// about y\n" {
+		t.Errorf("expected y to lead with its comment got %q", got)
+	}
+	if got := text(eof); got != "// the end\n" {
+		t.Errorf("expected the last comment at eof got %q", got)
+	}
+
+	node, err := Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := node.Get(0)
+	if got := text(x.Leading()); got != "// about x\n" {
+		t.Errorf("expected x's statement to lead with its comment got %q", got)
+	}
+	if got := text(x.Trailing()); got != " // one\n" {
+		t.Errorf("expected x's statement to trail its comment got %q", got)
+	}
+	if first, last := node.Tokens(); first != tokens[0] || last != tokens[len(tokens)-1] {
+		t.Errorf("expected the program to span all tokens got %v %v", first, last)
+	}
+}
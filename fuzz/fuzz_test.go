@@ -0,0 +1,86 @@
+package fuzz
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+var programs = flag.Int("programs", 100, "the count of programs to generate")
+var seed = flag.Int64("seed", 1, "the seed of the first program")
+var native = flag.Bool("native", false, "fail rather than skip where gcc cannot link 32 bit programs")
+
+func TestGenerate(t *testing.T) {
+	for s := *seed; s < *seed+int64(*programs); s++ {
+		p := NewGenerator(s).Program()
+		src := p.String()
+		if src != NewGenerator(s).Program().String() {
+			t.Fatalf("expected seed %d to generate the same program twice", s)
+		}
+		out, err := Evaluate(src)
+		if err != nil {
+			t.Fatalf("seed %d: %v in\n%v", s, err, src)
+		}
+		if out == "" {
+			t.Errorf("seed %d: expected the program to print in\n%v", s, src)
+		}
+		if _, err := Assemble(src); err != nil {
+			t.Fatalf("seed %d: %v in\n%v", s, err, src)
+		}
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	// a stand in for a bug: multiplying anything by 7
+	fails := func(p *Node) bool {
+		if !strings.Contains(p.String(), "* 7)") {
+			return false
+		}
+		_, err := Check(p.String())
+		return err == nil
+	}
+	for s := int64(1); s < 100; s++ {
+		p := NewGenerator(s).Program()
+		if !fails(p) {
+			continue
+		}
+		src := p.String()
+		min := Minimize(p, fails)
+		if p.String() != src {
+			t.Fatalf("expected the program to be left as it is")
+		}
+		if !fails(min) {
+			t.Fatalf("expected the minimized program to fail\n%v", min)
+		}
+		if len(min.String()) >= len(src)/2 {
+			t.Errorf("expected\n%v\nto shrink by half got\n%v", src, min)
+		}
+		return
+	}
+	t.Fatal("expected a program multiplying by 7")
+}
+
+// TestDifferential compares what the evaluator and the compiled programs
+// print, minimizing the programs which differ. It is skipped where gcc
+// cannot link 32 bit programs unless -native is given, so a CI which means
+// to run it cannot skip it without noticing.
+func TestDifferential(t *testing.T) {
+	gcc := GCC()
+	if gcc == "" && *native {
+		t.Fatal("gcc cannot link 32 bit programs, install a 32 bit libc or drop -native")
+	} else if gcc == "" {
+		t.Skip("gcc cannot link 32 bit programs, -native makes this fail instead")
+	}
+	for s := *seed; s < *seed+int64(*programs); s++ {
+		p := NewGenerator(s).Program()
+		if _, differs := Differ(gcc, p.String()); !differs {
+			continue
+		}
+		min := Minimize(p, func(p *Node) bool {
+			_, differs := Differ(gcc, p.String())
+			return differs
+		})
+		how, _ := Differ(gcc, min.String())
+		t.Errorf("seed %d: %v\nin\n%v", s, how, min)
+	}
+}
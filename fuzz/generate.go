@@ -0,0 +1,380 @@
+// Package fuzz generates random well typed programs to run through both the
+// evaluator and the compiler, so a difference in what they print shows a
+// bug in one of them, and shrinks the programs which show one.
+//
+// The programs are made of ints, floats, arrays of either, conversions
+// between ints, floats and strings, ifs, functions nested in functions which
+// use the names of the functions around them and calls of those functions.
+// Every int stored, passed or returned is reduced modulo 1000 and only
+// literals are multiplied, so the 64 bit ints of the evaluator and the 32
+// bit ints of the compiled program agree. Floats are made from ints and are
+// not added or multiplied, which the compiler does not do yet, so they hold
+// whole numbers which single precision keeps exactly.
+package fuzz
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Node is a node of a generated program. Programs are trees of their own,
+// rather than frontend.Nodes, so they are easily shrunk and printed back as
+// source.
+type Node struct {
+	Kind string // block, assign, put, fn, print, call, if, op, not, convert, new, index, int or name
+	Text string // the name, operator, literal, parameters or type
+	Type string // int, float, string or boolean for an expression
+	Kids []*Node
+}
+
+// Copy gives a deep copy of the tree.
+func (self *Node) Copy() *Node {
+	c := &Node{Kind: self.Kind, Text: self.Text, Type: self.Type}
+	for _, kid := range self.Kids {
+		c.Kids = append(c.Kids, kid.Copy())
+	}
+	return c
+}
+
+// String gives the source of the program.
+func (self *Node) String() string {
+	return self.source("")
+}
+
+func (self *Node) source(indent string) string {
+	switch self.Kind {
+	case "block":
+		lines := make([]string, 0, len(self.Kids))
+		for _, kid := range self.Kids {
+			lines = append(lines, indent+kid.source(indent))
+		}
+		return strings.Join(lines, "\n")
+	case "assign":
+		return fmt.Sprintf("%v = %v", self.Text, self.Kids[0].source(indent))
+	case "put":
+		return fmt.Sprintf("%v[%v] = %v", self.Text, self.Kids[0].source(indent), self.Kids[1].source(indent))
+	case "fn":
+		return fmt.Sprintf("fn(%v) int {\n%v\n%v}", self.Text, self.Kids[0].source(indent+"\t"), indent)
+	case "print", "call", "convert":
+		args := make([]string, 0, len(self.Kids))
+		for _, kid := range self.Kids {
+			args = append(args, kid.source(indent))
+		}
+		return fmt.Sprintf("%v(%v)", self.Text, strings.Join(args, ", "))
+	case "if":
+		return fmt.Sprintf("if %v {\n%v\n%v} else {\n%v\n%v}",
+			self.Kids[0].source(indent),
+			self.Kids[1].source(indent+"\t"), indent,
+			self.Kids[2].source(indent+"\t"), indent)
+	case "op":
+		return fmt.Sprintf("(%v %v %v)", self.Kids[0].source(indent), self.Text, self.Kids[1].source(indent))
+	case "not":
+		return fmt.Sprintf("!(%v)", self.Kids[0].source(indent))
+	case "new":
+		return fmt.Sprintf("new [%v]%v", self.Kids[0].source(indent), self.Text)
+	case "index":
+		return fmt.Sprintf("%v[%v]", self.Text, self.Kids[0].source(indent))
+	default:
+		return self.Text
+	}
+}
+
+// Generator makes random programs. MaxDepth bounds the nesting of
+// expressions and MaxStmts the statements of a block.
+type Generator struct {
+	MaxDepth int
+	MaxStmts int
+	rand     *rand.Rand
+	names    int
+}
+
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		MaxDepth: 4,
+		MaxStmts: 5,
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// array is an array defined in a program, its elements are ints or floats.
+type array struct {
+	name   string
+	elem   string
+	length int
+}
+
+// env is what a block may use: the ints, floats and arrays and the
+// functions, by their arities, defined before it.
+type env struct {
+	ints   []string
+	floats []string
+	arrays []array
+	funcs  map[string]int
+}
+
+func (self *env) copy() *env {
+	funcs := make(map[string]int, len(self.funcs))
+	for name, arity := range self.funcs {
+		funcs[name] = arity
+	}
+	return &env{
+		ints:   append([]string(nil), self.ints...),
+		floats: append([]string(nil), self.floats...),
+		arrays: append([]array(nil), self.arrays...),
+		funcs:  funcs,
+	}
+}
+
+// Program makes a program of assignments, functions and prints. It prints
+// at least once.
+func (g *Generator) Program() *Node {
+	e := &env{funcs: make(map[string]int)}
+	block := &Node{Kind: "block"}
+	n := 1 + g.rand.Intn(g.MaxStmts)
+	for i := 0; i < n; i++ {
+		switch g.rand.Intn(3) {
+		case 0:
+			block.Kids = append(block.Kids, g.assign(e, g.MaxDepth))
+		case 1:
+			block.Kids = append(block.Kids, g.function(e, g.MaxDepth))
+		default:
+			block.Kids = append(block.Kids, g.print(e))
+		}
+	}
+	block.Kids = append(block.Kids, g.print(e))
+	return block
+}
+
+func (g *Generator) name(prefix string) string {
+	g.names++
+	return fmt.Sprintf("%v%d", prefix, g.names)
+}
+
+// print prints an int with print_int or an int or float as a string.
+func (g *Generator) print(e *env) *Node {
+	switch g.rand.Intn(3) {
+	case 0:
+		s := convert("string", g.expr(e, g.MaxDepth))
+		return &Node{Kind: "print", Text: "print", Kids: []*Node{s}}
+	case 1:
+		s := convert("string", g.float(e, g.MaxDepth))
+		return &Node{Kind: "print", Text: "print", Kids: []*Node{s}}
+	default:
+		return &Node{Kind: "print", Text: "print_int", Kids: []*Node{reduce(g.expr(e, g.MaxDepth))}}
+	}
+}
+
+// assign defines an int, a float or an array or puts an element of an array
+// defined before.
+func (g *Generator) assign(e *env, depth int) *Node {
+	switch g.rand.Intn(5) {
+	case 0:
+		name := g.name("x")
+		n := &Node{Kind: "assign", Text: name, Kids: []*Node{reduceFloat(g.float(e, depth))}}
+		e.floats = append(e.floats, name)
+		return n
+	case 1:
+		a := array{name: g.name("a"), elem: []string{"int", "float"}[g.rand.Intn(2)], length: 1 + g.rand.Intn(4)}
+		n := &Node{Kind: "assign", Text: a.name, Kids: []*Node{{
+			Kind: "new", Text: a.elem, Kids: []*Node{{Kind: "int", Text: fmt.Sprint(a.length), Type: "int"}},
+		}}}
+		e.arrays = append(e.arrays, a)
+		return n
+	case 2:
+		if len(e.arrays) > 0 {
+			a := e.arrays[g.rand.Intn(len(e.arrays))]
+			value := reduce(g.expr(e, depth))
+			if a.elem == "float" {
+				value = reduceFloat(g.float(e, depth))
+			}
+			return &Node{Kind: "put", Text: a.name, Kids: []*Node{g.index(a), value}}
+		}
+	}
+	name := g.name("v")
+	n := &Node{Kind: "assign", Text: name, Kids: []*Node{reduce(g.expr(e, depth))}}
+	e.ints = append(e.ints, name)
+	return n
+}
+
+// function defines a function which may define functions of its own while
+// depth lasts.
+func (g *Generator) function(e *env, depth int) *Node {
+	name := g.name("f")
+	inner := e.copy()
+	arity := 1 + g.rand.Intn(3)
+	params := make([]string, 0, arity)
+	for i := 0; i < arity; i++ {
+		p := g.name("p")
+		params = append(params, p+" int")
+		inner.ints = append(inner.ints, p)
+	}
+	body := g.block(inner, depth-1, "int")
+	body.Kids[len(body.Kids)-1] = reduce(body.Kids[len(body.Kids)-1])
+	fn := &Node{Kind: "fn", Text: strings.Join(params, ", "), Kids: []*Node{body}}
+	e.funcs[name] = arity
+	return &Node{Kind: "assign", Text: name, Kids: []*Node{fn}}
+}
+
+// block makes statements ending with an int or a float.
+func (g *Generator) block(e *env, depth int, typ string) *Node {
+	e = e.copy()
+	block := &Node{Kind: "block", Type: typ}
+	for i := g.rand.Intn(3); i > 0; i-- {
+		if depth > 1 && g.rand.Intn(2) == 0 {
+			block.Kids = append(block.Kids, g.function(e, depth))
+		} else {
+			block.Kids = append(block.Kids, g.assign(e, depth))
+		}
+	}
+	if typ == "float" {
+		block.Kids = append(block.Kids, g.float(e, depth))
+	} else {
+		block.Kids = append(block.Kids, g.expr(e, depth))
+	}
+	return block
+}
+
+func (g *Generator) expr(e *env, depth int) *Node {
+	if depth <= 0 {
+		return g.leaf(e)
+	}
+	switch g.rand.Intn(8) {
+	case 0:
+		return g.leaf(e)
+	case 1, 2:
+		op := []string{"+", "-"}[g.rand.Intn(2)]
+		return &Node{Kind: "op", Text: op, Type: "int", Kids: []*Node{g.expr(e, depth-1), g.expr(e, depth-1)}}
+	case 3:
+		kids := []*Node{g.expr(e, depth-1), g.literal()}
+		if g.rand.Intn(2) == 0 {
+			kids[0], kids[1] = kids[1], kids[0]
+		}
+		return &Node{Kind: "op", Text: "*", Type: "int", Kids: kids}
+	case 4:
+		return &Node{Kind: "if", Type: "int", Kids: []*Node{
+			g.condition(e, depth-1),
+			g.block(e, depth-1, "int"),
+			g.block(e, depth-1, "int"),
+		}}
+	case 5:
+		return convert("int", g.float(e, depth-1))
+	case 6:
+		return g.element(e, "int")
+	default:
+		return g.call(e, depth)
+	}
+}
+
+// float makes a float from an int, a float defined before, an element of an
+// array of floats or an if.
+func (g *Generator) float(e *env, depth int) *Node {
+	if depth > 0 {
+		switch g.rand.Intn(4) {
+		case 0:
+			return &Node{Kind: "if", Type: "float", Kids: []*Node{
+				g.condition(e, depth-1),
+				g.block(e, depth-1, "float"),
+				g.block(e, depth-1, "float"),
+			}}
+		case 1:
+			if n := g.element(e, "float"); n.Type == "float" {
+				return n
+			}
+		case 2:
+			if len(e.floats) > 0 {
+				return &Node{Kind: "name", Text: e.floats[g.rand.Intn(len(e.floats))], Type: "float"}
+			}
+		}
+		return convert("float", g.expr(e, depth-1))
+	}
+	if len(e.floats) > 0 && g.rand.Intn(2) == 0 {
+		return &Node{Kind: "name", Text: e.floats[g.rand.Intn(len(e.floats))], Type: "float"}
+	}
+	return convert("float", g.literal())
+}
+
+// element reads an element of an array of elem, or gives a leaf if there
+// is no such array.
+func (g *Generator) element(e *env, elem string) *Node {
+	var arrays []array
+	for _, a := range e.arrays {
+		if a.elem == elem {
+			arrays = append(arrays, a)
+		}
+	}
+	if len(arrays) == 0 {
+		return g.leaf(e)
+	}
+	a := arrays[g.rand.Intn(len(arrays))]
+	return &Node{Kind: "index", Text: a.name, Type: elem, Kids: []*Node{g.index(a)}}
+}
+
+// index gives an index in the bounds of the array, a literal or its last
+// by its length.
+func (g *Generator) index(a array) *Node {
+	if g.rand.Intn(2) == 0 {
+		length := &Node{Kind: "call", Text: "len", Type: "int", Kids: []*Node{{Kind: "name", Text: a.name, Type: "[]" + a.elem}}}
+		return &Node{Kind: "op", Text: "-", Type: "int", Kids: []*Node{length, {Kind: "int", Text: "1", Type: "int"}}}
+	}
+	return &Node{Kind: "int", Text: fmt.Sprint(g.rand.Intn(a.length)), Type: "int"}
+}
+
+func (g *Generator) call(e *env, depth int) *Node {
+	names := make([]string, 0, len(e.funcs))
+	for name := range e.funcs {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return g.leaf(e)
+	}
+	// in order so the seed alone decides the program
+	sort.Strings(names)
+	name := names[g.rand.Intn(len(names))]
+	call := &Node{Kind: "call", Text: name, Type: "int"}
+	for i := 0; i < e.funcs[name]; i++ {
+		call.Kids = append(call.Kids, reduce(g.expr(e, depth-1)))
+	}
+	return call
+}
+
+func (g *Generator) condition(e *env, depth int) *Node {
+	if depth > 0 {
+		switch g.rand.Intn(5) {
+		case 0:
+			op := []string{"&&", "||"}[g.rand.Intn(2)]
+			return &Node{Kind: "op", Text: op, Type: "boolean", Kids: []*Node{g.condition(e, depth-1), g.condition(e, depth-1)}}
+		case 1:
+			return &Node{Kind: "not", Type: "boolean", Kids: []*Node{g.condition(e, depth-1)}}
+		}
+	}
+	op := []string{"<", "<=", "==", "!=", ">=", ">"}[g.rand.Intn(6)]
+	return &Node{Kind: "op", Text: op, Type: "boolean", Kids: []*Node{g.expr(e, depth-1), g.expr(e, depth-1)}}
+}
+
+func (g *Generator) leaf(e *env) *Node {
+	if len(e.ints) > 0 && g.rand.Intn(2) == 0 {
+		return &Node{Kind: "name", Text: e.ints[g.rand.Intn(len(e.ints))], Type: "int"}
+	}
+	return g.literal()
+}
+
+func (g *Generator) literal() *Node {
+	return &Node{Kind: "int", Text: fmt.Sprint(g.rand.Intn(10)), Type: "int"}
+}
+
+// convert converts the expression to typ, int, float or string.
+func convert(typ string, n *Node) *Node {
+	return &Node{Kind: "convert", Text: typ, Type: typ, Kids: []*Node{n}}
+}
+
+// reduce takes the int modulo 1000.
+func reduce(n *Node) *Node {
+	return &Node{Kind: "op", Text: "%", Type: "int", Kids: []*Node{n, {Kind: "int", Text: "1000", Type: "int"}}}
+}
+
+// reduceFloat takes the whole float modulo 1000.
+func reduceFloat(n *Node) *Node {
+	return convert("float", reduce(convert("int", n)))
+}
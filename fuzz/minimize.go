@@ -0,0 +1,88 @@
+package fuzz
+
+// Minimize shrinks the program while it still fails, giving the smallest
+// program found. The program itself is left as it is. Statements are
+// removed and expressions are replaced with literals, or floats made of
+// them, or with their own expressions of the same type, one at a time,
+// until none of those which make the program shorter fail.
+func Minimize(program *Node, fails func(*Node) bool) *Node {
+	p := program.Copy()
+	for shrunk := true; shrunk; {
+		shrunk = false
+		size := len(p.String())
+		for _, c := range candidates(p) {
+			undo := c()
+			if len(p.String()) < size && fails(p) {
+				shrunk = true
+				break
+			}
+			undo()
+		}
+	}
+	return p
+}
+
+// candidate makes a change to the program giving the function undoing it.
+type candidate func() (undo func())
+
+func candidates(n *Node) []candidate {
+	var cs []candidate
+	for i, kid := range n.Kids {
+		i, kid := i, kid
+		if n.Kind == "block" && len(n.Kids) > 1 && (n.Type == "" || i < len(n.Kids)-1) {
+			// the last statement of a function or branch is its value
+			cs = append(cs, func() func() {
+				kids := n.Kids
+				n.Kids = append(append([]*Node(nil), kids[:i]...), kids[i+1:]...)
+				return func() { n.Kids = kids }
+			})
+		}
+		for _, r := range replacements(kid) {
+			r := r
+			cs = append(cs, func() func() {
+				n.Kids[i] = r
+				return func() { n.Kids[i] = kid }
+			})
+		}
+	}
+	for _, kid := range n.Kids {
+		cs = append(cs, candidates(kid)...)
+	}
+	return cs
+}
+
+// replacements are the smaller expressions an expression may be replaced
+// with.
+func replacements(n *Node) []*Node {
+	var rs []*Node
+	if n.Kind == "block" {
+		return nil
+	}
+	switch n.Type {
+	case "int":
+		if n.Kind != "int" {
+			rs = append(rs, &Node{Kind: "int", Text: "0", Type: "int"}, &Node{Kind: "int", Text: "1", Type: "int"})
+		}
+	case "float":
+		if n.Kind != "convert" || n.Kids[0].Kind != "int" {
+			rs = append(rs, convert("float", &Node{Kind: "int", Text: "0", Type: "int"}))
+		}
+	case "boolean":
+		rs = append(rs,
+			&Node{Kind: "op", Text: "==", Type: "boolean", Kids: []*Node{{Kind: "int", Text: "0", Type: "int"}, {Kind: "int", Text: "0", Type: "int"}}},
+			&Node{Kind: "op", Text: "!=", Type: "boolean", Kids: []*Node{{Kind: "int", Text: "0", Type: "int"}, {Kind: "int", Text: "0", Type: "int"}}},
+		)
+	default:
+		return nil
+	}
+	for _, kid := range n.Kids {
+		if kid.Type == n.Type && kid.Kind != "block" {
+			rs = append(rs, kid)
+		}
+		if n.Kind == "if" && kid.Kind == "block" && len(kid.Kids) == 1 {
+			// a branch of nothing but its value
+			rs = append(rs, kid.Kids[0])
+		}
+	}
+	return rs
+}
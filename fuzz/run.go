@@ -0,0 +1,138 @@
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+import (
+	"github.com/timtadh/tcel/builtins"
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/il"
+	"github.com/timtadh/tcel/x86"
+)
+
+// Check lexes, parses and type checks the source.
+func Check(src string) (*frontend.Node, error) {
+	tokens, err := frontend.Lex(src, "fuzz.x")
+	if err != nil {
+		return nil, err
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := checker.Check(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Evaluate runs the source with the evaluator giving what it printed.
+func Evaluate(src string) (string, error) {
+	node, err := Check(src)
+	if err != nil {
+		return "", err
+	}
+	out := new(bytes.Buffer)
+	stdout := builtins.Stdout
+	builtins.Stdout = out
+	defer func() { builtins.Stdout = stdout }()
+	if _, err := evaluator.Evaluate(node); err != nil {
+		return out.String(), err
+	}
+	return out.String(), nil
+}
+
+// Assemble compiles the source to x86 assembly.
+func Assemble(src string) (string, error) {
+	node, err := Check(src)
+	if err != nil {
+		return "", err
+	}
+	fns, err := il.Generate(node)
+	if err != nil {
+		return "", err
+	}
+	return x86.Generate(fns)
+}
+
+// GCC gives the path of a gcc which links 32 bit programs or "" if there is
+// none.
+func GCC() string {
+	path, err := exec.LookPath("gcc")
+	if err != nil {
+		return ""
+	}
+	dir, err := ioutil.TempDir("", "tcel-fuzz")
+	if err != nil {
+		return ""
+	}
+	defer os.RemoveAll(dir)
+	c := filepath.Join(dir, "main.c")
+	if err := ioutil.WriteFile(c, []byte("int main() { return 0; }\n"), 0644); err != nil {
+		return ""
+	}
+	if err := exec.Command(path, "-m32", "-o", filepath.Join(dir, "main"), c).Run(); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Native compiles the source, links it with the runtime using gcc and runs
+// it giving what it printed.
+func Native(gcc, src string) (string, error) {
+	asm, err := Assemble(src)
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "tcel-fuzz")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	s := filepath.Join(dir, "a.s")
+	lib := filepath.Join(dir, "lib.c")
+	bin := filepath.Join(dir, "a.out")
+	if err := ioutil.WriteFile(s, []byte(asm), 0644); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(lib, []byte(x86.Lib), 0644); err != nil {
+		return "", err
+	}
+	if out, err := exec.Command(gcc, "-m32", "-o", bin, lib, s, "-lm").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, out)
+	}
+	out, err := exec.Command(bin).Output()
+	if err != nil {
+		return string(out), fmt.Errorf("%v", err)
+	}
+	return string(out), nil
+}
+
+// Differ runs the source with the evaluator and compiled with gcc and tells
+// how they differ, if they do. Source which does not type check does not
+// differ.
+func Differ(gcc, src string) (string, bool) {
+	if _, err := Check(src); err != nil {
+		return "", false
+	}
+	evaluated, eerr := Evaluate(src)
+	compiled, cerr := Native(gcc, src)
+	if eerr != nil || cerr != nil {
+		if (eerr == nil) == (cerr == nil) && evaluated == compiled {
+			return "", false
+		}
+		return fmt.Sprintf("the evaluator gave %q, %v\nthe compiled program gave %q, %v", evaluated, eerr, compiled, cerr), true
+	}
+	if evaluated != compiled {
+		return fmt.Sprintf("the evaluator printed\n%v\nthe compiled program printed\n%v", evaluated, compiled), true
+	}
+	return "", false
+}
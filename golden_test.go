@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/builtins"
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/il"
+	"github.com/timtadh/tcel/loader"
+	"github.com/timtadh/tcel/x86"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// unkept names the stages of a program which have no golden files. long is
+// there to time the parser, its tokens and trees run to tens of thousands of
+// lines so only what it evaluates to and its IL are kept.
+var unkept = map[string]map[string]bool{
+	"long": {"lex": true, "ast": true, "typed": true},
+}
+
+// TestGolden runs each program in ex through every stage comparing what
+// each gives to testdata/<program>.<stage>. A stage which fails gives its
+// error and the stages which need it are not run. A program reads
+// testdata/<program>.stdin if there is one. Each program is compiled and
+// where gcc links 32 bit programs what it prints is compared to what the
+// evaluator does.
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("ex", "*.x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("expected programs in ex")
+	}
+	native := gcc(t)
+	for _, path := range paths {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".x")
+		t.Run(name, func(t *testing.T) {
+			stdin, err := ioutil.ReadFile(filepath.Join("testdata", name+".stdin"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			g := &golden{t: t, name: name, unkept: unkept[name]}
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := frontend.Lex(string(src), path)
+			if !g.check("lex", tokenLines(tokens), err) {
+				return
+			}
+			node, err := loader.Load(path)
+			if !g.check("ast", serialized(node, false), err) {
+				return
+			}
+			err = checker.Check(node)
+			if !g.check("typed", serialized(node, true), err) {
+				return
+			}
+			output, values, err := evaluate(path, stdin)
+			ran := g.check("eval", evaluated(output, values), err)
+			fns, err := il.Generate(node)
+			if !g.check("il", stringer(fns), err) {
+				return
+			}
+			// the assembly is generated even where it cannot be linked so
+			// the back end is run on every program
+			asm, err := x86.Generate(fns)
+			if err != nil {
+				t.Logf("not comparing the compiled program: %v", err)
+				return
+			}
+			if native == "" || !ran {
+				return
+			}
+			compiled, err := compile(native, asm, stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compiled != output {
+				t.Errorf("the compiled program printed\n%v\nbut the evaluator printed\n%v", compiled, output)
+			}
+		})
+	}
+}
+
+type golden struct {
+	t      *testing.T
+	name   string
+	unkept map[string]bool
+}
+
+// check compares what a stage gave, or its error, to the golden file of the
+// stage. It reports whether the stage succeeded.
+func (g *golden) check(stage string, got func() string, err error) bool {
+	if g.unkept[stage] {
+		if err != nil {
+			g.t.Errorf("%v failed: %v", stage, err)
+		}
+		return err == nil
+	}
+	text := ""
+	if err != nil {
+		text = fmt.Sprintf("error: %v\n", err)
+	} else {
+		text = got()
+	}
+	path := filepath.Join("testdata", g.name+"."+stage)
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			g.t.Fatal(err)
+		}
+		return err == nil
+	}
+	expected, rerr := ioutil.ReadFile(path)
+	if rerr != nil {
+		g.t.Fatalf("%v, run go test -update to write it", rerr)
+	}
+	if text != string(expected) {
+		g.t.Errorf("%v differs from %v, run go test -update if it should\n%v", stage, path, text)
+	}
+	return err == nil
+}
+
+func tokenLines(tokens []*frontend.Token) func() string {
+	return func() string {
+		lines := make([]string, 0, len(tokens))
+		for _, tk := range tokens {
+			lines = append(lines, tk.String())
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+}
+
+func serialized(node *frontend.Node, typed bool) func() string {
+	return func() string {
+		return node.Serialize(typed) + "\n"
+	}
+}
+
+func stringer(s fmt.Stringer) func() string {
+	return func() string {
+		return s.String()
+	}
+}
+
+func evaluated(output string, values []interface{}) func() string {
+	return func() string {
+		lines := []string{output + "values:"}
+		for _, v := range values {
+			lines = append(lines, fmt.Sprint(v))
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+}
+
+// evaluate loads and checks the program at path anew, so it shares no tree
+// with the IL, then runs it giving what it printed and its values.
+func evaluate(path string, stdin []byte) (string, []interface{}, error) {
+	node, err := loader.Load(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checker.Check(node); err != nil {
+		return "", nil, err
+	}
+	out := new(bytes.Buffer)
+	in, printed := builtins.Stdin, builtins.Stdout
+	defer func() {
+		builtins.Stdin, builtins.Stdout = in, printed
+	}()
+	builtins.Stdin = bytes.NewReader(stdin)
+	builtins.Stdout = out
+	values, err := evaluator.Evaluate(node)
+	return out.String(), values, err
+}
+
+// gcc gives the path of a gcc which links 32 bit programs or "" if there is
+// none.
+func gcc(t *testing.T) string {
+	path, err := exec.LookPath("gcc")
+	if err != nil {
+		return ""
+	}
+	dir, err := ioutil.TempDir("", "tcel-golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := filepath.Join(dir, "main.c")
+	if err := ioutil.WriteFile(c, []byte("int main() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command(path, "-m32", "-o", filepath.Join(dir, "main"), c).Run(); err != nil {
+		t.Log("not comparing compiled programs, gcc cannot link 32 bit programs")
+		return ""
+	}
+	return path
+}
+
+// compile assembles and links the program with the runtime then runs it
+// giving what it printed.
+func compile(gcc string, asm string, stdin []byte) (string, error) {
+	dir, err := ioutil.TempDir("", "tcel-golden")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	s := filepath.Join(dir, "a.s")
+	lib := filepath.Join(dir, "lib.c")
+	bin := filepath.Join(dir, "a.out")
+	if err := ioutil.WriteFile(s, []byte(asm), 0644); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(lib, []byte(x86.Lib), 0644); err != nil {
+		return "", err
+	}
+	if out, err := exec.Command(gcc, "-m32", "-o", bin, lib, s, "-lm").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, out)
+	}
+	run := exec.Command(bin)
+	run.Stdin = bytes.NewReader(stdin)
+	out, err := run.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, out)
+	}
+	return string(out), nil
+}
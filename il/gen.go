@@ -5,6 +5,7 @@ import (
 )
 
 import (
+	"github.com/timtadh/tcel/builtins"
 	"github.com/timtadh/tcel/checker"
 	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/table"
@@ -32,6 +33,9 @@ type ilGen struct {
 	types  *table.SymbolTable
 	funcs Functions
 	fn *Func
+	subst map[*types.TypeVar]types.Type
+	modules map[string]map[string]interface{} // the operands of each module by path
+	loc *frontend.SourceLocation // of the node instructions are generated for
 }
 
 func newIlGen() *ilGen {
@@ -40,6 +44,7 @@ func newIlGen() *ilGen {
 		funcs: funcs,
 		syms:  table.NewSymbolTable(),
 		types: table.NewSymbolTable(),
+		modules: make(map[string]map[string]interface{}),
 	}
 	for _, p := range types.Primatives {
 		g.types.Put(string(p), p)
@@ -51,27 +56,12 @@ func newIlGen() *ilGen {
 			Returns: types.Unit,
 		},
 	)
-	g.syms.Put("print_int", &Operand{
-		Type: &types.Function{
-			Parameters: []types.Type{ types.Type(types.Int) },
-			Returns: types.Unit,
-		},
-		Value: &NativeTarget{"print_int"},
-	})
-	g.syms.Put("read_stdin_int", &Operand{
-		Type: &types.Function{
-			Parameters: []types.Type{ types.Type(types.String) },
-			Returns: types.Int,
-		},
-		Value: &NativeTarget{"read_stdin_int"},
-	})
-	g.syms.Put("print", &Operand{
-		Type: &types.Function{
-			Parameters: []types.Type{ types.Type(types.String) },
-			Returns: types.Unit,
-		},
-		Value: &NativeTarget{"print"},
-	})
+	for _, b := range builtins.Builtins {
+		g.syms.Put(b.Name, &Operand{
+			Type: b.Type,
+			Value: &NativeTarget{b.Native},
+		})
+	}
 	return g
 }
 
@@ -112,6 +102,19 @@ func (g *ilGen) Pop() {
 	}
 }
 
+// locate makes node the source of the instructions generated until the
+// function it gives is called. A node the checker made has no location of
+// its own, its instructions keep the location they had.
+func (g *ilGen) locate(node *frontend.Node) func() {
+	old := g.loc
+	if loc := node.Location(); loc != nil {
+		g.loc = loc
+	}
+	return func() {
+		g.loc = old
+	}
+}
+
 func (g *ilGen) CONST(v interface{}, t types.Type) *Operand {
 	return &Operand{Type: t, Value: &Constant{v}}
 }
@@ -132,21 +135,132 @@ func (g *ilGen) Stmts(node *frontend.Node, rslt *Operand, blk *Block) (*Operand,
 }
 
 func (g *ilGen) Stmt(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	defer g.locate(node)()
 	switch node.Label {
 	case "Assign":
 		return g.Assign(node, rslt, blk)
+	case "TypeAlias", "TypeDecl":
+		return g.TypeDecl(node, blk)
+	case "Module":
+		return g.Module(node, rslt, blk)
+	case "ModuleDecl", "Import":
+		return &UNIT, blk
 	default:
 		return g.Expr(node, rslt, blk)
 	}
 }
 
+// Module generates the statements of a module in a scope of their own
+// keeping its operands for the modules which import it.
+func (g *ilGen) Module(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	g.Push()
+	defer g.Pop()
+	rslt, blk = g.Stmts(node.Get(1), rslt, blk)
+	g.modules[node.Value.(string)] = g.syms.Top()
+	return rslt, blk
+}
+
+// TypeDecl defines the constructors of a union. A variant with fields is
+// made by calling a function of them, one without is made once here.
+func (g *ilGen) TypeDecl(node *frontend.Node, blk *Block) (*Operand, *Block) {
+	if node.Get(1).Label != "Union" {
+		return &UNIT, blk
+	}
+	union := types.Underlying(node.Get(0).Type).(*types.Union)
+	for tag, v := range union.Variants {
+		t := g.typeof(node.Get(1).Get(tag))
+		reg := g.Register(t)
+		if len(v.Fields) == 0 {
+			g.variant(tag, nil, reg, blk)
+		} else {
+			f := g.constructor(tag, t.(*types.Function))
+			blk.Add(NewInst(Ops["IMM"], Call(f), &UNIT, reg))
+		}
+		g.syms.Put(v.Name, reg)
+	}
+	return &UNIT, blk
+}
+
+// constructor makes the function which constructs the variant with the
+// given tag from its parameters.
+func (g *ilGen) constructor(tag int, t *types.Function) *Func {
+	f := g.NewFunc(t)
+	fblk := f.Entry()
+	old_fn := g.fn
+	g.fn = f
+
+	defer func() {
+		g.fn = old_fn
+	}()
+
+	fields := make([]*Operand, 0, len(t.Parameters))
+	for i, p := range t.Parameters {
+		reg := g.Register(p)
+		fblk.Add(NewInst(Ops["PRM"], Const(i), &UNIT, reg))
+		fields = append(fields, reg)
+	}
+	rslt := g.Register(t.Returns)
+	g.variant(tag, fields, rslt, fblk)
+	fblk.Add(NewInst(Ops["RTRN"], rslt, &UNIT, &UNIT))
+	return f
+}
+
+// A variant is laid out like a record with its tag before its fields: its
+// size in bytes in the first word, the tag, then a word for each field.
+func (g *ilGen) variant(tag int, fields []*Operand, rslt *Operand, blk *Block) {
+	size := Const(4*len(fields) + 8)
+	blk.Add(NewInst(Ops["NEW"], size, Pointers(append([]bool{false}, pointers(fields)...), false), rslt))
+	blk.Add(NewInst(Ops["PUT"], size, OffLen(0, 4), rslt))
+	blk.Add(NewInst(Ops["PUT"], Const(tag), OffLen(4, 4), rslt))
+	for i, f := range fields {
+		blk.Add(NewInst(Ops["PUT"], f, OffLen(4*i + 8, 4), rslt))
+	}
+}
+
 func (g *ilGen) Assign(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
 	if rslt != nil {
 		panic(fmt.Errorf("cannot propogate the result of an assign"))
 	}
+	if node.Get(0).Label == "Targets" {
+		return g.unpack(node.Get(0), node.Get(1), blk)
+	}
 	return g.assign(node.Get(0), node.Get(1), blk)
 }
 
+// unpack gets each element of the tuple into its target.
+func (g *ilGen) unpack(targets, expr *frontend.Node, blk *Block) (*Operand, *Block) {
+	tuple, blk := g.Expr(expr, nil, blk)
+	for i, target := range targets.Children {
+		elem := OffLen(4*i + 4, 4)
+		if target.Label == "NAME" {
+			name := g.NAME(target)
+			var symbol *Operand
+			if sym := g.syms.Get(name); sym != nil {
+				symbol = sym.(*Operand)
+			} else {
+				symbol = g.Register(g.typeof(target))
+			}
+			blk.Add(NewInst(Ops["GET"], tuple, elem, symbol))
+			g.syms.Put(name, symbol)
+		} else if target.Label == "Field" {
+			var record *Operand
+			value := g.Register(g.typeof(target))
+			blk.Add(NewInst(Ops["GET"], tuple, elem, value))
+			record, blk = g.Expr(target.Get(0), nil, blk)
+			blk.Add(NewInst(Ops["PUT"], value, g.field(target), record))
+		} else if target.Label == "Index" {
+			var array, offset *Operand
+			value := g.Register(g.typeof(target))
+			blk.Add(NewInst(Ops["GET"], tuple, elem, value))
+			array, offset, blk = g.element(target, blk)
+			blk.Add(NewInst(Ops["PUT"], value, offset, array))
+		} else {
+			panic(fmt.Errorf("cannot unpack into %v", target.Serialize(true)))
+		}
+	}
+	return &UNIT, blk
+}
+
 func (g *ilGen) assign(node, expr *frontend.Node, blk *Block) (*Operand, *Block) {
 	if node.Label == "NAME" {
 		name := g.NAME(node)
@@ -165,22 +279,22 @@ func (g *ilGen) assign(node, expr *frontend.Node, blk *Block) (*Operand, *Block)
 		// size := g.primative_size(sym)
 		// blk.Add(NewInst(Ops["PUT"], expr, OffLen(0, size), sym))
 		// return &UNIT, blk
-	} else {
-		panic(fmt.Errorf("Array assignments unimplemented"))
-	}
-}
-
-/*
-func (g *ilGen) assignee(node *frontend.Node) (value []interface{}, i int64) {
-	if node.Label == "Index" {
-		item := g.Expr(node.Get(0)).([]interface{})
-		spot := g.Expr(node.Get(1)).(int64)
-		return item, spot
+	} else if node.Label == "Field" {
+		var value, record *Operand
+		value, blk = g.Expr(expr, nil, blk)
+		record, blk = g.Expr(node.Get(0), nil, blk)
+		blk.Add(NewInst(Ops["PUT"], value, g.field(node), record))
+		return &UNIT, blk
+	} else if node.Label == "Index" {
+		var value, array, offset *Operand
+		value, blk = g.Expr(expr, nil, blk)
+		array, offset, blk = g.element(node, blk)
+		blk.Add(NewInst(Ops["PUT"], value, offset, array))
+		return &UNIT, blk
 	} else {
 		panic(fmt.Errorf("Unxpected node %v", node))
 	}
 }
-*/
 
 func (g *ilGen) NAME(node *frontend.Node) (string) {
 	if node.Label != "NAME" {
@@ -190,6 +304,7 @@ func (g *ilGen) NAME(node *frontend.Node) (string) {
 }
 
 func (g *ilGen) Expr(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	defer g.locate(node)()
 	switch node.Label {
 	case "+", "-", "*", "/", "%":
 		return g.ArithOp(node, rslt, blk)
@@ -205,10 +320,22 @@ func (g *ilGen) Expr(node *frontend.Node, rslt *Operand, blk *Block) (*Operand,
 		return g.Function(node, rslt, blk)
 	case "Call":
 		return g.Call(node, rslt, blk)
-	// case "Index":
-		// return g.Index(node)
+	case "Conversion":
+		return g.Conversion(node, rslt, blk)
+	case "Index":
+		return g.Index(node, rslt, blk)
 	case "NEW":
 		return g.New(node, rslt, blk)
+	case "Record":
+		return g.Record(node, rslt, blk)
+	case "Tuple":
+		return g.Tuple(node, rslt, blk)
+	case "Field":
+		return g.Field(node, rslt, blk)
+	case "Match":
+		return g.Match(node, rslt, blk)
+	case "Instantiate":
+		return g.Instantiate(node, rslt, blk)
 	default:
 		panic(fmt.Errorf("unexpected node %v", node))
 	}
@@ -227,7 +354,7 @@ func (g *ilGen) ArithOp(node *frontend.Node, rslt *Operand, blk *Block) (*Operan
 	default: panic(fmt.Errorf("Unexpected node %v", node.Label))
 	}
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 	blk.Add(NewInst(op, a, b, rslt))
 	return rslt, blk
@@ -236,7 +363,7 @@ func (g *ilGen) ArithOp(node *frontend.Node, rslt *Operand, blk *Block) (*Operan
 func (g *ilGen) UnaryOp(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
 	a, blk := g.Expr(node.Get(0), nil, blk)
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 	if node.Label == "Negate" {
 		t := node.Get(0).Type
@@ -254,7 +381,7 @@ func (g *ilGen) UnaryOp(node *frontend.Node, rslt *Operand, blk *Block) (*Operan
 
 
 func (g *ilGen) Constant(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
-	c := g.CONST(node.Value, node.Type)
+	c := g.CONST(node.Value, g.typeof(node))
 	if rslt == nil {
 		return c, blk
 	}
@@ -267,6 +394,16 @@ func (g *ilGen) Symbol(node *frontend.Node, rslt *Operand, blk *Block) (*Operand
 		panic(fmt.Errorf("Unknown name, %v", node.Serialize(true)))
 	} else {
 		o := sym.(*Operand)
+		if _, is := o.Value.(*Template); is && !types.Generic(g.typeof(node)) {
+			// self in a function generalized by the checker is used at the
+			// type of the instance being generated
+			f := g.instance(o, g.typeof(node).(*types.Function))
+			if rslt == nil {
+				rslt = g.Register(f.Type)
+			}
+			blk.Add(NewInst(Ops["IMM"], Call(f), &UNIT, rslt))
+			return rslt, blk
+		}
 		if rslt == nil {
 			return o, blk
 		}
@@ -287,7 +424,7 @@ func (g *ilGen) If(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *B
 	blk = g.BooleanExpr(condition, blk, then_blk, else_blk)
 
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 
 	g.Push()
@@ -309,7 +446,50 @@ func (g *ilGen) If(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *B
 	return rslt, final_blk
 }
 
+// Match gets the tag of the variant and tests it against each arm in turn.
+// The last arm needs no test as the match is exhaustive.
+func (g *ilGen) Match(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	union := types.Underlying(node.Get(0).Type).(*types.Union)
+	value, blk := g.Expr(node.Get(0), nil, blk)
+	tag := g.Register(types.Int)
+	blk.Add(NewInst(Ops["GET"], value, OffLen(4, 4), tag))
+
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+
+	final_blk := g.fn.AddNewBlock()
+	arms := node.Children[1:]
+	for i, arm := range arms {
+		pattern := arm.Get(0)
+		t, v := union.Lookup(g.NAME(pattern.Get(0)))
+		arm_blk := g.fn.AddNewBlock()
+		if i + 1 < len(arms) {
+			next_blk := g.fn.AddNewBlock()
+			blk.Link(arm_blk)
+			blk.Add(NewInst(Ops["IFEQ"], tag, Const(t), Jump(arm_blk)))
+			blk.J(next_blk)
+			blk = next_blk
+		} else {
+			blk.J(arm_blk)
+		}
+
+		g.Push()
+		for j, b := range pattern.Get(1).Children {
+			reg := g.Register(v.Fields[j])
+			arm_blk.Add(NewInst(Ops["GET"], value, OffLen(4*j + 8, 4), reg))
+			g.syms.Put(g.NAME(b), reg)
+		}
+		_, arm_blk = g.Expr(arm.Get(1), rslt, arm_blk)
+		arm_blk.J(final_blk)
+		g.Pop()
+	}
+
+	return rslt, final_blk
+}
+
 func (g *ilGen) BooleanExpr(node *frontend.Node, blk, then, otherwise *Block) (*Block) {
+	defer g.locate(node)()
 	switch node.Label {
 	case "TRUE":
 		blk.J(then)
@@ -338,7 +518,7 @@ func (g *ilGen) CmpOp(node *frontend.Node, blk, then, otherwise *Block) (*Block)
 	case "<": op = Ops["IFLT"]
 	case "<=": op = Ops["IFLE"]
 	case "==": op = Ops["IFEQ"]
-	case "!=": op = Ops["IFNQ"]
+	case "!=": op = Ops["IFNE"]
 	case ">=": op = Ops["IFGE"]
 	case ">": op = Ops["IFGT"]
 	default: panic(fmt.Errorf("unexpected node %v", node))
@@ -365,16 +545,33 @@ func (g *ilGen) Not(node *frontend.Node, blk, then, otherwise *Block) (*Block) {
 }
 
 func (g *ilGen) Function(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
-	params := node.Get(0)
-	ret_type := node.Get(1)
-	block := node.Get(2)
+	if types.Generic(node.Type) {
+		// each instantiation is generated when it is used
+		tmpl := &Template{
+			node:  node,
+			fn:    g.fn,
+			syms:  g.syms.Capture(),
+			subst: g.subst,
+		}
+		return &Operand{Type: g.typeof(node), Value: tmpl}, blk
+	}
 
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 
-	f := g.NewFunc(node.Type.(*types.Function))
+	f := g.NewFunc(g.typeof(node).(*types.Function))
 	blk.Add(NewInst(Ops["IMM"], Call(f), &UNIT, rslt))
+	g.function(f, node, nil)
+	return rslt, blk
+}
+
+// function generates the body of f from the function literal node. self is
+// what the function calls itself, if nil it is f.
+func (g *ilGen) function(f *Func, node *frontend.Node, self *Operand) {
+	params := node.Get(0)
+	ret_type := node.Get(1)
+	block := node.Get(2)
 
 	g.Push()
 	defer g.Pop()
@@ -388,25 +585,140 @@ func (g *ilGen) Function(node *frontend.Node, rslt *Operand, blk *Block) (*Opera
 	}()
 
 	for i, kid := range params.Children {
-		t := kid.Type
+		t := g.typeof(kid)
 		name := g.NAME(kid.Get(0))
 		reg := g.Register(t)
 		fblk.Add(NewInst(Ops["PRM"], Const(i), &UNIT, reg))
 		g.syms.Put(name, reg)
 	}
 
+	if self == nil {
+		self = g.Register(f.Type)
+		fblk.Add(NewInst(Ops["IMM"], Call(f), &UNIT, self))
+	}
+	g.syms.Put("self", self)
+
 	ret, xblk := g.Stmts(block, nil, fblk)
 
 	// here we need to do escape analysis and make closures as appropriate
 
-	if !ret_type.Type.Equals(types.Unit) {
+	if !g.typeof(ret_type).Equals(types.Unit) {
 		xblk.Add(NewInst(Ops["RTRN"], ret, &UNIT, &UNIT))
 	}
 
-	if _, is := ret_type.Type.(*types.Function); is {
+	if _, is := types.Underlying(g.typeof(ret_type)).(*types.Function); is {
 		panic(fmt.Errorf("Doesn't yet support closures sorry!\n%v", node.Serialize(true)))
 	}
+}
+
+// Template is a generic function. It is not code itself, each of its
+// instantiations is generated as a function of its own.
+type Template struct {
+	node      *frontend.Node
+	fn        *Func // the function the template was defined in
+	syms      map[string]interface{}
+	subst     map[*types.TypeVar]types.Type
+	instances []*Func
+}
+
+func (self *Template) String() string {
+	return fmt.Sprintf("template %v", self.node.Type)
+}
+
+func (self *Template) Equals(v Value) bool {
+	o, is := v.(*Template)
+	return is && o == self
+}
+
+// Instantiate gives the function generated for an instantiation of a
+// generic function, generating it the first time it is used.
+func (g *ilGen) Instantiate(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	generic, blk := g.Expr(node.Get(0), nil, blk)
+	if _, is := generic.Value.(*NativeTarget); is {
+		// a generic builtin is the same code at every type
+		if rslt == nil {
+			return generic, blk
+		}
+		blk.Add(NewInst(Ops["MV"], generic, &UNIT, rslt))
+		return rslt, blk
+	} else if _, is := generic.Value.(*Template); !is {
+		panic(fmt.Errorf("expected a generic function %v", node.Serialize(true)))
+	}
+	f := g.instance(generic, g.typeof(node).(*types.Function))
+	if rslt == nil {
+		rslt = g.Register(f.Type)
+	}
+	blk.Add(NewInst(Ops["IMM"], Call(f), &UNIT, rslt))
+	return rslt, blk
+}
+
+// instance finds or generates the function for the generic function with
+// the type t. It is generated where the generic function was defined with
+// its type parameters bound to the types which give t.
+func (g *ilGen) instance(generic *Operand, t *types.Function) *Func {
+	tmpl := generic.Value.(*Template)
+	for _, f := range tmpl.instances {
+		if f.Type.Equals(t) {
+			return f
+		}
+	}
+
+	ft := types.Substitute(tmpl.node.Type, tmpl.subst).(*types.Function)
+	s := make(map[*types.TypeVar]types.Type, len(tmpl.subst) + len(ft.TypeParams))
+	for _, v := range ft.TypeParams {
+		s[v] = nil
+	}
+	pattern := &types.Function{Parameters: ft.Parameters, Returns: ft.Returns}
+	if !types.Unify(pattern, t, s) {
+		panic(fmt.Errorf("%v is not an instance of %v", t, ft))
+	}
+	for v, bound := range tmpl.subst {
+		s[v] = bound
+	}
 
+	old_fn, old_syms, old_subst := g.fn, g.syms, g.subst
+	g.fn, g.syms, g.subst = tmpl.fn, table.Copy(tmpl.syms), s
+	defer func() {
+		g.fn, g.syms, g.subst = old_fn, old_syms, old_subst
+	}()
+
+	defer g.locate(tmpl.node)()
+	f := g.NewFunc(t)
+	tmpl.instances = append(tmpl.instances, f)
+	g.function(f, tmpl.node, generic)
+	return f
+}
+
+// typeof is the type of node with the type parameters of the instance
+// being generated replaced.
+func (g *ilGen) typeof(node *frontend.Node) types.Type {
+	return types.Substitute(node.Type, g.subst)
+}
+
+// conversions are the opcodes which convert between primatives, by the
+// types converted from and to.
+var conversions = map[[2]types.Type]string{
+	{types.Int, types.Float}: "ITOF",
+	{types.Float, types.Int}: "FTOI",
+	{types.Int, types.String}: "ITOS",
+	{types.Float, types.String}: "FTOS",
+}
+
+// Conversion generates T(x). Conversions to a named type with the structure
+// of x leave the value as it is.
+func (g *ilGen) Conversion(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	param := node.Get(1).Get(0)
+	from := types.Underlying(g.typeof(param))
+	to := types.Underlying(g.typeof(node))
+	op, has := conversions[[2]types.Type{from, to}]
+	if !has {
+		return g.Expr(param, rslt, blk)
+	}
+	value, blk := g.Expr(param, nil, blk)
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+	blk.Add(NewInst(Ops[op], value, &UNIT, rslt))
 	return rslt, blk
 }
 
@@ -418,7 +730,7 @@ func (g *ilGen) Call(node *frontend.Node, rslt *Operand, blk *Block) (*Operand,
 	callee, blk := g.Expr(node.Get(0), nil, blk)
 
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 
 	blk.Add(NewInst(Ops["CALL"], callee, Params(params), rslt))
@@ -434,19 +746,109 @@ func (g *ilGen) Params(node *frontend.Node, blk *Block) (prms []*Operand, oblk *
 	return prms, blk
 }
 
+func (g *ilGen) Index(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	array, offset, blk := g.element(node, blk)
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+	blk.Add(NewInst(Ops["GET"], array, offset, rslt))
+	return rslt, blk
+}
+
+// element gives the array an Index node refers into and the offset of the
+// element in it. An array of arrays is one block, see New, so an element is
+// found from all of its indices at once. Each is checked against the length
+// of its dimension. A row of an array of arrays has no block of its own, so
+// indexing part of one is not implemented.
+func (g *ilGen) element(node *frontend.Node, blk *Block) (*Operand, *Operand, *Block) {
+	var indices []*frontend.Node
+	base := node
+	for base.Label == "Index" {
+		indices = append([]*frontend.Node{base}, indices...)
+		base = base.Get(0)
+	}
+	dims := 0
+	for t := types.Underlying(g.typeof(base)); ; dims++ {
+		a, is := t.(*types.Array)
+		if !is {
+			break
+		}
+		t = types.Underlying(a.Base)
+	}
+	if len(indices) != dims {
+		panic(fmt.Errorf("indexing part of a nested array not implemented %v", node.Serialize(true)))
+	}
+
+	array, blk := g.Expr(base, nil, blk)
+	offset := g.Register(types.Int)
+	for d, index := range indices {
+		var i *Operand
+		i, blk = g.Expr(index.Get(1), nil, blk)
+		length := g.Register(types.Int)
+		blk.Add(NewInst(Ops["GET"], array, OffLen(4*d + 4, 4), length))
+		blk = g.bounds(index, i, length, blk)
+		if d == 0 {
+			blk.Add(NewInst(Ops["MV"], i, &UNIT, offset))
+		} else {
+			blk.Add(NewInst(Ops["MUL"], offset, length, offset))
+			blk.Add(NewInst(Ops["ADD"], offset, i, offset))
+		}
+	}
+	blk.Add(NewInst(Ops["MUL"], offset, Const(4), offset))
+	blk.Add(NewInst(Ops["ADD"], offset, Const(4*dims + 4), offset))
+	return array, offset, blk
+}
+
+// bounds checks the index i is in [0, length). If it is not the runtime
+// stops the program with an error at node. It gives the block to carry on
+// in.
+func (g *ilGen) bounds(node *frontend.Node, i, length *Operand, blk *Block) *Block {
+	fail := g.fn.AddNewBlock()
+	ok := g.fn.AddNewBlock()
+	blk.Link(fail)
+	blk.Add(NewInst(Ops["IFLT"], i, Const(0), Jump(fail)))
+	blk.Add(NewInst(Ops["IFGE"], i, length, Jump(fail)))
+	blk.J(ok)
+	bounds_error := &Operand{
+		Type: &types.Function{
+			Parameters: []types.Type{types.String, types.Int, types.Int},
+			Returns: types.Unit,
+		},
+		Value: &NativeTarget{builtins.BoundsError},
+	}
+	at := Const(node.Location().String())
+	fail.Add(NewInst(Ops["CALL"], bounds_error, Params([]*Operand{at, i, length}), &UNIT))
+	return ok
+}
+
+// New allocates a box or an array. It is laid out as its size in bytes then,
+// for an array, the length of each of its dimensions, outermost first, and
+// then its elements a word each.
 func (g *ilGen) New(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
 	var size *Operand
 	var components []*Operand
 	size, components, blk = g.sizeof(node.Get(0), nil, blk)
 	if rslt == nil {
-		rslt = g.Register(node.Type)
+		rslt = g.Register(g.typeof(node))
 	}
 	if c, is := size.Value.(*Constant); is {
 		size = Const(c.Value.(int64) + int64(4*len(components)) + 4)
 	} else {
 		blk.Add(NewInst(Ops["ADD"], Const(4*len(components) + 4), size, size))
 	}
-	blk.Add(NewInst(Ops["NEW"], size, &UNIT, rslt))
+	// the lengths are not pointers, the elements are if what is boxed is
+	elem := types.Underlying(g.typeof(node))
+	for {
+		if a, is := elem.(*types.Array); is {
+			elem = types.Underlying(a.Base)
+		} else if b, is := elem.(*types.Box); is {
+			elem = types.Underlying(b.Boxed)
+		} else {
+			break
+		}
+	}
+	layout := Pointers(make([]bool, len(components)), Pointer(elem))
+	blk.Add(NewInst(Ops["NEW"], size, layout, rslt))
 	blk.Add(NewInst(Ops["PUT"], size, OffLen(0, 4), rslt))
 	for i := len(components) - 1; i >= 0; i-- {
 		c := components[i]
@@ -456,7 +858,7 @@ func (g *ilGen) New(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *
 }
 
 func (g *ilGen) sizeof(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, []*Operand, *Block) {
-	switch t := node.Type.(type) {
+	switch t := types.Underlying(g.typeof(node)).(type) {
 	case *types.Array:
 		boxsize, components, blk := g.sizeof(node.Get(0), nil, blk)
 		boxes, blk := g.Expr(node.Get(1), nil, blk)
@@ -484,3 +886,79 @@ func (g *ilGen) sizeof(node *frontend.Node, rslt *Operand, blk *Block) (*Operand
 	}
 }
 
+// A record is laid out like an array: its size in bytes in the first word
+// then a word for each field in the order of its type.
+func (g *ilGen) Record(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	fields := make([]*Operand, 0, len(node.Children))
+	for _, kid := range node.Children {
+		var f *Operand
+		f, blk = g.Expr(kid.Get(1), nil, blk)
+		fields = append(fields, f)
+	}
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+	size := Const(4*len(fields) + 4)
+	blk.Add(NewInst(Ops["NEW"], size, Pointers(pointers(fields), false), rslt))
+	blk.Add(NewInst(Ops["PUT"], size, OffLen(0, 4), rslt))
+	for i, f := range fields {
+		blk.Add(NewInst(Ops["PUT"], f, OffLen(4*i + 4, 4), rslt))
+	}
+	return rslt, blk
+}
+
+// pointers gives whether each of the operands is a pointer.
+func pointers(operands []*Operand) []bool {
+	ps := make([]bool, 0, len(operands))
+	for _, o := range operands {
+		ps = append(ps, Pointer(o.Type))
+	}
+	return ps
+}
+
+// A tuple is laid out like a record with its elements as the fields.
+func (g *ilGen) Tuple(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	elems := make([]*Operand, 0, len(node.Children))
+	for _, kid := range node.Children {
+		var e *Operand
+		e, blk = g.Expr(kid, nil, blk)
+		elems = append(elems, e)
+	}
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+	size := Const(4*len(elems) + 4)
+	blk.Add(NewInst(Ops["NEW"], size, Pointers(pointers(elems), false), rslt))
+	blk.Add(NewInst(Ops["PUT"], size, OffLen(0, 4), rslt))
+	for i, e := range elems {
+		blk.Add(NewInst(Ops["PUT"], e, OffLen(4*i + 4, 4), rslt))
+	}
+	return rslt, blk
+}
+
+func (g *ilGen) Field(node *frontend.Node, rslt *Operand, blk *Block) (*Operand, *Block) {
+	if mod, is := node.Get(0).Type.(*types.Module); is {
+		o := g.modules[mod.Path][g.NAME(node.Get(1))].(*Operand)
+		if rslt == nil {
+			return o, blk
+		}
+		blk.Add(NewInst(Ops["MV"], o, &UNIT, rslt))
+		return rslt, blk
+	}
+	record, blk := g.Expr(node.Get(0), nil, blk)
+	if rslt == nil {
+		rslt = g.Register(g.typeof(node))
+	}
+	blk.Add(NewInst(Ops["GET"], record, g.field(node), rslt))
+	return rslt, blk
+}
+
+// field is the offset and length of the field accessed by a Field node.
+func (g *ilGen) field(node *frontend.Node) *Operand {
+	record := types.Underlying(node.Get(0).Type).(*types.Record)
+	i, _ := record.Lookup(g.NAME(node.Get(1)))
+	if i < 0 {
+		panic(fmt.Errorf("%v has no field %v", record, node.Get(1).Serialize(true)))
+	}
+	return OffLen(4*i + 4, 4)
+}
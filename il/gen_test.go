@@ -0,0 +1,67 @@
+package il
+
+import (
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+func generate(t *testing.T, src string) Functions {
+	tokens, err := frontend.Lex(src, "test.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns, err := Generate(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fns
+}
+
+func TestComparisons(t *testing.T) {
+	for cmp, op := range map[string]string{
+		"<":  "IFLT",
+		"<=": "IFLE",
+		"==": "IFEQ",
+		"!=": "IFNE",
+		">=": "IFGE",
+		">":  "IFGT",
+	} {
+		il := generate(t, "f = fn(x int) int {\n\tif x "+cmp+" 1 {\n\t\t2\n\t} else {\n\t\t3\n\t}\n}\nf(1)\n").String()
+		if !strings.Contains(il, op+" ") {
+			t.Errorf("expected %v to branch with %v in\n%v", cmp, op, il)
+		}
+		if strings.Contains(il, "INVALID") {
+			t.Errorf("expected %v to give only valid instructions in\n%v", cmp, il)
+		}
+	}
+}
+
+func TestRows(t *testing.T) {
+	// the evaluator runs these but a row has no block of its own in the IL
+	for _, src := range []string{
+		"a = new [3][4]int\nb = a[1]\n",
+		"a = new [3][4]int\nlen(a[1])\n",
+		"a = new [3][4]int\na[1] = new [4]int\n",
+	} {
+		tokens, err := frontend.Lex(src, "test.x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := frontend.Parse(tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Generate(node)
+		if err == nil || !strings.Contains(err.Error(), "nested array not implemented") {
+			t.Errorf("expected indexing a row to be not implemented for\n%v got %v", src, err)
+		}
+	}
+}
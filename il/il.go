@@ -4,17 +4,30 @@ import (
 	"fmt"
 	"strings"
 	"reflect"
+	"sort"
 )
 
 import (
+	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/types"
 )
 
 type Functions map[string]*Func
 
+// Names gives the names of the functions in order.
+func (funcs Functions) Names() []string {
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (funcs Functions) String() string {
 	lines := make([]string, 0, 100)
-	for name, fn := range funcs {
+	for _, name := range funcs.Names() {
+		fn := funcs[name]
 		lines = append(lines, fmt.Sprintf("%v %v", name, fn.Type))
 		scope := make([]string, 0, len(fn.StaticScope))
 		for _, x := range fn.StaticScope {
@@ -105,6 +118,9 @@ func (self *Block) Add(i *Inst) {
 	if self.closed {
 		panic("adding instruction to closed block")
 	}
+	if i.Loc == nil && self.Fn != nil && self.Fn.g != nil {
+		i.Loc = self.Fn.g.loc
+	}
 	self.Insts = append(self.Insts, i)
 }
 
@@ -138,11 +154,14 @@ func (self *Block) String() string {
 	)
 }
 
+// Inst is an instruction. Loc is where in the source the code it was
+// generated for is.
 type Inst struct {
 	Op OpCode
 	A  *Operand
 	B  *Operand
 	R  *Operand
+	Loc *frontend.SourceLocation
 }
 
 type InstSlice []*Inst
@@ -199,10 +218,14 @@ var Ops = map[string]OpCode{
 	"IFLE":    17,
 	"IFGT":    18,
 	"IFGE":    19,
-	"NEW":     20, // takes size in bytes
-	"GET":     21, // takes a mem buf, (an offset, length pair), and a destination
-	"PUT":     22, // takes an operand, (an offset, length pair), and a mem buf
+	"NEW":     20, // takes size in bytes and the layout of the pointers
+	"GET":     21, // takes a mem buf, (an offset, length pair) or an offset to a word, and a destination
+	"PUT":     22, // takes an operand, (an offset, length pair) or an offset to a word, and a mem buf
 	"SIZE":    23, // takes a mem buf
+	"ITOF":    24, // converts an int to a float
+	"FTOI":    25, // converts a float to an int, truncating it
+	"ITOS":    26, // converts an int to a string
+	"FTOS":    27, // converts a float to a string
 }
 
 func init() {
@@ -425,3 +448,50 @@ func (self *OffsetLength) Equals(v Value) bool {
 	}
 	return false
 }
+
+// Layout tells the collector which words of an object allocated with NEW
+// point to other objects. Words has an entry for each word after the size
+// in the order they are laid out, the elements of an array follow them and
+// are all pointers or all not as Elems says.
+type Layout struct {
+	Words []bool
+	Elems bool
+}
+
+func Pointers(words []bool, elems bool) *Operand {
+	return &Operand{
+		Type:  types.Label,
+		Value: &Layout{Words: words, Elems: elems},
+	}
+}
+
+func (self *Layout) String() string {
+	word := func(pointer bool) string {
+		if pointer {
+			return "p"
+		}
+		return "w"
+	}
+	words := make([]string, 0, len(self.Words))
+	for _, p := range self.Words {
+		words = append(words, word(p))
+	}
+	return fmt.Sprintf("%v[%v]", strings.Join(words, ""), word(self.Elems))
+}
+
+func (self *Layout) Equals(v Value) bool {
+	if o, is := v.(*Layout); is {
+		return reflect.DeepEqual(self, o)
+	}
+	return false
+}
+
+// Pointer reports whether a value of the type is an object allocated with
+// NEW, which the collector traces.
+func Pointer(t types.Type) bool {
+	switch types.Underlying(t).(type) {
+	case *types.Array, *types.Box, *types.Record, types.Tuple, *types.Union:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Package loader finds the modules a program is made of. Each file is a
+// module with a namespace of its own. A module names the modules it uses
+// with `import "path"`, the path being relative to the importing file, and
+// may name itself with `module name` at its top. Otherwise it is named after
+// its file.
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+// Ext is the extension given to an imported path which has none.
+const Ext = ".x"
+
+// Loader loads modules and the modules they import. Parse gives the tree of
+// the file at a path, it is ParseFile if nil.
+type Loader struct {
+	Parse func(path string) (*frontend.Node, error)
+
+	modules map[string]*frontend.Node
+	loading []string // the paths being loaded, each importing the next
+	order   []*frontend.Node
+}
+
+// Load loads the modules at the paths with the default Loader.
+func Load(paths ...string) (*frontend.Node, error) {
+	return new(Loader).Load(paths...)
+}
+
+// Load gives the program made of the modules at the paths and the modules
+// they import. It is a Stmts node of Module nodes in an order where each
+// module comes after the modules it imports, so they can be checked and run
+// in that order. The Value of a Module is its path, its children the NAME it
+// is known by and its Stmts. The Value of each Import is the path of the
+// module it imports.
+func (self *Loader) Load(paths ...string) (*frontend.Node, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no modules to load")
+	}
+	self.modules = make(map[string]*frontend.Node)
+	self.loading = nil
+	self.order = nil
+	for _, path := range paths {
+		if _, err := self.load(filepath.Clean(path)); err != nil {
+			return nil, err
+		}
+	}
+	program := frontend.NewNode("Stmts")
+	for _, mod := range self.order {
+		program.AddKid(mod)
+	}
+	return program, nil
+}
+
+func (self *Loader) load(path string) (*frontend.Node, error) {
+	if mod, has := self.modules[path]; has {
+		return mod, nil
+	}
+	for i, p := range self.loading {
+		if p == path {
+			cycle := append(append([]string(nil), self.loading[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %v", strings.Join(cycle, " imports "))
+		}
+	}
+	self.loading = append(self.loading, path)
+	defer func() {
+		self.loading = self.loading[:len(self.loading)-1]
+	}()
+
+	stmts, err := self.parse(path)
+	if err != nil {
+		return nil, err
+	}
+	name := frontend.NewNode("NAME")
+	name.Value = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for i, stmt := range stmts.Children {
+		switch stmt.Label {
+		case "ModuleDecl":
+			if i != 0 {
+				return nil, fmt.Errorf("%v: the module must be declared before any other statement", stmt.Location())
+			}
+			name = stmt.Get(0)
+		case "Import":
+			imported := stmt.Get(0).Value.(string)
+			if !filepath.IsAbs(imported) {
+				imported = filepath.Join(filepath.Dir(path), imported)
+			}
+			if filepath.Ext(imported) == "" {
+				imported += Ext
+			}
+			if _, err := self.load(filepath.Clean(imported)); err != nil {
+				return nil, err
+			}
+			stmt.Value = filepath.Clean(imported)
+		}
+	}
+	mod := frontend.NewNode("Module").AddKid(name).AddKid(stmts)
+	mod.Value = path
+	self.modules[path] = mod
+	self.order = append(self.order, mod)
+	return mod, nil
+}
+
+func (self *Loader) parse(path string) (*frontend.Node, error) {
+	if self.Parse != nil {
+		return self.Parse(path)
+	}
+	return ParseFile(path)
+}
+
+// ParseFile reads, lexes and parses the file at path.
+func ParseFile(path string) (*frontend.Node, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := frontend.Lex(string(src), path)
+	if err != nil {
+		return nil, err
+	}
+	return frontend.Parse(tokens)
+}
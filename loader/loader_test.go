@@ -0,0 +1,116 @@
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/frontend"
+)
+
+// files writes the files to a new directory giving its path.
+func files(t *testing.T, srcs map[string]string) string {
+	dir, err := ioutil.TempDir("", "tcel-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range srcs {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func load(t *testing.T, srcs map[string]string, main string) (*frontend.Node, error) {
+	dir := files(t, srcs)
+	defer os.RemoveAll(dir)
+	return Load(filepath.Join(dir, main))
+}
+
+var geo = `module geo
+
+type Point record { x int, y int }
+
+square = fn(x int) int { x * x }
+Origin = Point(record { x: 0, y: 0 })
+Norm = fn(p Point) int { square(p.x) + square(p.y) }
+`
+
+func TestLoad(t *testing.T) {
+	program, err := load(t, map[string]string{
+		"lib/point.x": geo,
+		"main.x": `import "lib/point"
+square = 2
+f = fn(q geo.Point) int { geo.Norm(q) }
+f(geo.Origin) + f(geo.Point(record { x: 3, y: 4 })) + square
+`,
+	}, "main.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Children) != 2 {
+		t.Fatalf("expected 2 modules got %v", program.Serialize(false))
+	}
+	names := []string{program.Get(0).Get(0).Value.(string), program.Get(1).Get(0).Value.(string)}
+	if names[0] != "geo" || names[1] != "main" {
+		t.Errorf("expected geo before main got %v", names)
+	}
+	if err := checker.Check(program); err != nil {
+		t.Fatal(err)
+	}
+	values, err := evaluator.Evaluate(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(values[len(values)-1]); got != "27" {
+		t.Errorf("expected 27 got %v", got)
+	}
+}
+
+func TestImportCycle(t *testing.T) {
+	_, err := load(t, map[string]string{
+		"a.x": "import \"b\"\nx = 1\n",
+		"b.x": "import \"c.x\"\ny = 1\n",
+		"c.x": "import \"a\"\nz = 1\n",
+	}, "a.x")
+	if err == nil || !strings.Contains(err.Error(), "import cycle") {
+		t.Fatalf("expected an import cycle got %v", err)
+	}
+	if !strings.Contains(err.Error(), "a.x imports") || !strings.Contains(err.Error(), "c.x imports") {
+		t.Errorf("expected the cycle to be named got %v", err)
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	bad := []string{
+		"import \"geo\"\nx = geo.square(1)\n",
+		"import \"geo\"\nx = geo.Missing\n",
+		"import \"geo\"\ngeo.Origin = geo.Origin\n",
+		"import \"geo\"\nx = geo\n",
+		"import \"geo\"\nf = fn(p geo.Line) int { 1 }\n",
+		"import \"geo\"\nx = Origin\n",
+		"import \"geo\"\nimport \"geo\"\n",
+		"x = 1\nmodule late\n",
+	}
+	for _, src := range bad {
+		program, err := load(t, map[string]string{"geo.x": geo, "main.x": src}, "main.x")
+		if err == nil {
+			err = checker.Check(program)
+		}
+		if err == nil {
+			t.Errorf("expected an error for\n%v", src)
+		}
+	}
+}
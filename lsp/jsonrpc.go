@@ -0,0 +1,146 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes used by the server.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Request is an incoming request or notification. Notifications have no Id.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+func (self *Request) Notification() bool {
+	return len(self.Id) == 0
+}
+
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (self *ResponseError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %v", self.Code, self.Message)
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   *ResponseError  `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// Conn reads and writes Content-Length framed JSON-RPC messages, the base
+// protocol of the language server protocol.
+type Conn struct {
+	r    *bufio.Reader
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{r: bufio.NewReader(r), w: w}
+}
+
+// ReadMessage reads the body of the next framed message.
+func (self *Conn) ReadMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := self.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(parts[0]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", parts[1])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message had no Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(self.r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// WriteMessage marshals v and writes it as a framed message.
+func (self *Conn) WriteMessage(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, err := fmt.Fprintf(self.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = self.w.Write(body)
+	return err
+}
+
+func (self *Conn) Read() (*Request, error) {
+	body, err := self.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	req := new(Request)
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, &ResponseError{Code: ParseError, Message: err.Error()}
+	}
+	return req, nil
+}
+
+func (self *Conn) Reply(id json.RawMessage, result interface{}) error {
+	return self.WriteMessage(&response{JSONRPC: "2.0", Id: id, Result: result})
+}
+
+func (self *Conn) ReplyError(id json.RawMessage, err *ResponseError) error {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return self.WriteMessage(&errorResponse{JSONRPC: "2.0", Id: id, Error: err})
+}
+
+func (self *Conn) Notify(method string, params interface{}) error {
+	return self.WriteMessage(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
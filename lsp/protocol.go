@@ -0,0 +1,212 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+)
+
+// The subset of the language server protocol the server speaks. Lines and
+// characters are zero based; frontend.SourceLocation is one based with an
+// inclusive end column.
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// The encodings a Position's character may count in. UTF-16 code units are
+// the protocol's default, UTF-8 bytes are used when the client offers them.
+const (
+	UTF8  = "utf-8"
+	UTF16 = "utf-16"
+)
+
+// lines converts between the one based byte columns of a
+// frontend.SourceLocation and the characters of a Position in the text of
+// a file.
+type lines struct {
+	text     []string
+	encoding string
+}
+
+func newLines(text, encoding string) *lines {
+	return &lines{text: strings.Split(text, "\n"), encoding: encoding}
+}
+
+// character gives the character of the one based byte column of the one
+// based line.
+func (self *lines) character(line, col int) int {
+	if self.encoding == UTF8 || line < 1 || line > len(self.text) {
+		return col - 1
+	}
+	text := self.text[line-1]
+	if col-1 > len(text) {
+		return len(utf16.Encode([]rune(text))) + col - 1 - len(text)
+	}
+	return len(utf16.Encode([]rune(text[:col-1])))
+}
+
+// column gives the one based byte column of the character of the zero
+// based line. A character inside a rune gives the rune.
+func (self *lines) column(line, char int) int {
+	if self.encoding == UTF8 || line < 0 || line >= len(self.text) {
+		return char + 1
+	}
+	units := 0
+	for i, r := range self.text[line] {
+		units += len(utf16.Encode([]rune{r}))
+		if units > char {
+			return i + 1
+		}
+	}
+	return len(self.text[line]) + char - units + 1
+}
+
+func (self *lines) Range(loc *frontend.SourceLocation) Range {
+	if loc == nil {
+		return Range{}
+	}
+	return Range{
+		Start: Position{Line: loc.StartLine - 1, Character: self.character(loc.StartLine, loc.StartColumn)},
+		End:   Position{Line: loc.EndLine - 1, Character: self.character(loc.EndLine, loc.EndColumn+1)},
+	}
+}
+
+// Contains reports whether the zero based position falls in loc.
+func (self *lines) Contains(loc *frontend.SourceLocation, pos Position) bool {
+	if loc == nil {
+		return false
+	}
+	line, col := pos.Line+1, self.column(pos.Line, pos.Character)
+	if line < loc.StartLine || line > loc.EndLine {
+		return false
+	}
+	if line == loc.StartLine && col < loc.StartColumn {
+		return false
+	}
+	if line == loc.EndLine && col > loc.EndColumn {
+		return false
+	}
+	return true
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageId string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+const (
+	SymbolFunction = 12
+	SymbolVariable = 13
+)
+
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+type GeneralClientCapabilities struct {
+	PositionEncodings []string `json:"positionEncodings"`
+}
+
+type ClientCapabilities struct {
+	General GeneralClientCapabilities `json:"general"`
+}
+
+type InitializeParams struct {
+	Capabilities ClientCapabilities `json:"capabilities"`
+}
+
+type ServerCapabilities struct {
+	PositionEncoding       string `json:"positionEncoding"`
+	TextDocumentSync       int    `json:"textDocumentSync"`
+	HoverProvider          bool   `json:"hoverProvider"`
+	DefinitionProvider     bool   `json:"definitionProvider"`
+	DocumentSymbolProvider bool   `json:"documentSymbolProvider"`
+}
+
+type ServerInfo struct {
+	Name string `json:"name"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   ServerInfo         `json:"serverInfo"`
+}
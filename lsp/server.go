@@ -0,0 +1,474 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+)
+
+import (
+	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/loader"
+	"github.com/timtadh/tcel/types"
+)
+
+// Serve runs a language server over the given streams until the client
+// sends exit. It returns an error if the client exits without first asking
+// for a shutdown or the connection fails.
+func Serve(r io.Reader, w io.Writer) error {
+	s := NewServer(NewConn(r, w))
+	return s.Run()
+}
+
+type Server struct {
+	conn     *Conn
+	docs     map[string]*document
+	encoding string // of the characters of Positions
+	shutdown bool
+}
+
+func NewServer(conn *Conn) *Server {
+	return &Server{
+		conn:     conn,
+		docs:     make(map[string]*document),
+		encoding: UTF16,
+	}
+}
+
+func (s *Server) Run() error {
+	for {
+		req, err := s.conn.Read()
+		if rerr, is := err.(*ResponseError); is {
+			if err := s.conn.ReplyError(nil, rerr); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		var result interface{}
+		var rerr *ResponseError
+		if s.shutdown {
+			// only exit is answered once the server is shut down
+			rerr = &ResponseError{Code: InvalidRequest, Message: fmt.Sprintf("%v after shutdown", req.Method)}
+		} else {
+			result, rerr = s.handle(req)
+		}
+		if req.Notification() {
+			continue
+		}
+		if rerr != nil {
+			err = s.conn.ReplyError(req.Id, rerr)
+		} else {
+			err = s.conn.Reply(req.Id, result)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *Request) (interface{}, *ResponseError) {
+	switch req.Method {
+	case "initialize":
+		var params InitializeParams
+		if len(req.Params) > 0 {
+			if err := unmarshal(req.Params, &params); err != nil {
+				return nil, err
+			}
+		}
+		return s.Initialize(&params)
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.DidOpen(&params)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.DidChange(&params)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.DidClose(&params)
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.Hover(&params)
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.Definition(&params)
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.DocumentSymbols(&params)
+	}
+	return nil, &ResponseError{Code: MethodNotFound, Message: fmt.Sprintf("unknown method %v", req.Method)}
+}
+
+func unmarshal(params json.RawMessage, v interface{}) *ResponseError {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: InvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// Initialize picks UTF-8 for the characters of Positions if the client
+// offers it, which the lexer's columns count in, otherwise they are
+// converted to and from the default UTF-16.
+func (s *Server) Initialize(params *InitializeParams) (interface{}, *ResponseError) {
+	for _, encoding := range params.Capabilities.General.PositionEncodings {
+		if encoding == UTF8 {
+			s.encoding = UTF8
+		}
+	}
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			PositionEncoding:       s.encoding,
+			TextDocumentSync:       1, // full
+			HoverProvider:          true,
+			DefinitionProvider:     true,
+			DocumentSymbolProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "tcel"},
+	}, nil
+}
+
+func (s *Server) DidOpen(params *DidOpenTextDocumentParams) *ResponseError {
+	doc := newDocument(params.TextDocument.URI, params.TextDocument.Text, s.encoding)
+	s.docs[doc.uri] = doc
+	return s.publish(doc)
+}
+
+func (s *Server) DidChange(params *DidChangeTextDocumentParams) *ResponseError {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	if len(params.ContentChanges) == 0 {
+		return nil
+	}
+	doc = newDocument(doc.uri, params.ContentChanges[len(params.ContentChanges)-1].Text, s.encoding)
+	s.docs[doc.uri] = doc
+	return s.publish(doc)
+}
+
+func (s *Server) DidClose(params *DidCloseTextDocumentParams) *ResponseError {
+	delete(s.docs, params.TextDocument.URI)
+	err := s.conn.Notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         params.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+	if err != nil {
+		return &ResponseError{Code: InternalError, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) publish(doc *document) *ResponseError {
+	err := s.conn.Notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         doc.uri,
+		Diagnostics: doc.diagnostics,
+	})
+	if err != nil {
+		return &ResponseError{Code: InternalError, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) document(uri string) (*document, *ResponseError) {
+	doc, has := s.docs[uri]
+	if !has {
+		return nil, &ResponseError{Code: InvalidParams, Message: fmt.Sprintf("document %v is not open", uri)}
+	}
+	return doc, nil
+}
+
+func (s *Server) Hover(params *TextDocumentPositionParams) (interface{}, *ResponseError) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	node := doc.typedAt(params.Position)
+	if node == nil {
+		return nil, nil
+	}
+	var value string
+	if node.Label == "NAME" {
+		value = fmt.Sprintf("%v %v", node.Value, node.Type)
+	} else {
+		value = fmt.Sprintf("%v %v", node.Label, node.Type)
+	}
+	r := doc.lines.Range(node.Location())
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```tcel\n" + value + "\n```"},
+		Range:    &r,
+	}, nil
+}
+
+func (s *Server) Definition(params *TextDocumentPositionParams) (interface{}, *ResponseError) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if doc.info == nil {
+		return nil, nil
+	}
+	node := doc.at(params.Position)
+	if node == nil {
+		return nil, nil
+	}
+	def, has := doc.info.Uses[node]
+	if !has {
+		for _, d := range doc.info.Defs {
+			if d == node {
+				def, has = d, true
+				break
+			}
+		}
+	}
+	if !has {
+		return nil, nil
+	}
+	loc := def.Location()
+	if loc == nil || loc.Filename == doc.path {
+		return []Location{{URI: doc.uri, Range: doc.lines.Range(loc)}}, nil
+	}
+	// the definition is in a module the document imports
+	text, rerr := ioutil.ReadFile(loc.Filename)
+	if rerr != nil {
+		return nil, &ResponseError{Code: InternalError, Message: rerr.Error()}
+	}
+	uri := (&url.URL{Scheme: "file", Path: loc.Filename}).String()
+	return []Location{{URI: uri, Range: newLines(string(text), s.encoding).Range(loc)}}, nil
+}
+
+func (s *Server) DocumentSymbols(params *DocumentSymbolParams) (interface{}, *ResponseError) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	syms := []DocumentSymbol{}
+	if doc.ast != nil {
+		syms = append(syms, doc.symbols(doc.ast)...)
+	}
+	return syms, nil
+}
+
+func (self *document) symbols(node *frontend.Node) (syms []DocumentSymbol) {
+	switch node.Label {
+	case "Assign":
+		name := node.Get(0)
+		if name.Label == "Targets" {
+			for _, target := range name.Children {
+				if target.Label == "NAME" {
+					syms = append(syms, self.symbol(target, node, nil))
+				}
+			}
+			return append(syms, self.symbols(node.Get(1))...)
+		}
+		if name.Label != "NAME" {
+			return self.symbols(node.Get(1))
+		}
+		return append(syms, self.symbol(name, node, self.symbols(node.Get(1))))
+	case "Func":
+		for _, param := range node.Get(0).Children {
+			syms = append(syms, self.symbol(param.Get(0), param, nil))
+		}
+		return append(syms, self.symbols(node.Get(2))...)
+	}
+	for _, kid := range node.Children {
+		syms = append(syms, self.symbols(kid)...)
+	}
+	return syms
+}
+
+func (self *document) symbol(name, whole *frontend.Node, kids []DocumentSymbol) DocumentSymbol {
+	sym := DocumentSymbol{
+		Name:           fmt.Sprintf("%v", name.Value),
+		Kind:           SymbolVariable,
+		Range:          self.lines.Range(whole.Location()),
+		SelectionRange: self.lines.Range(name.Location()),
+		Children:       kids,
+	}
+	if name.Type != nil {
+		sym.Detail = name.Type.String()
+		if _, is := name.Type.(*types.Function); is {
+			sym.Kind = SymbolFunction
+		}
+	}
+	return sym
+}
+
+type document struct {
+	uri         string
+	path        string
+	text        string
+	lines       *lines
+	ast         *frontend.Node
+	info        *checker.Info
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri, text, encoding string) *document {
+	doc := &document{uri: uri, path: uri, text: text, lines: newLines(text, encoding)}
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		doc.path = u.Path
+	}
+	doc.analyze()
+	return doc
+}
+
+func (self *document) diagnose(r Range, err error) {
+	self.diagnostics = append(self.diagnostics, Diagnostic{
+		Range:    r,
+		Severity: SeverityError,
+		Source:   "tcel",
+		Message:  err.Error(),
+	})
+}
+
+// analyze lexes, parses and type checks the document, recording any errors
+// as diagnostics. The checker panics on some malformed trees so that is
+// reported as a diagnostic too rather than taking down the server.
+func (self *document) analyze() {
+	self.diagnostics = []Diagnostic{}
+	defer func() {
+		if e := recover(); e != nil {
+			self.info = nil
+			self.diagnose(Range{}, fmt.Errorf("internal error: %v", e))
+		}
+	}()
+	tokens, err := frontend.Lex(self.text, self.path)
+	if err != nil {
+		self.diagnose(Range{}, err)
+		return
+	}
+	ast, err := frontend.Parse(tokens)
+	if err != nil {
+		self.diagnose(self.parseErrorRange(err, tokens), err)
+		return
+	}
+	// the modules the document imports are read from disk and checked with
+	// it, the document is the last of them
+	path := filepath.Clean(self.path)
+	l := &loader.Loader{
+		Parse: func(p string) (*frontend.Node, error) {
+			if p == path {
+				return ast, nil
+			}
+			return loader.ParseFile(p)
+		},
+	}
+	program, err := l.Load(path)
+	if err != nil {
+		self.diagnose(Range{}, err)
+		program = ast
+	}
+	self.ast = ast
+	info, err := checker.Analyze(program)
+	self.info = info
+	if errs, is := err.(checker.Errors); is {
+		for _, e := range errs {
+			if ce, is := e.(*checker.Error); is {
+				if loc := ce.Location(); loc != nil && loc.Filename != self.path {
+					self.diagnose(Range{}, fmt.Errorf("%v: %v", loc.Filename, ce))
+					continue
+				}
+				self.diagnose(self.lines.Range(ce.Location()), ce)
+			} else {
+				self.diagnose(Range{}, e)
+			}
+		}
+	} else if err != nil {
+		self.diagnose(Range{}, err)
+	}
+}
+
+func (self *document) parseErrorRange(err error, tokens []*frontend.Token) Range {
+	var tok *frontend.Token
+	if pe, is := err.(*frontend.ParseError); is && pe != nil && pe.Token != nil {
+		tok = pe.Token
+	} else if len(tokens) > 0 {
+		tok = tokens[len(tokens)-1]
+	} else {
+		return Range{}
+	}
+	return self.lines.Range(&frontend.SourceLocation{
+		Filename:    tok.Filename,
+		StartLine:   tok.StartLine,
+		StartColumn: tok.StartColumn,
+		EndLine:     tok.EndLine,
+		EndColumn:   tok.EndColumn,
+	})
+}
+
+// at finds the innermost node spanning pos.
+func (self *document) at(pos Position) *frontend.Node {
+	if self.ast == nil {
+		return nil
+	}
+	var find func(*frontend.Node) *frontend.Node
+	find = func(node *frontend.Node) *frontend.Node {
+		if !self.lines.Contains(node.Location(), pos) {
+			return nil
+		}
+		for _, kid := range node.Children {
+			if n := find(kid); n != nil {
+				return n
+			}
+		}
+		return node
+	}
+	return find(self.ast)
+}
+
+// typedAt finds the innermost node with a type spanning pos.
+func (self *document) typedAt(pos Position) *frontend.Node {
+	if self.ast == nil {
+		return nil
+	}
+	var find func(*frontend.Node) *frontend.Node
+	find = func(node *frontend.Node) *frontend.Node {
+		if !self.lines.Contains(node.Location(), pos) {
+			return nil
+		}
+		for _, kid := range node.Children {
+			if n := find(kid); n != nil {
+				return n
+			}
+		}
+		if node.Type == nil || node.Label == "Stmts" || node.Label == "Assign" {
+			return nil
+		}
+		return node
+	}
+	return find(self.ast)
+}
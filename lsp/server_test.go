@@ -0,0 +1,328 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type client struct {
+	t    *testing.T
+	conn *Conn
+	id   int
+	done chan error
+}
+
+func newClient(t *testing.T) *client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	c := &client{
+		t:    t,
+		conn: NewConn(cr, cw),
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := Serve(sr, sw)
+		sw.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+// next reads the next message from the server decoded as a generic map.
+func (c *client) next() map[string]interface{} {
+	body, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func (c *client) call(method string, params interface{}, result interface{}) {
+	c.id++
+	err := c.conn.WriteMessage(map[string]interface{}{
+		"jsonrpc": "2.0", "id": c.id, "method": method, "params": params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var resp struct {
+		Id     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *ResponseError  `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.Error != nil {
+		c.t.Fatalf("%v failed: %v", method, resp.Error)
+	}
+	if resp.Id != c.id {
+		c.t.Fatalf("expected response to %d got %d", c.id, resp.Id)
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	err := c.conn.WriteMessage(map[string]interface{}{
+		"jsonrpc": "2.0", "method": method, "params": params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) diagnostics() []Diagnostic {
+	msg := c.next()
+	if msg["method"] != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics got %v", msg)
+	}
+	body, _ := json.Marshal(msg["params"])
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(body, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params.Diagnostics
+}
+
+func (c *client) open(uri, text string) []Diagnostic {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": uri, "languageId": "tcel", "version": 1, "text": text,
+		},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) exit() {
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+	}
+}
+
+var program = `add = fn(x int, y int) int {
+	x + y
+}
+z = add(1, 2)
+`
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{}, &result)
+	if !result.Capabilities.HoverProvider || !result.Capabilities.DefinitionProvider {
+		t.Errorf("missing capabilities %v", result)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	c.exit()
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	if diags := c.open("file:///ok.x", program); len(diags) != 0 {
+		t.Errorf("expected no diagnostics got %v", diags)
+	}
+	diags := c.open("file:///bad.x", "x = 1\ny = x + \"a\"\n")
+	if len(diags) == 0 {
+		t.Fatalf("expected a diagnostic")
+	}
+	for _, d := range diags {
+		if d.Range.Start.Line != 1 {
+			t.Errorf("expected the error on line 1 got %v", d)
+		}
+	}
+	diags = c.open("file:///syntax.x", "x = fn(\n")
+	if len(diags) != 1 {
+		t.Fatalf("expected one diagnostic got %v", diags)
+	}
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": "file:///syntax.x", "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "x = 1\n"}},
+	})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("expected the change to fix the error got %v", diags)
+	}
+	c.exit()
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.open("file:///ok.x", program)
+	var hover Hover
+	c.call("textDocument/hover", at("file:///ok.x", 3, 0), &hover)
+	if !strings.Contains(hover.Contents.Value, "z int") {
+		t.Errorf("expected z to be an int got %v", hover.Contents.Value)
+	}
+	c.call("textDocument/hover", at("file:///ok.x", 0, 1), &hover)
+	if !strings.Contains(hover.Contents.Value, "add fn(int,int)int") {
+		t.Errorf("expected the type of add got %v", hover.Contents.Value)
+	}
+	c.exit()
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.open("file:///ok.x", program)
+	var locs []Location
+	c.call("textDocument/definition", at("file:///ok.x", 1, 5), &locs)
+	if len(locs) != 1 {
+		t.Fatalf("expected a definition got %v", locs)
+	}
+	expected := Range{Start: Position{0, 16}, End: Position{0, 17}}
+	if locs[0].Range != expected {
+		t.Errorf("expected y's declaration at %v got %v", expected, locs[0].Range)
+	}
+	c.call("textDocument/definition", at("file:///ok.x", 3, 5), &locs)
+	expected = Range{Start: Position{0, 0}, End: Position{0, 3}}
+	if len(locs) != 1 || locs[0].Range != expected {
+		t.Errorf("expected add's assignment at %v got %v", expected, locs)
+	}
+	c.exit()
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.open("file:///ok.x", program)
+	var syms []DocumentSymbol
+	c.call("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///ok.x"},
+	}, &syms)
+	if len(syms) != 2 || syms[0].Name != "add" || syms[1].Name != "z" {
+		t.Fatalf("expected add and z got %v", syms)
+	}
+	if syms[0].Kind != SymbolFunction || len(syms[0].Children) != 2 {
+		t.Errorf("expected add to be a function with two params got %v", syms[0])
+	}
+	c.exit()
+}
+
+// wide has a name after runes which are longer in UTF-8 and UTF-16 than
+// one character. m is at byte 23 of its line and UTF-16 code unit 20.
+var wide = "m = 1\nn = strlen(\"é😀\") + m\n"
+
+func TestUTF16(t *testing.T) {
+	c := newClient(t)
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{}, &result)
+	if result.Capabilities.PositionEncoding != UTF16 {
+		t.Errorf("expected the default encoding got %v", result.Capabilities.PositionEncoding)
+	}
+	c.open("file:///unicode.x", wide)
+	var hover Hover
+	c.call("textDocument/hover", at("file:///unicode.x", 1, 20), &hover)
+	if !strings.Contains(hover.Contents.Value, "m int") {
+		t.Errorf("expected m to be an int got %v", hover.Contents.Value)
+	}
+	expected := Range{Start: Position{1, 20}, End: Position{1, 21}}
+	if hover.Range == nil || *hover.Range != expected {
+		t.Errorf("expected m at %v got %v", expected, hover.Range)
+	}
+	var locs []Location
+	c.call("textDocument/definition", at("file:///unicode.x", 1, 20), &locs)
+	expected = Range{Start: Position{0, 0}, End: Position{0, 1}}
+	if len(locs) != 1 || locs[0].Range != expected {
+		t.Errorf("expected m's assignment at %v got %v", expected, locs)
+	}
+	c.exit()
+}
+
+func TestUTF8(t *testing.T) {
+	c := newClient(t)
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"general": map[string]interface{}{"positionEncodings": []string{UTF16, UTF8}},
+		},
+	}, &result)
+	if result.Capabilities.PositionEncoding != UTF8 {
+		t.Errorf("expected utf-8 to be picked got %v", result.Capabilities.PositionEncoding)
+	}
+	c.open("file:///unicode.x", wide)
+	var hover Hover
+	c.call("textDocument/hover", at("file:///unicode.x", 1, 23), &hover)
+	expected := Range{Start: Position{1, 23}, End: Position{1, 24}}
+	if !strings.Contains(hover.Contents.Value, "m int") || hover.Range == nil || *hover.Range != expected {
+		t.Errorf("expected m at %v got %v %v", expected, hover.Contents.Value, hover.Range)
+	}
+	c.exit()
+}
+
+func TestAfterShutdown(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.open("file:///ok.x", program)
+	c.call("shutdown", nil, nil)
+	err := c.conn.WriteMessage(map[string]interface{}{
+		"jsonrpc": "2.0", "id": 99, "method": "textDocument/hover", "params": at("file:///ok.x", 3, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := c.next()
+	rerr, _ := msg["error"].(map[string]interface{})
+	if rerr == nil || rerr["code"] != float64(InvalidRequest) {
+		t.Errorf("expected an invalid request after shutdown got %v", msg)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefinitionInImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcel-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	geo := filepath.Join(dir, "geo.x")
+	if err := ioutil.WriteFile(geo, []byte("module geo\n\nOrigin = \"é\" + \"o\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := (&url.URL{Scheme: "file", Path: filepath.Join(dir, "main.x")}).String()
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+	if diags := c.open(uri, "import \"geo\"\nx = geo.Origin\n"); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics got %v", diags)
+	}
+	var locs []Location
+	c.call("textDocument/definition", at(uri, 1, 9), &locs)
+	expected := Location{
+		URI:   (&url.URL{Scheme: "file", Path: geo}).String(),
+		Range: Range{Start: Position{2, 0}, End: Position{2, 6}},
+	}
+	if len(locs) != 1 || locs[0] != expected {
+		t.Errorf("expected Origin in geo.x at %v got %v", expected, locs)
+	}
+	c.exit()
+}
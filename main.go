@@ -4,6 +4,8 @@ import (
 	"os"
 	"io"
 	"io/ioutil"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"syscall"
@@ -17,10 +19,15 @@ import (
 )
 
 import (
+	"github.com/timtadh/tcel/cache"
 	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/checker"
+	"github.com/timtadh/tcel/debugger"
 	"github.com/timtadh/tcel/evaluator"
+	"github.com/timtadh/tcel/format"
 	"github.com/timtadh/tcel/il"
+	"github.com/timtadh/tcel/loader"
+	"github.com/timtadh/tcel/lsp"
 	"github.com/timtadh/tcel/x86"
 )
 
@@ -32,8 +39,15 @@ func init() {
 }
 
 
-var UsageMessage string = "tcel -o <path> <input>+ "
+var UsageMessage string = "tcel -o <path> <input>+ \n       tcel fmt [-w|-d] <input>+\n       tcel debug <input>+\n       tcel lsp"
 var ExtendedMessage string = `
+Commands
+    fmt                                 print the inputs in canonical style
+        -w, write                       rewrite the inputs in place
+        -d, diff                        print a diff against the inputs
+    debug                               step through the evaluation of the
+                                        inputs with commands read from stdin
+    lsp                                 run a language server on stdin/stdout
 
 Options
     -h, --help                          print this message
@@ -41,6 +55,15 @@ Options
     -L, lex                             stop at lexing
     -A, ast                             stop at AST generation
     -T, typed-ast                       stop at type checked AST
+    --json                              print the AST as JSON
+    --eval                              evaluate the inputs
+    --no-cache                          do not use the build cache in
+                                        $TCEL_CACHE, parse and check every
+                                        input
+    --profile=<path>                    evaluate the inputs writing the time
+                                        spent in each call stack as folded
+                                        stacks to path and a table of the
+                                        functions and statements to stderr
 
 Specs
     <path>
@@ -120,48 +143,61 @@ func lex(paths ... string) FilesTokens {
 		if err != nil {
 			log.Fatal(err)
 		}
-		scanner, err := frontend.Lexer(string(program), path)
+		tokens, err := frontend.Lex(string(program), path)
 		if err != nil {
 			log.Fatal(err)
 		}
-		var tokens []*frontend.Token
-		for tok, err, eof := scanner.Next(); !eof; tok, err, eof = scanner.Next() {
-			if err != nil {
-				panic(err)
-			}
-			tokens = append(tokens, tok.(*frontend.Token))
-		}
 		files = append(files, &FileTokens{path, tokens})
 	}
 	return files
 }
 
-func parse(files FilesTokens) *frontend.Node {
-	/*
-	defer func() {
-		if e := recover(); e != nil {
-			log.Fatal(e)
-		}
-	}()*/
-	var A *frontend.Node = nil
-	for _, file := range files {
-		log.Println("> parsing", file.Filename)
-		n, err := frontend.Parse(file.Tokens)
-		if err != nil {
-			log.Fatal(err)
-		}
+func write_json(node *frontend.Node, ouf io.Writer) {
+	data, err := json.Marshal(node)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ouf.Write(data)
+	ouf.Write([]byte("\n"))
+}
 
-		if A == nil {
-			A = n
-		} else {
-			for _, kid := range n.Children {
-				A.AddKid(kid)
-			}
-		}
+// open_cache opens the build cache. It is nil if the cache is off.
+func open_cache() *cache.Cache {
+	dir, err := cache.Dir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dir == "" {
+		return nil
 	}
-	if A == nil {
+	c, err := cache.New(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// parse loads the modules at the paths and the modules they import. Each
+// file is a module of its own. With a cache only the files which changed
+// are parsed.
+func parse(c *cache.Cache, paths ...string) *frontend.Node {
+	if len(paths) == 0 {
 		log.Fatal("You must supply input paths")
 	}
+	l := &loader.Loader{
+		Parse: func(path string) (*frontend.Node, error) {
+			files := lex(path)
+			log.Println("> parsing", path)
+			return frontend.Parse(files[0].Tokens)
+		},
+	}
+	if c != nil {
+		l.Parse = c.Parser(l.Parse)
+	}
+	A, err := l.Load(paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return A
 }
 
@@ -174,24 +210,98 @@ func ilgen(node *frontend.Node) il.Functions {
 	return fns
 }
 
-func typecheck(node *frontend.Node) *frontend.Node {
+// typecheck checks the program. With a cache only the modules which
+// changed, or import one which did, are checked.
+func typecheck(c *cache.Cache, node *frontend.Node) *frontend.Node {
 	log.Print("> type checking")
-	err := checker.Check(node)
+	if c == nil {
+		if err := checker.Check(node); err != nil {
+			log.Fatal(err)
+		}
+		return node
+	}
+	checked, err := c.Check(node)
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("> %d of %d modules from the cache", len(node.Children)-len(checked), len(node.Children))
 	return node
 }
 
+// generate gives what gen makes of the program for the stage, from the
+// cache if there is one and the program has not changed.
+func generate(c *cache.Cache, stage string, node *frontend.Node, gen func() string) string {
+	if c == nil {
+		return gen()
+	}
+	made := false
+	out, err := c.Generate(stage, node, func() (string, error) {
+		made = true
+		return gen(), nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !made {
+		log.Printf("> using the cached %v", stage)
+	}
+	return out
+}
+
 func eval(node *frontend.Node) []interface{} {
 	log.Print("> evaluating")
 	values, err := evaluator.Evaluate(node)
-	if err != nil {
+	if rerr, is := err.(*evaluator.RuntimeError); is {
+		log.Fatal(rerr.Backtrace())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	return values
+}
+
+func profiled(node *frontend.Node, path string) []interface{} {
+	log.Print("> evaluating with profiling")
+	profile := evaluator.NewProfile()
+	values, err := evaluator.Profiled(node, profile)
+	f, ferr := os.Create(path)
+	if ferr != nil {
+		log.Fatal(ferr)
+	}
+	defer f.Close()
+	if werr := profile.WriteFolded(f); werr != nil {
+		log.Fatal(werr)
+	}
+	log.Print(profile)
+	if rerr, is := err.(*evaluator.RuntimeError); is {
+		log.Fatal(rerr.Backtrace())
+	} else if err != nil {
 		log.Fatal(err)
 	}
 	return values
 }
 
+func debug(args []string) {
+	paths, optargs, err := getopt.GetOpt(args, "h", []string{"help"})
+	if err != nil {
+		log.Print(err)
+		Usage(1)
+	}
+	for _, oa := range optargs {
+		switch oa.Opt() {
+		case "-h", "--help": Usage(0)
+		}
+	}
+	c := open_cache()
+	T := typecheck(c, parse(c, paths...))
+	log.Print("> debugging")
+	_, err = debugger.Debug(T, os.Stdin, os.Stdout)
+	if rerr, is := err.(*evaluator.RuntimeError); is {
+		log.Fatal(rerr.Backtrace())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func x86_gen(I il.Functions) string {
 	log.Print("> compiling intermediate code to x86 32 bit assembly")
 	asm, e := x86.Generate(I)
@@ -216,27 +326,121 @@ func link(input, lib, output string) {
 	defer os.Remove("lib.o")
 	call("gcc -m32 -g -c -o main.o " + input)
 	defer os.Remove("main.o")
-	call("gcc -m32 -g -o " + output + " lib.o main.o")
+	call("gcc -m32 -g -o " + output + " lib.o main.o -lm")
+}
+
+func diff(path string, a, b []byte) []byte {
+	write_tmp := func(data []byte) string {
+		f, err := ioutil.TempFile("", "tcel-fmt")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Write(data); err != nil {
+			log.Fatal(err)
+		}
+		return f.Name()
+	}
+	orig := write_tmp(a)
+	defer os.Remove(orig)
+	formatted := write_tmp(b)
+	defer os.Remove(formatted)
+	out, err := exec.Command(
+		"diff", "-u", "--label", path+".orig", "--label", path, orig, formatted).Output()
+	if len(out) > 0 {
+		// diff exits with 1 when the files differ
+		return out
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func format_files(args []string) {
+	paths, optargs, err := getopt.GetOpt(args, "hwd", []string{"help", "write", "diff"})
+	if err != nil {
+		log.Print(err)
+		Usage(1)
+	}
+	write_back := false
+	show_diff := false
+	for _, oa := range optargs {
+		switch oa.Opt() {
+		case "-h", "--help": Usage(0)
+		case "-w", "--write":
+			write_back = true
+		case "-d", "--diff":
+			show_diff = true
+		}
+	}
+	if len(paths) <= 0 {
+		log.Print("Must supply some input paths")
+		Usage(1)
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		formatted, err := format.Source(src, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if show_diff {
+			os.Stdout.Write(diff(path, src, formatted))
+		}
+		if write_back {
+			if !bytes.Equal(src, formatted) {
+				if err := ioutil.WriteFile(path, formatted, 0644); err != nil {
+					log.Fatal(err)
+				}
+			}
+		} else if !show_diff {
+			os.Stdout.Write(formatted)
+		}
+	}
 }
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		format_files(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		debug(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	short := "ho:LATIS"
 	long := []string{
 		"help",
 		"output=",
 		"lex", "ast", "typed-ast", "il", "asm", "eval",
+		"json", "profile=", "no-cache",
 	}
 
 	args, optargs, err := getopt.GetOpt(os.Args[1:], short, long)
 	if err != nil {
-		log.Print(os.Stderr, err)
+		log.Print(err)
 		Usage(1)
 	}
 
 
 	output := ""
 	stop_at := "link"
+	as_json := false
+	profile := ""
+	use_cache := true
 	for _, oa := range optargs {
 		switch oa.Opt() {
 		case "-h", "--help": Usage(0)
@@ -254,6 +458,13 @@ func main() {
 			stop_at = "asm"
 		case "--eval":
 			stop_at = "eval"
+		case "--json":
+			as_json = true
+		case "--profile":
+			stop_at = "eval"
+			profile = oa.Arg()
+		case "--no-cache":
+			use_cache = false
 		}
 	}
 
@@ -273,7 +484,7 @@ func main() {
 	} else {
 		f, err := os.Create(output)
 		if err != nil {
-			log.Print(os.Stderr, err)
+			log.Print(err)
 			Usage(1)
 		}
 		defer f.Close()
@@ -285,38 +496,60 @@ func main() {
 		Usage(1)
 	}
 
-	L := lex(args...)
 	if stop_at == "lex" {
-		write(L, ouf)
+		write(lex(args...), ouf)
 		return
 	}
 
-	A := parse(L)
+	var c *cache.Cache
+	if use_cache {
+		c = open_cache()
+	}
+
+	A := parse(c, args...)
 	if stop_at == "ast" {
-		ouf.Write([]byte(fmt.Sprintf("%v\n", A.Serialize(false))))
+		if as_json {
+			write_json(A, ouf)
+		} else {
+			ouf.Write([]byte(fmt.Sprintf("%v\n", A.Serialize(false))))
+		}
 		return
 	}
 
 	if stop_at == "typed-ast" {
-		T := typecheck(A)
-		ouf.Write([]byte(fmt.Sprintf("%v\n", T.Serialize(true))))
+		T := typecheck(c, A)
+		if as_json {
+			write_json(T, ouf)
+		} else {
+			ouf.Write([]byte(fmt.Sprintf("%v\n", T.Serialize(true))))
+		}
 		return
 	}
 	
-	if stop_at == "eval" {
-		values := eval(typecheck(A))
+	if stop_at == "eval" && profile != "" {
+		values := profiled(typecheck(c, A), profile)
+		for _, value := range values {
+			ouf.Write([]byte(fmt.Sprintf("%v\n", value)))
+		}
+	} else if stop_at == "eval" {
+		values := eval(typecheck(c, A))
 		for _, value := range values {
 			ouf.Write([]byte(fmt.Sprintf("%v\n", value)))
 		}
 	} else {
-		I := ilgen(typecheck(A))
+		T := typecheck(c, A)
 		if stop_at == "il" {
-			write(I, ouf)
+			ouf.Write([]byte(generate(c, "il", T, func() string {
+				return ilgen(T).String()
+			}) + "\n"))
 			return
 		}
 
-		log.Println(I)
-		asm := x86_gen(I)
+		asm := generate(c, "asm", T, func() string {
+			I := ilgen(T)
+			log.Println(I)
+			return x86_gen(I)
+		})
 		ouf.Write([]byte(asm))
 
 		if stop_at == "asm" {
@@ -42,6 +42,11 @@ func (self *SymbolTable) Depth() int {
 	return len(self.symbols) - 1
 }
 
+// Upto gives the scopes to the depth. Scopes pushed on it are its own.
+func (self *SymbolTable) Upto(depth int) *SymbolTable {
+	return &SymbolTable{symbols: self.symbols[:depth+1:depth+1]}
+}
+
 func (self *SymbolTable) Get(sym string) interface{} {
 	for i := len(self.symbols) - 1; i >= 0; i-- {
 		if e, has := self.symbols[i][sym]; has {
@@ -60,6 +65,11 @@ func (self *SymbolTable) TopHas(name string) bool {
 	return has
 }
 
+// Top gives the symbols of the innermost scope.
+func (self *SymbolTable) Top() map[string]interface{} {
+	return self.symbols[len(self.symbols)-1]
+}
+
 func (self *SymbolTable) Has(name string) bool {
 	return self.Get(name) != nil
 }
@@ -0,0 +1,275 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Encoding is a Type as plain data tagged with its kind so it can be written
+// out with encoding/json or encoding/gob and read back with Decode.
+type Encoding struct {
+	Kind       string             `json:"kind"`
+	Name       string             `json:"name,omitempty"`
+	Path       string             `json:"path,omitempty"`
+	Id         int                `json:"id,omitempty"`
+	TypeParams []*Encoding        `json:"typeParams,omitempty"`
+	Parameters []*Encoding        `json:"parameters,omitempty"`
+	Returns    *Encoding          `json:"returns,omitempty"`
+	Elems      []*Encoding        `json:"elems,omitempty"`
+	Of         *Encoding          `json:"of,omitempty"`
+	Fields     []*FieldEncoding   `json:"fields,omitempty"`
+	Variants   []*VariantEncoding `json:"variants,omitempty"`
+}
+
+type VariantEncoding struct {
+	Name   string      `json:"name"`
+	Fields []*Encoding `json:"fields,omitempty"`
+}
+
+type FieldEncoding struct {
+	Name string    `json:"name"`
+	Type *Encoding `json:"type"`
+}
+
+// Encode converts t to its Encoding. A nil type encodes as nil. A Named type
+// is given with its structure the first time it occurs, later occurrences
+// only refer to it so recursive types can be encoded.
+func Encode(t Type) *Encoding {
+	return (&encoder{seen: make(map[*Named]bool)}).encode(t)
+}
+
+type encoder struct {
+	seen map[*Named]bool
+}
+
+func (self *encoder) encode(t Type) *Encoding {
+	switch t := t.(type) {
+	case nil:
+		return nil
+	case Primative:
+		return &Encoding{Kind: "primative", Name: string(t)}
+	case *Function:
+		vars := make([]*Encoding, 0, len(t.TypeParams))
+		for _, v := range t.TypeParams {
+			vars = append(vars, self.encode(v))
+		}
+		return &Encoding{Kind: "function", TypeParams: vars, Parameters: self.all(t.Parameters), Returns: self.encode(t.Returns)}
+	case *TypeVar:
+		return &Encoding{Kind: "typevar", Name: t.Name, Path: t.Path, Id: t.Id}
+	case *Meta:
+		if t.Type != nil {
+			return self.encode(t.Type)
+		}
+		return &Encoding{Kind: "meta", Id: t.Id}
+	case Tuple:
+		return &Encoding{Kind: "tuple", Elems: self.all(t)}
+	case *Array:
+		return &Encoding{Kind: "array", Of: self.encode(t.Base)}
+	case *Box:
+		return &Encoding{Kind: "box", Of: self.encode(t.Boxed)}
+	case *Record:
+		fields := make([]*FieldEncoding, 0, len(t.Fields))
+		for _, f := range t.Fields {
+			fields = append(fields, &FieldEncoding{Name: f.Name, Type: self.encode(f.Type)})
+		}
+		return &Encoding{Kind: "record", Fields: fields}
+	case *Union:
+		variants := make([]*VariantEncoding, 0, len(t.Variants))
+		for _, v := range t.Variants {
+			variants = append(variants, &VariantEncoding{Name: v.Name, Fields: self.all(v.Fields)})
+		}
+		return &Encoding{Kind: "union", Variants: variants}
+	case *Module:
+		return &Encoding{Kind: "module", Name: t.Name, Path: t.Path}
+	case *Named:
+		if self.seen[t] {
+			return &Encoding{Kind: "named", Name: t.Name, Path: t.Path, Id: t.Id}
+		}
+		self.seen[t] = true
+		return &Encoding{Kind: "named", Name: t.Name, Path: t.Path, Id: t.Id, Of: self.encode(t.Type)}
+	}
+	panic(fmt.Errorf("cannot encode type %v (%T)", t, t))
+}
+
+func (self *encoder) all(ts []Type) []*Encoding {
+	es := make([]*Encoding, 0, len(ts))
+	for _, t := range ts {
+		es = append(es, self.encode(t))
+	}
+	return es
+}
+
+// Decode converts the Encoding back to a Type. A nil Encoding decodes as a
+// nil Type.
+func (self *Encoding) Decode() (Type, error) {
+	return NewDecoder().Decode(self)
+}
+
+// Decoder decodes many Encodings sharing the Named types, type variables
+// and metas among them. The types of a tree are decoded with one Decoder as
+// type variables are substituted by identity.
+type Decoder struct {
+	d *decoder
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{&decoder{
+		named: make(map[ident]*Named),
+		vars:  make(map[ident]*TypeVar),
+		metas: make(map[int]*Meta),
+	}}
+}
+
+// Decode converts e to a Type. A nil Encoding decodes as a nil Type.
+func (self *Decoder) Decode(e *Encoding) (Type, error) {
+	return e.decode(self.d)
+}
+
+// ident is the identity of a Named type or a type variable.
+type ident struct {
+	path string
+	id   int
+}
+
+// decoder holds the Named types, type variables and metas decoded so far by
+// their identity so references to them can be resolved.
+type decoder struct {
+	named map[ident]*Named
+	vars  map[ident]*TypeVar
+	metas map[int]*Meta
+}
+
+func (self *Encoding) decode(d *decoder) (Type, error) {
+	if self == nil {
+		return nil, nil
+	}
+	switch self.Kind {
+	case "primative":
+		for _, p := range append(Primatives, Label) {
+			if string(p) == self.Name {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown primative type %q", self.Name)
+	case "function":
+		vars := make([]*TypeVar, 0, len(self.TypeParams))
+		for _, e := range self.TypeParams {
+			v, err := e.required("type parameter", d)
+			if err != nil {
+				return nil, err
+			}
+			tv, ok := v.(*TypeVar)
+			if !ok {
+				return nil, fmt.Errorf("type parameter %v is not a type variable", v)
+			}
+			vars = append(vars, tv)
+		}
+		params, err := decodeAll(self.Parameters, d)
+		if err != nil {
+			return nil, err
+		}
+		returns, err := self.Returns.required("returns", d)
+		if err != nil {
+			return nil, err
+		}
+		return &Function{TypeParams: vars, Parameters: params, Returns: returns}, nil
+	case "typevar":
+		id := ident{self.Path, self.Id}
+		if v, has := d.vars[id]; has {
+			return v, nil
+		}
+		v := &TypeVar{Name: self.Name, Path: self.Path, Id: self.Id}
+		d.vars[id] = v
+		return v, nil
+	case "meta":
+		if m, has := d.metas[self.Id]; has {
+			return m, nil
+		}
+		m := &Meta{Id: self.Id}
+		d.metas[self.Id] = m
+		return m, nil
+	case "tuple":
+		elems, err := decodeAll(self.Elems, d)
+		if err != nil {
+			return nil, err
+		}
+		return Tuple(elems), nil
+	case "array":
+		base, err := self.Of.required("of", d)
+		if err != nil {
+			return nil, err
+		}
+		return &Array{Base: base}, nil
+	case "box":
+		boxed, err := self.Of.required("of", d)
+		if err != nil {
+			return nil, err
+		}
+		return &Box{Boxed: boxed}, nil
+	case "record":
+		fields := make([]*Field, 0, len(self.Fields))
+		for _, f := range self.Fields {
+			if f == nil {
+				return nil, fmt.Errorf("record has a null field")
+			}
+			t, err := f.Type.required("field type", d)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, &Field{Name: f.Name, Type: t})
+		}
+		return &Record{Fields: fields}, nil
+	case "union":
+		variants := make([]*Variant, 0, len(self.Variants))
+		for _, v := range self.Variants {
+			if v == nil {
+				return nil, fmt.Errorf("union has a null variant")
+			}
+			fields, err := decodeAll(v.Fields, d)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, &Variant{Name: v.Name, Fields: fields})
+		}
+		return &Union{Variants: variants}, nil
+	case "module":
+		// only the identity of a module is encoded, not its members
+		return &Module{Name: self.Name, Path: self.Path}, nil
+	case "named":
+		id := ident{self.Path, self.Id}
+		if n, has := d.named[id]; has {
+			return n, nil
+		}
+		if self.Of == nil {
+			return nil, fmt.Errorf("named type %v is used before it is given", self.Name)
+		}
+		n := &Named{Name: self.Name, Path: self.Path, Id: self.Id}
+		d.named[id] = n
+		t, err := self.Of.decode(d)
+		if err != nil {
+			return nil, err
+		}
+		n.Type = t
+		return n, nil
+	}
+	return nil, fmt.Errorf("unknown kind of type %q", self.Kind)
+}
+
+// required decodes a component type which must be present.
+func (self *Encoding) required(field string, d *decoder) (Type, error) {
+	if self == nil {
+		return nil, fmt.Errorf("type is missing its %v", field)
+	}
+	return self.decode(d)
+}
+
+func decodeAll(es []*Encoding, d *decoder) ([]Type, error) {
+	ts := make([]Type, 0, len(es))
+	for _, e := range es {
+		t, err := e.required("component", d)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
@@ -0,0 +1,175 @@
+package types
+
+import (
+	"fmt"
+)
+
+// TypeVar is a type parameter of a generic function. It stands for the type
+// the function is instantiated with. Like a Named type it is told apart by
+// the Path of the module declaring it and its Id.
+type TypeVar struct {
+	Name string
+	Path string
+	Id   int
+}
+
+var type_var_ids = 0
+
+func NewTypeVar(name string) *TypeVar {
+	type_var_ids++
+	return &TypeVar{Name: name, Id: type_var_ids}
+}
+
+func (self *TypeVar) Equals(o Type) bool {
+	t, ok := Resolve(o).(*TypeVar)
+	return ok && (t == self || (t.Name == self.Name && t.Path == self.Path && t.Id == self.Id))
+}
+
+func (self *TypeVar) String() string {
+	return self.Name
+}
+
+func (self *TypeVar) Empty() interface{} {
+	panic(fmt.Errorf("cannot construct a value of the type variable %v", self))
+}
+
+func (self *TypeVar) Unboxed() Type {
+	return self
+}
+
+// Generic reports whether t is a function with type parameters.
+func Generic(t Type) bool {
+	f, ok := Underlying(t).(*Function)
+	return ok && len(f.TypeParams) > 0
+}
+
+// Instantiate gives the type of the generic function f with its type
+// parameters replaced by args.
+func (self *Function) Instantiate(args []Type) *Function {
+	if len(args) != len(self.TypeParams) {
+		panic(fmt.Errorf("%v takes %d type arguments got %d", self, len(self.TypeParams), len(args)))
+	}
+	s := make(map[*TypeVar]Type, len(args))
+	for i, v := range self.TypeParams {
+		s[v] = args[i]
+	}
+	return &Function{
+		Parameters: substituteAll(self.Parameters, s),
+		Returns:    Substitute(self.Returns, s),
+	}
+}
+
+// Substitute replaces the type variables in t which are in s. Named types
+// are left as they are as they cannot refer to type variables.
+func Substitute(t Type, s map[*TypeVar]Type) Type {
+	if len(s) == 0 {
+		return t
+	}
+	switch t := t.(type) {
+	case *TypeVar:
+		if r, has := s[t]; has {
+			return r
+		}
+		return t
+	case *Function:
+		return &Function{
+			TypeParams: t.TypeParams,
+			Parameters: substituteAll(t.Parameters, s),
+			Returns:    Substitute(t.Returns, s),
+		}
+	case Tuple:
+		return Tuple(substituteAll(t, s))
+	case *Array:
+		return &Array{Base: Substitute(t.Base, s)}
+	case *Box:
+		return &Box{Boxed: Substitute(t.Boxed, s)}
+	case *Record:
+		fields := make([]*Field, 0, len(t.Fields))
+		for _, f := range t.Fields {
+			fields = append(fields, &Field{Name: f.Name, Type: Substitute(f.Type, s)})
+		}
+		return &Record{Fields: fields}
+	case *Union:
+		variants := make([]*Variant, 0, len(t.Variants))
+		for _, v := range t.Variants {
+			variants = append(variants, &Variant{Name: v.Name, Fields: substituteAll(v.Fields, s)})
+		}
+		return &Union{Variants: variants}
+	}
+	return t
+}
+
+func substituteAll(ts []Type, s map[*TypeVar]Type) []Type {
+	subbed := make([]Type, 0, len(ts))
+	for _, t := range ts {
+		subbed = append(subbed, Substitute(t, s))
+	}
+	return subbed
+}
+
+// Unify matches pattern against t binding the type variables of pattern
+// which are keys of s. A key bound to nil is yet to be bound. It reports
+// whether t is an instance of pattern under the bindings.
+func Unify(pattern, t Type, s map[*TypeVar]Type) bool {
+	t = Resolve(t)
+	switch p := pattern.(type) {
+	case *TypeVar:
+		if bound, has := s[p]; has {
+			if bound == nil {
+				s[p] = t
+				return true
+			}
+			return bound.Equals(t)
+		}
+	case *Function:
+		f, ok := t.(*Function)
+		if !ok || len(p.TypeParams) > 0 || len(f.TypeParams) > 0 {
+			break
+		}
+		return unifyAll(p.Parameters, f.Parameters, s) && Unify(p.Returns, f.Returns, s)
+	case Tuple:
+		u, ok := t.(Tuple)
+		return ok && unifyAll(p, u, s)
+	case *Array:
+		a, ok := t.(*Array)
+		return ok && Unify(p.Base, a.Base, s)
+	case *Box:
+		b, ok := t.(*Box)
+		return ok && Unify(p.Boxed, b.Boxed, s)
+	case *Record:
+		r, ok := t.(*Record)
+		if !ok || len(p.Fields) != len(r.Fields) {
+			return false
+		}
+		for i, f := range p.Fields {
+			if f.Name != r.Fields[i].Name || !Unify(f.Type, r.Fields[i].Type, s) {
+				return false
+			}
+		}
+		return true
+	case *Union:
+		u, ok := t.(*Union)
+		if !ok || len(p.Variants) != len(u.Variants) {
+			return false
+		}
+		for i, v := range p.Variants {
+			if v.Name != u.Variants[i].Name || !unifyAll(v.Fields, u.Variants[i].Fields, s) {
+				return false
+			}
+		}
+		return true
+	}
+	return pattern.Equals(t)
+}
+
+func unifyAll(patterns, ts []Type, s map[*TypeVar]Type) bool {
+	if len(patterns) != len(ts) {
+		return false
+	}
+	for i, p := range patterns {
+		if !Unify(p, ts[i], s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Meta is a type yet to be inferred. Unifying it with a type binds it, from
+// then on it stands for that type.
+type Meta struct {
+	Id   int
+	Type Type // nil until bound
+}
+
+var meta_ids = 0
+
+func NewMeta() *Meta {
+	meta_ids++
+	return &Meta{Id: meta_ids}
+}
+
+func (self *Meta) Equals(o Type) bool {
+	if self.Type != nil {
+		return self.Type.Equals(o)
+	}
+	return Resolve(o) == self
+}
+
+func (self *Meta) String() string {
+	if self.Type != nil {
+		return self.Type.String()
+	}
+	return fmt.Sprintf("?%d", self.Id)
+}
+
+func (self *Meta) Empty() interface{} {
+	if self.Type != nil {
+		return self.Type.Empty()
+	}
+	panic(fmt.Errorf("cannot construct a value of the uninferred type %v", self))
+}
+
+func (self *Meta) Unboxed() Type {
+	if self.Type != nil {
+		return self.Type.Unboxed()
+	}
+	return self
+}
+
+// Resolve follows bound metas to the type they stand for.
+func Resolve(t Type) Type {
+	for {
+		m, ok := t.(*Meta)
+		if !ok || m.Type == nil {
+			return t
+		}
+		t = m.Type
+	}
+}
+
+// Equate unifies a and b, binding the metas in them so they are equal. It
+// reports whether they could be made equal. Metas bound before a failure
+// stay bound.
+func Equate(a, b Type) bool {
+	a, b = Resolve(a), Resolve(b)
+	if m, ok := a.(*Meta); ok {
+		return bind(m, b)
+	} else if m, ok := b.(*Meta); ok {
+		return bind(m, a)
+	}
+	switch x := a.(type) {
+	case *Function:
+		y, ok := b.(*Function)
+		if !ok || len(x.TypeParams) > 0 || len(y.TypeParams) > 0 {
+			break
+		}
+		return equateAll(x.Parameters, y.Parameters) && Equate(x.Returns, y.Returns)
+	case Tuple:
+		y, ok := b.(Tuple)
+		return ok && equateAll(x, y)
+	case *Array:
+		y, ok := b.(*Array)
+		return ok && Equate(x.Base, y.Base)
+	case *Box:
+		y, ok := b.(*Box)
+		return ok && Equate(x.Boxed, y.Boxed)
+	case *Record:
+		y, ok := b.(*Record)
+		if !ok || len(x.Fields) != len(y.Fields) {
+			return false
+		}
+		for i, f := range x.Fields {
+			if f.Name != y.Fields[i].Name || !Equate(f.Type, y.Fields[i].Type) {
+				return false
+			}
+		}
+		return true
+	case *Union:
+		y, ok := b.(*Union)
+		if !ok || len(x.Variants) != len(y.Variants) {
+			return false
+		}
+		for i, v := range x.Variants {
+			if v.Name != y.Variants[i].Name || !equateAll(v.Fields, y.Variants[i].Fields) {
+				return false
+			}
+		}
+		return true
+	}
+	return a.Equals(b)
+}
+
+func equateAll(as, bs []Type) bool {
+	if len(as) != len(bs) {
+		return false
+	}
+	for i, a := range as {
+		if !Equate(a, bs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// bind binds the unbound meta m to t unless t contains m, as then m would
+// stand for an infinite type.
+func bind(m *Meta, t Type) bool {
+	if t == Type(m) {
+		return true
+	}
+	for _, n := range Metas(t) {
+		if n == m {
+			return false
+		}
+	}
+	m.Type = t
+	return true
+}
+
+// Metas lists the unbound metas in t in the order they first occur.
+func Metas(t Type) []*Meta {
+	var metas []*Meta
+	seen := make(map[*Meta]bool)
+	var walk func(Type)
+	walk = func(t Type) {
+		switch t := Resolve(t).(type) {
+		case *Meta:
+			if !seen[t] {
+				seen[t] = true
+				metas = append(metas, t)
+			}
+		case *Function:
+			for _, p := range t.Parameters {
+				walk(p)
+			}
+			walk(t.Returns)
+		case Tuple:
+			for _, e := range t {
+				walk(e)
+			}
+		case *Array:
+			walk(t.Base)
+		case *Box:
+			walk(t.Boxed)
+		case *Record:
+			for _, f := range t.Fields {
+				walk(f.Type)
+			}
+		case *Union:
+			for _, v := range t.Variants {
+				for _, f := range v.Fields {
+					walk(f)
+				}
+			}
+		}
+	}
+	walk(t)
+	return metas
+}
+
+// Zonk replaces the bound metas in t with the types they stand for. Unbound
+// metas are left in place.
+func Zonk(t Type) Type {
+	switch t := Resolve(t).(type) {
+	case *Function:
+		return &Function{
+			TypeParams: t.TypeParams,
+			Parameters: zonkAll(t.Parameters),
+			Returns:    Zonk(t.Returns),
+		}
+	case Tuple:
+		return Tuple(zonkAll(t))
+	case *Array:
+		return &Array{Base: Zonk(t.Base)}
+	case *Box:
+		return &Box{Boxed: Zonk(t.Boxed)}
+	case *Record:
+		fields := make([]*Field, 0, len(t.Fields))
+		for _, f := range t.Fields {
+			fields = append(fields, &Field{Name: f.Name, Type: Zonk(f.Type)})
+		}
+		return &Record{Fields: fields}
+	case *Union:
+		variants := make([]*Variant, 0, len(t.Variants))
+		for _, v := range t.Variants {
+			variants = append(variants, &Variant{Name: v.Name, Fields: zonkAll(v.Fields)})
+		}
+		return &Union{Variants: variants}
+	default:
+		return t
+	}
+}
+
+func zonkAll(ts []Type) []Type {
+	zonked := make([]Type, 0, len(ts))
+	for _, t := range ts {
+		zonked = append(zonked, Zonk(t))
+	}
+	return zonked
+}
@@ -14,7 +14,10 @@ type Type interface {
 
 type Primative string
 
+// Function is the type of a function. A generic function has TypeParams
+// which its Parameters and Returns may refer to.
 type Function struct {
+	TypeParams []*TypeVar
 	Parameters []Type
 	Returns Type
 }
@@ -29,6 +32,56 @@ type Box struct {
 	Boxed Type
 }
 
+type Field struct {
+	Name string
+	Type Type
+}
+
+type Variant struct {
+	Name   string
+	Fields []Type
+}
+
+// Union is a tagged union, a value of it is one of its variants. Each
+// variant has a name, its constructor, and may carry some fields.
+type Union struct {
+	Variants []*Variant
+}
+
+// Named is a nominal type declared with `type Name T`. It is only equal to
+// itself, even if another named type has the same underlying Type. Id tells
+// apart types of the same name declared in different scopes and Path, the
+// module declaring it, types given Ids by different runs of the checker.
+type Named struct {
+	Name string
+	Path string
+	Id   int
+	Type Type
+}
+
+// Module is the type of the name an imported module is bound to. The
+// exported values and types of the module are reached through it, m.f. Path
+// tells apart modules of the same name.
+type Module struct {
+	Name   string
+	Path   string
+	Values map[string]Type
+	Types  map[string]Type
+}
+
+var named_ids = 0
+
+func NewNamed(name string, t Type) *Named {
+	named_ids++
+	return &Named{Name: name, Id: named_ids, Type: t}
+}
+
+// Record is an aggregate of named fields. Records are equal when they have
+// the same fields in the same order.
+type Record struct {
+	Fields []*Field
+}
+
 var Label Primative = "label"
 var Unit Primative = "unit"
 var String Primative = "string"
@@ -45,7 +98,7 @@ func (self Primative) String() string {
 }
 
 func (self Primative) Equals(o Type) bool {
-	if t, ok := o.(Primative); ok {
+	if t, ok := Resolve(o).(Primative); ok {
 		return string(t) == string(self)
 	}
 	return false
@@ -66,13 +119,25 @@ func (self Primative) Empty() interface{} {
 }
 
 func (self *Function) Equals(o Type) bool {
-	t, ok := o.(*Function)
+	t, ok := Resolve(o).(*Function)
 	if !ok {
 		return false
 	}
-	if len(self.Parameters) != len(t.Parameters) {
+	if len(self.Parameters) != len(t.Parameters) || len(self.TypeParams) != len(t.TypeParams) {
 		return false
 	}
+	if len(t.TypeParams) > 0 {
+		// generic functions are equal whatever their type parameters are
+		// called so t is renamed to use the parameters of self
+		s := make(map[*TypeVar]Type, len(t.TypeParams))
+		for i, v := range t.TypeParams {
+			s[v] = self.TypeParams[i]
+		}
+		t = &Function{
+			Parameters: substituteAll(t.Parameters, s),
+			Returns: Substitute(t.Returns, s),
+		}
+	}
 	if !self.Returns.Equals(t.Returns) {
 		return false
 	}
@@ -93,6 +158,13 @@ func (self *Function) String() string {
 	for _, param := range self.Parameters {
 		params = append(params, param.String())
 	}
+	if len(self.TypeParams) > 0 {
+		vars := make([]string, 0, len(self.TypeParams))
+		for _, v := range self.TypeParams {
+			vars = append(vars, v.String())
+		}
+		return fmt.Sprintf("fn[%v](%v)%v", strings.Join(vars, ","), strings.Join(params, ","), self.Returns)
+	}
 	return fmt.Sprintf("fn(%v)%v", strings.Join(params, ","), self.Returns)
 }
 
@@ -101,7 +173,7 @@ func (self *Function) Unboxed() Type {
 }
 
 func (self *Array) Equals(o Type) bool {
-	t, ok := o.(*Array)
+	t, ok := Resolve(o).(*Array)
 	if !ok {
 		return false
 	}
@@ -129,7 +201,7 @@ func (self Tuple) String() string {
 }
 
 func (self Tuple) Equals(o Type) bool {
-	t, ok := o.(Tuple)
+	t, ok := Resolve(o).(Tuple)
 	if !ok {
 		return false
 	}
@@ -153,7 +225,7 @@ func (self Tuple) Unboxed() Type {
 }
 
 func (self *Box) Equals(o Type) bool {
-	t, ok := o.(*Box)
+	t, ok := Resolve(o).(*Box)
 	if !ok {
 		return false
 	}
@@ -172,5 +244,157 @@ func (self *Box) Unboxed() Type {
 	return self.Boxed
 }
 
+func (self *Record) Equals(o Type) bool {
+	t, ok := Resolve(o).(*Record)
+	if !ok {
+		return false
+	}
+	if len(self.Fields) != len(t.Fields) {
+		return false
+	}
+	for i, f := range self.Fields {
+		if f.Name != t.Fields[i].Name || !f.Type.Equals(t.Fields[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *Record) String() string {
+	fields := make([]string, 0, len(self.Fields))
+	for _, f := range self.Fields {
+		fields = append(fields, fmt.Sprintf("%v %v", f.Name, f.Type))
+	}
+	return fmt.Sprintf("record{%v}", strings.Join(fields, ","))
+}
+
+func (self *Record) Empty() interface{} {
+	panic("cannot construct an empty record yet")
+}
+
+func (self *Record) Unboxed() Type {
+	return self
+}
+
+// Lookup finds the field with the given name returning its position in the
+// record, or -1 if there is no such field.
+func (self *Record) Lookup(name string) (int, Type) {
+	for i, f := range self.Fields {
+		if f.Name == name {
+			return i, f.Type
+		}
+	}
+	return -1, nil
+}
+
+func (self *Named) Equals(o Type) bool {
+	t, ok := Resolve(o).(*Named)
+	if !ok {
+		return false
+	}
+	return self == t || (self.Name == t.Name && self.Path == t.Path && self.Id == t.Id)
+}
+
+func (self *Named) String() string {
+	return self.Name
+}
+
+func (self *Named) Empty() interface{} {
+	return self.Type.Empty()
+}
+
+func (self *Named) Unboxed() Type {
+	return self
+}
+
+// Underlying strips any Named types and bound metas from t giving the
+// structure of the type.
+func Underlying(t Type) Type {
+	for {
+		n, ok := Resolve(t).(*Named)
+		if !ok {
+			return Resolve(t)
+		}
+		t = n.Type
+	}
+}
+
+func (self *Union) Equals(o Type) bool {
+	t, ok := Resolve(o).(*Union)
+	if !ok {
+		return false
+	}
+	if len(self.Variants) != len(t.Variants) {
+		return false
+	}
+	for i, v := range self.Variants {
+		w := t.Variants[i]
+		if v.Name != w.Name || len(v.Fields) != len(w.Fields) {
+			return false
+		}
+		for j, f := range v.Fields {
+			if !f.Equals(w.Fields[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (self *Union) String() string {
+	variants := make([]string, 0, len(self.Variants))
+	for _, v := range self.Variants {
+		variants = append(variants, v.String())
+	}
+	return strings.Join(variants, "|")
+}
+
+func (self *Union) Empty() interface{} {
+	panic("cannot construct an empty union")
+}
+
+func (self *Union) Unboxed() Type {
+	return self
+}
+
+// Lookup finds the variant with the given name giving its tag, or -1 if
+// there is no such variant.
+func (self *Union) Lookup(name string) (int, *Variant) {
+	for i, v := range self.Variants {
+		if v.Name == name {
+			return i, v
+		}
+	}
+	return -1, nil
+}
+
+func (self *Variant) String() string {
+	if len(self.Fields) == 0 {
+		return self.Name
+	}
+	fields := make([]string, 0, len(self.Fields))
+	for _, f := range self.Fields {
+		fields = append(fields, f.String())
+	}
+	return fmt.Sprintf("%v(%v)", self.Name, strings.Join(fields, ","))
+}
+
+func (self *Module) Equals(o Type) bool {
+	t, ok := Resolve(o).(*Module)
+	if !ok {
+		return false
+	}
+	return self == t || self.Path == t.Path
+}
+
+func (self *Module) String() string {
+	return fmt.Sprintf("module %v", self.Name)
+}
 
+func (self *Module) Empty() interface{} {
+	panic("a module is not a value")
+}
 
+func (self *Module) Unboxed() Type {
+	return self
+}
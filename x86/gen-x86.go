@@ -2,57 +2,37 @@ package x86
 
 import (
 	"fmt"
+	"math"
 	"strings"
 )
 
 import (
+	"github.com/timtadh/tcel/builtins"
+	"github.com/timtadh/tcel/frontend"
 	"github.com/timtadh/tcel/types"
 	"github.com/timtadh/tcel/il"
 )
 
-var Lib string = `
-#include <stdio.h>
-#include <error.h>
-#include <errno.h>
-
-extern void print_int(int);
-extern int read_stdin_int(char *);
-extern void print(char *);
-
-void print_int(int i) {
-	printf("%d\n", i);
-}
-
-int read_stdin_int(char * msg) {
-	int read;
-	printf("%s ", msg);
-	int res = scanf("%d", &read);
-	if (res == EOF) {
-		int e = errno;
-		error(1, e, "EOF on stdin read\n");
-		return 0;
-	} else if (res == 0) {
-		error(1, EIO, "Could not read int from stdin\n");
-		return 0;
-	} else {
-		return read;
-	}
-}
-
-void print(char * msg) {
-	printf("%s\n", msg);
-}
-`
+// Lib is the runtime the generated code is linked with.
+var Lib string = builtins.Runtime()
 
-func Generate(fns il.Functions) (string, error) {
+// Generate gives the assembly of the functions. IL the generator does not
+// support yet is an error.
+func Generate(fns il.Functions) (asm string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			asm = ""
+			err = fmt.Errorf("%v", e)
+		}
+	}()
 	g := newGen()
 	g.ProgramSetup(fns)
-	err := g.Functions(fns)
+	err = g.Functions(fns)
 	program := make([]string, len(g.rodata) + len(g.data) + len(g.program) + 1)
 	copy(program, g.rodata)
 	copy(program[len(g.rodata):], g.data)
 	copy(program[len(g.rodata) + len(g.data):], g.program)
-	asm := strings.Join(program, "\n")
+	asm = strings.Join(program, "\n")
 	if err != nil {
 		fmt.Println(asm)
 		return "", err
@@ -65,6 +45,8 @@ type x86Gen struct {
 	data    []string
 	rodata  []string
 	f       *frame
+	files   map[string]int // the number of each source file
+	at      *frontend.SourceLocation // of the last instruction
 }
 
 type frame struct {
@@ -75,6 +57,7 @@ type frame struct {
 func newGen() *x86Gen {
 	return &x86Gen{
 		program: make([]string, 0, 100),
+		files: make(map[string]int),
 	}
 }
 
@@ -151,10 +134,12 @@ func (g *x86Gen) Value(o *il.Operand) string {
 	panic(fmt.Errorf("Can't gen a value of %v", o))
 }
 
+// ConstValue gives the immediate of a constant. A float is the bits of its
+// single precision value, as floats are held in a word.
 func (g *x86Gen) ConstValue(v *il.Constant) string {
 	switch c := v.Value.(type) {
 	case int64: return fmt.Sprintf("%v", c)
-	case float64: panic(fmt.Errorf("not yet supported"))
+	case float64: return fmt.Sprintf("%v", math.Float32bits(float32(c)))
 	case string: return g.String(c)
 	case bool: panic(fmt.Errorf("not yet supported"))
 	}
@@ -184,8 +169,8 @@ func (g *x86Gen) loc(r *il.Register) int {
 }
 
 func (g *x86Gen) Functions(fns il.Functions) error {
-	for _, fn := range fns {
-		if err := g.Function(fn); err != nil {
+	for _, name := range fns.Names() {
+		if err := g.Function(fns[name]); err != nil {
 			return err
 		}
 	}
@@ -193,26 +178,55 @@ func (g *x86Gen) Functions(fns il.Functions) error {
 }
 
 func (g *x86Gen) Function(fn *il.Func) error {
+	g.StackMap(fn)
 	g.Add("")
 	g.Direct(fmt.Sprintf(".global %v", g.Name(fn.Name)))
 	g.Direct(fmt.Sprintf(".type %v @function", g.Name(fn.Name)))
 	g.Label(fn.Name)
+	g.Direct(".cfi_startproc")
+	g.at = nil
+	if len(fn.BlockList) > 0 && len(fn.BlockList[0].Insts) > 0 {
+		g.Loc(fn.BlockList[0].Insts[0].Loc)
+	}
 	g.FnPush(fn)
 	for _, blk := range fn.BlockList {
 		if err := g.Block(blk); err != nil {
 			return err
 		}
 	}
+	g.Direct(".cfi_endproc")
 	return nil
 }
 
+// Loc tells the debugger the instructions which follow are from loc, the
+// start of which is the line stepped to.
+func (g *x86Gen) Loc(loc *frontend.SourceLocation) {
+	if loc == nil {
+		return
+	}
+	if g.at != nil && g.at.Filename == loc.Filename && g.at.StartLine == loc.StartLine && g.at.StartColumn == loc.StartColumn {
+		return
+	}
+	file, has := g.files[loc.Filename]
+	if !has {
+		file = len(g.files) + 1
+		g.files[loc.Filename] = file
+		g.Direct(fmt.Sprintf(".file %d %q", file, loc.Filename))
+	}
+	g.Direct(fmt.Sprintf(".loc %d %d %d", file, loc.StartLine, loc.StartColumn))
+	g.at = loc
+}
+
 func (g *x86Gen) FnPush(fn *il.Func) {
 	g.f = &frame{
 		fn: fn,
 		locs: make(map[uint32]int),
 	}
 	g.Add("pushl %ebp")
+	g.Direct(".cfi_def_cfa_offset 8")
+	g.Direct(".cfi_offset %ebp, -8")
 	g.Add("movl %esp, %ebp")
+	g.Direct(".cfi_def_cfa_register %ebp")
 	g.Add(fmt.Sprintf("pushl display_%d", fn.Scope))
 	g.Add(fmt.Sprintf("movl %%ebp, display_%d", fn.Scope))
 	g.Add(fmt.Sprintf("subl $%d, %%esp", len(fn.Registers)*4))
@@ -223,20 +237,71 @@ func (g *x86Gen) FnPush(fn *il.Func) {
 		g.f.locs[r.Id] = g.loc(r)
 		g.Add(fmt.Sprintf("movl $0, %v", g.location(r)))
 	}
+	g.Add(fmt.Sprintf("pushl $%v", g.stackMap(fn)))
+	g.Add("pushl %ebp")
+	g.Add(fmt.Sprintf("call %v", builtins.Enter))
+	g.Add("addl $8, %esp")
+}
+
+func (g *x86Gen) stackMap(fn *il.Func) string {
+	return g.Name(fn.Name) + "_stack_map"
+}
+
+// StackMap tells the collector which registers of the function hold
+// pointers, as their offsets from the frame pointer after their count. The
+// registers are zeroed on entry so each is a pointer or null.
+func (g *x86Gen) StackMap(fn *il.Func) {
+	offsets := make([]string, 0, len(fn.Registers))
+	for _, r := range fn.Registers {
+		if il.Pointer(r.Type) {
+			offsets = append(offsets, fmt.Sprint(g.loc(r)))
+		}
+	}
+	g.roAdd(fmt.Sprintf("%v:", g.stackMap(fn)))
+	g.roAdd(fmt.Sprintf(".long %v", strings.Join(append([]string{fmt.Sprint(len(offsets))}, offsets...), ", ")))
+}
+
+// Layout gives the label of the layout of the objects allocated by a NEW.
+func (g *x86Gen) Layout(layout *il.Layout) string {
+	words := []string{fmt.Sprint(len(layout.Words)), fmt.Sprint(bit(layout.Elems))}
+	for _, p := range layout.Words {
+		words = append(words, fmt.Sprint(bit(p)))
+	}
+	name := fmt.Sprintf("layout_%d", len(g.rodata))
+	g.roAdd(fmt.Sprintf("%v:", name))
+	g.roAdd(fmt.Sprintf(".long %v", strings.Join(words, ", ")))
+	return name
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func (g *x86Gen) FnPop(fn *il.Func) {
+	g.Add("pushl %eax")
+	g.Add(fmt.Sprintf("call %v", builtins.Leave))
+	g.Add("popl %eax")
 	g.Add("movl -4(%ebp), %ebx")
 	g.Add(fmt.Sprintf("movl %%ebx, display_%d", fn.Scope))
+	// the code after a return is still in the frame
+	g.Direct(".cfi_remember_state")
 	g.Add("movl %ebp, %esp")
+	g.Direct(".cfi_def_cfa %esp, 8")
 	g.Add("movl (%esp), %ebp")
+	g.Direct(".cfi_restore %ebp")
 	g.Add("addl $4, %esp")
+	g.Direct(".cfi_def_cfa_offset 4")
 	g.Add("ret")
+	g.Direct(".cfi_restore_state")
 }
 
 func (g *x86Gen) Block(blk *il.Block) error {
 	g.Label(blk.Name)
 	for _, i := range blk.Insts {
+		g.Loc(i.Loc)
 		if err := g.Instruction(i); err != nil {
 			return err
 		}
@@ -265,6 +330,13 @@ func (g *x86Gen) Instruction(i *il.Inst) error {
 	case il.Ops["IFLE"]: return g.IF(i)
 	case il.Ops["IFGT"]: return g.IF(i)
 	case il.Ops["IFGE"]: return g.IF(i)
+	case il.Ops["NEW"]: return g.NEW(i)
+	case il.Ops["GET"]: return g.GET(i)
+	case il.Ops["PUT"]: return g.PUT(i)
+	case il.Ops["ITOF"]: return g.ITOF(i)
+	case il.Ops["FTOI"]: return g.FTOI(i)
+	case il.Ops["ITOS"]: return g.ToString(i, "int_to_string")
+	case il.Ops["FTOS"]: return g.ToString(i, "float_to_string")
 	}
 	return fmt.Errorf("unknown opcode %v", i)
 }
@@ -400,3 +472,73 @@ func (g *x86Gen) IF(i *il.Inst) error {
 	return nil
 }
 
+// Floats are held as single precision in a word, so the x87 unit converts
+// them through the top of the stack.
+func (g *x86Gen) ITOF(i *il.Inst) error {
+	g.Load(i.A, "eax")
+	g.Add("pushl %eax")
+	g.Add("fildl (%esp)")
+	g.Add("fstps (%esp)")
+	g.Add("popl %eax")
+	g.Store("eax", i.R)
+	return nil
+}
+
+func (g *x86Gen) FTOI(i *il.Inst) error {
+	g.Load(i.A, "eax")
+	g.Add("pushl %eax")
+	g.Add("flds (%esp)")
+	g.Add("fisttpl (%esp)")
+	g.Add("popl %eax")
+	g.Store("eax", i.R)
+	return nil
+}
+
+// ToString calls the builtin which formats the number in the runtime.
+func (g *x86Gen) ToString(i *il.Inst, builtin string) error {
+	g.PUSH(i.A)
+	g.Add(fmt.Sprintf("call %v", builtins.Lookup(builtin).Native))
+	g.Add("addl $4, %esp")
+	g.Store("eax", i.R)
+	return nil
+}
+
+// NEW allocates the object with the collector, which may collect first.
+func (g *x86Gen) NEW(i *il.Inst) error {
+	g.Add(fmt.Sprintf("pushl $%v", g.Layout(i.B.Value.(*il.Layout))))
+	g.PUSH(i.A)
+	g.Add(fmt.Sprintf("call %v", builtins.Alloc))
+	g.Add("addl $8, %esp")
+	g.Store("eax", i.R)
+	return nil
+}
+
+func (g *x86Gen) GET(i *il.Inst) error {
+	g.Load(i.A, "ecx")
+	g.Add(fmt.Sprintf("movl %v, %%eax", g.Word(i.B)))
+	g.Store("eax", i.R)
+	return nil
+}
+
+func (g *x86Gen) PUT(i *il.Inst) error {
+	g.Load(i.A, "eax")
+	g.Load(i.R, "ecx")
+	g.Add(fmt.Sprintf("movl %%eax, %v", g.Word(i.B)))
+	return nil
+}
+
+// Word gives the word at the offset in the object ecx points to. The
+// offset is an (offset, length) pair or in a register.
+func (g *x86Gen) Word(o *il.Operand) string {
+	switch off := o.Value.(type) {
+	case *il.OffsetLength:
+		if off.Length != 4 {
+			panic(fmt.Errorf("only words can be moved got %v", off))
+		}
+		return fmt.Sprintf("%d(%%ecx)", off.Offset)
+	case *il.Register:
+		g.Load(o, "edx")
+		return "(%ecx,%edx)"
+	}
+	panic(fmt.Errorf("unexpected offset %v", o))
+}
@@ -0,0 +1,210 @@
+package x86
+
+import (
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/timtadh/tcel/frontend"
+	"github.com/timtadh/tcel/il"
+)
+
+func generate(t *testing.T, src string) string {
+	tokens, err := frontend.Lex(src, "test.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns, err := il.Generate(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm, err := Generate(fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asm
+}
+
+func TestCollectorRoots(t *testing.T) {
+	asm := generate(t, `
+n = 1
+p = record { x: n, next: (2, new [3]int) }
+n + 1
+`)
+	lines := strings.Split(asm, "\n")
+	found := false
+	for i, line := range lines {
+		if line == "main_stack_map:" {
+			found = true
+			// the array, the tuple and the record are pointers, the sum
+			// of n and 1 is not
+			if got := lines[i+1]; got != ".long 3, -8, -12, -16" {
+				t.Errorf("unexpected stack map %v", got)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected a stack map for main in\n%v", asm)
+	}
+	for _, expected := range []string{
+		"call tcel_gc_enter",
+		"call tcel_alloc",
+		// the record, its first field is an int and its second a pointer
+		".long 2, 0, 0, 1",
+		// the array, its length then its elements which are ints
+		".long 1, 0, 0",
+	} {
+		if !strings.Contains(asm, expected) {
+			t.Errorf("expected %v in\n%v", expected, asm)
+		}
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	asm := generate(t, `
+f = fn(n int) int {
+	if n < 2 {
+		n
+	} else {
+		self(n-1) + n
+	}
+}
+f(10)
+`)
+	if strings.Count(asm, `.file 1 "test.x"`) != 1 {
+		t.Errorf("expected the file to be named once in\n%v", asm)
+	}
+	for _, expected := range []string{
+		".cfi_startproc",
+		".cfi_def_cfa_register %ebp",
+		".cfi_endproc",
+		// the condition, each branch and the call in main
+		".loc 1 3 5",
+		".loc 1 4 3",
+		".loc 1 6 3",
+		".loc 1 9 1",
+	} {
+		if !strings.Contains(asm, expected) {
+			t.Errorf("expected %v in\n%v", expected, asm)
+		}
+	}
+}
+
+func TestFunctionOrder(t *testing.T) {
+	src := `
+a = fn(x int) int { x + 1 }
+b = fn(x int) int { a(x) * 2 }
+c = fn(x int) int { b(x) - a(x) }
+d = fn(x int) int { c(x) + b(x) }
+d(1)
+`
+	// the functions are kept in a map, they must be written in the same
+	// order each time for the assembly to be the same
+	first := generate(t, src)
+	for i := 0; i < 10; i++ {
+		if asm := generate(t, src); asm != first {
+			t.Fatalf("expected the same assembly each time got\n%v\nthen\n%v", first, asm)
+		}
+	}
+}
+
+// follows reports whether the instructions are consecutive lines of asm.
+func follows(asm string, instructions ...string) bool {
+	lines := strings.Split(asm, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	for i := 0; i+len(instructions) <= len(lines); i++ {
+		match := true
+		for j, inst := range instructions {
+			if lines[i+j] != inst {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConversions(t *testing.T) {
+	asm := generate(t, `
+f = fn(i int) int {
+	x = float(i)
+	print(string(i))
+	print(string(x))
+	int(x)
+}
+f(3)
+`)
+	for name, expected := range map[string][]string{
+		// the x87 unit loads the int and stores it as a single
+		"ITOF": {"pushl %eax", "fildl (%esp)", "fstps (%esp)", "popl %eax"},
+		// and loads the single and stores it truncated as an int
+		"FTOI": {"pushl %eax", "flds (%esp)", "fisttpl (%esp)", "popl %eax"},
+		"ITOS": {"call tcel_int_to_string", "addl $4, %esp"},
+		"FTOS": {"call tcel_float_to_string", "addl $4, %esp"},
+	} {
+		if !follows(asm, expected...) {
+			t.Errorf("expected %v to give\n%v\nin\n%v", name, strings.Join(expected, "\n"), asm)
+		}
+	}
+}
+
+func TestFloatConstants(t *testing.T) {
+	asm := generate(t, `
+f = fn(i int) int {
+	x = float(i)
+	print(string(x))
+	print(string(1.5))
+	int(2.5) + int(x)
+}
+f(3)
+`)
+	// floats are held as the bits of their single precision value, 1.5 is
+	// 0x3fc00000 and 2.5 is 0x40200000
+	for name, expected := range map[string][]string{
+		"ITOF": {"pushl %eax", "fildl (%esp)", "fstps (%esp)", "popl %eax"},
+		"FTOS": {"pushl $1069547520", "call tcel_float_to_string"},
+		"FTOI": {"movl $1075838976, %eax", "pushl %eax", "flds (%esp)", "fisttpl (%esp)", "popl %eax"},
+	} {
+		if !follows(asm, expected...) {
+			t.Errorf("expected %v to give\n%v\nin\n%v", name, strings.Join(expected, "\n"), asm)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tokens, err := frontend.Lex("x = 1\nprint_int(x)\n", "test.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := frontend.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns, err := il.Generate(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there are no boolean values so the back end has no constant for one
+	for _, fn := range fns {
+		for _, blk := range fn.BlockList {
+			for _, i := range blk.Insts {
+				if args, is := i.B.Value.(*il.CallArgs); is {
+					args.Operands[0] = il.Const(true)
+				}
+			}
+		}
+	}
+	if _, err := Generate(fns); err == nil || err.Error() != "not yet supported" {
+		t.Errorf("expected not yet supported got %v", err)
+	}
+}